@@ -0,0 +1,108 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"fmt"
+	"io"
+)
+
+// arm64FilterID is the ID of the ARM64 BCJ filter.
+const arm64FilterID = 0x0a
+
+// arm64Filter declares the ARM64 BCJ filter information stored in an xz
+// block header. The filter converts the relative targets of the BL
+// instructions and the page addresses of the ADRP instructions into
+// absolute addresses. It requires XZ Utils 5.4.0 or later.
+type arm64Filter struct {
+	start uint32
+}
+
+// String returns a representation of the ARM64 filter.
+func (f arm64Filter) String() string {
+	return fmt.Sprintf("ARM64 start offset %#x", f.start)
+}
+
+// id returns the ID for the ARM64 filter.
+func (f arm64Filter) id() uint64 { return arm64FilterID }
+
+// MarshalBinary converts the arm64Filter in its encoded representation.
+func (f arm64Filter) MarshalBinary() (data []byte, err error) {
+	return marshalBCJ(arm64FilterID, f.start), nil
+}
+
+// UnmarshalBinary unmarshals the given data representation of the ARM64
+// filter.
+func (f *arm64Filter) UnmarshalBinary(data []byte) error {
+	start, err := unmarshalBCJ(arm64FilterID, "ARM64", 4, data)
+	if err != nil {
+		return err
+	}
+	f.start = start
+	return nil
+}
+
+// reader creates a new reader for the ARM64 filter.
+func (f arm64Filter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
+	err error) {
+	return newBCJReader(r, &arm64Coder{ip: f.start}), nil
+}
+
+// writeCloser creates a io.WriteCloser for the ARM64 filter.
+func (f arm64Filter) writeCloser(w io.WriteCloser, c *WriterConfig,
+) (fw io.WriteCloser, err error) {
+	return newBCJWriter(w, &arm64Coder{ip: f.start, encoder: true}), nil
+}
+
+// last returns false, because the ARM64 filter must be followed by
+// another filter.
+func (f arm64Filter) last() bool { return false }
+
+// arm64Coder converts the BL and ADRP instructions of the ARM64
+// instruction set, which are aligned to 4 bytes.
+type arm64Coder struct {
+	ip      uint32
+	encoder bool
+}
+
+// code converts the BL and ADRP instructions in p.
+func (c *arm64Coder) code(p []byte) int {
+	i := 0
+	for ; i+4 <= len(p); i += 4 {
+		pc := c.ip + uint32(i)
+		instr := uint32LE(p[i:])
+		switch {
+		case instr>>26 == 0x25:
+			// BL with a 26-bit word offset
+			pc >>= 2
+			if !c.encoder {
+				pc = -pc
+			}
+			instr = 0x94000000 | (instr+pc)&0x03ffffff
+		case instr&0x9f000000 == 0x90000000:
+			// ADRP with a 21-bit offset of 4 KiB pages
+			src := (instr>>29)&3 | (instr>>3)&0x001ffffc
+			// Only offsets in the range of +-512 MiB are
+			// converted.
+			if (src+0x00020000)&0x001c0000 != 0 {
+				continue
+			}
+			pc >>= 12
+			if !c.encoder {
+				pc = -pc
+			}
+			dest := src + pc
+			instr &= 0x9000001f
+			instr |= (dest & 3) << 29
+			instr |= (dest & 0x0003fffc) << 3
+			instr |= -(dest & 0x00020000) & 0x00e00000
+		default:
+			continue
+		}
+		putUint32LE(p[i:], instr)
+	}
+	c.ip += uint32(i)
+	return i
+}
@@ -0,0 +1,57 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// arm64Code generates n bytes resembling ARM64 machine code with many
+// BL and ADRP instructions.
+func arm64Code(n int, seed int64) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	p := make([]byte, n)
+	for i := 0; i+4 <= n; i += 4 {
+		var instr uint32
+		switch rng.Intn(8) {
+		case 0, 1:
+			// BL with a near offset
+			d := uint32(rng.Intn(1<<14) - 1<<13)
+			instr = 0x94000000 | d&0x03ffffff
+		case 2:
+			// ADRP with near and far page offsets
+			d := uint32(rng.Intn(1<<8) - 1<<7)
+			if rng.Intn(4) == 0 {
+				d = uint32(rng.Intn(1 << 21))
+			}
+			instr = 0x90000000 | (d&3)<<29 | (d>>2&0x7ffff)<<5 |
+				uint32(rng.Intn(31))
+		default:
+			// MOVZ
+			instr = 0xd2800000 | uint32(rng.Intn(1<<21))
+		}
+		putUint32LE(p[i:], instr)
+	}
+	return p
+}
+
+func TestARM64FilterFixture(t *testing.T) {
+	testBCJFixture(t, "testdata/arm64.xz", &arm64Filter{},
+		arm64Code(1<<14, 1))
+}
+
+func TestARM64FilterRoundTrip(t *testing.T) {
+	data := arm64Code(100003, 2)
+	testBCJRoundTrip(t, WriterConfig{ARM64: true}, data)
+	testBCJRoundTrip(t, WriterConfig{ARM64: true, BlockSize: 7001}, data)
+}
+
+func TestWriterConfigTooManyBCJFilters(t *testing.T) {
+	cfg := WriterConfig{X86: true, ARM: true, ARMThumb: true, ARM64: true}
+	if err := cfg.Verify(); err != errTooManyFilters {
+		t.Fatalf("Verify returned %v; want %v", err, errTooManyFilters)
+	}
+}
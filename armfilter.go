@@ -0,0 +1,179 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"fmt"
+	"io"
+)
+
+// IDs of the ARM BCJ filters.
+const (
+	armFilterID      = 0x07
+	armThumbFilterID = 0x08
+)
+
+// armFilter declares the ARM BCJ filter information stored in an xz
+// block header. The filter converts the relative targets of the BL
+// instructions into absolute addresses.
+type armFilter struct {
+	start uint32
+}
+
+// String returns a representation of the ARM filter.
+func (f armFilter) String() string {
+	return fmt.Sprintf("ARM start offset %#x", f.start)
+}
+
+// id returns the ID for the ARM filter.
+func (f armFilter) id() uint64 { return armFilterID }
+
+// MarshalBinary converts the armFilter in its encoded representation.
+func (f armFilter) MarshalBinary() (data []byte, err error) {
+	return marshalBCJ(armFilterID, f.start), nil
+}
+
+// UnmarshalBinary unmarshals the given data representation of the ARM
+// filter.
+func (f *armFilter) UnmarshalBinary(data []byte) error {
+	start, err := unmarshalBCJ(armFilterID, "ARM", 4, data)
+	if err != nil {
+		return err
+	}
+	f.start = start
+	return nil
+}
+
+// reader creates a new reader for the ARM filter.
+func (f armFilter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
+	err error) {
+	return newBCJReader(r, &armCoder{ip: f.start}), nil
+}
+
+// writeCloser creates a io.WriteCloser for the ARM filter.
+func (f armFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
+) (fw io.WriteCloser, err error) {
+	return newBCJWriter(w, &armCoder{ip: f.start, encoder: true}), nil
+}
+
+// last returns false, because the ARM filter must be followed by
+// another filter.
+func (f armFilter) last() bool { return false }
+
+// armCoder converts the BL instructions of the ARM instruction set,
+// which are aligned to 4 bytes.
+type armCoder struct {
+	ip      uint32
+	encoder bool
+}
+
+// code converts the BL instructions in p.
+func (c *armCoder) code(p []byte) int {
+	i := 0
+	for ; i+4 <= len(p); i += 4 {
+		if p[i+3] != 0xeb {
+			continue
+		}
+		src := (uint32(p[i]) | uint32(p[i+1])<<8 |
+			uint32(p[i+2])<<16) << 2
+		pc := c.ip + uint32(i) + 8
+		var dest uint32
+		if c.encoder {
+			dest = src + pc
+		} else {
+			dest = src - pc
+		}
+		dest >>= 2
+		p[i+2] = byte(dest >> 16)
+		p[i+1] = byte(dest >> 8)
+		p[i] = byte(dest)
+	}
+	c.ip += uint32(i)
+	return i
+}
+
+// armThumbFilter declares the ARM-Thumb BCJ filter information stored
+// in an xz block header. The filter converts the relative targets of
+// the BL instruction pairs of the Thumb instruction set into absolute
+// addresses.
+type armThumbFilter struct {
+	start uint32
+}
+
+// String returns a representation of the ARM-Thumb filter.
+func (f armThumbFilter) String() string {
+	return fmt.Sprintf("ARM-Thumb start offset %#x", f.start)
+}
+
+// id returns the ID for the ARM-Thumb filter.
+func (f armThumbFilter) id() uint64 { return armThumbFilterID }
+
+// MarshalBinary converts the armThumbFilter in its encoded
+// representation.
+func (f armThumbFilter) MarshalBinary() (data []byte, err error) {
+	return marshalBCJ(armThumbFilterID, f.start), nil
+}
+
+// UnmarshalBinary unmarshals the given data representation of the
+// ARM-Thumb filter.
+func (f *armThumbFilter) UnmarshalBinary(data []byte) error {
+	start, err := unmarshalBCJ(armThumbFilterID, "ARM-Thumb", 2, data)
+	if err != nil {
+		return err
+	}
+	f.start = start
+	return nil
+}
+
+// reader creates a new reader for the ARM-Thumb filter.
+func (f armThumbFilter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
+	err error) {
+	return newBCJReader(r, &armThumbCoder{ip: f.start}), nil
+}
+
+// writeCloser creates a io.WriteCloser for the ARM-Thumb filter.
+func (f armThumbFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
+) (fw io.WriteCloser, err error) {
+	return newBCJWriter(w, &armThumbCoder{ip: f.start, encoder: true}),
+		nil
+}
+
+// last returns false, because the ARM-Thumb filter must be followed by
+// another filter.
+func (f armThumbFilter) last() bool { return false }
+
+// armThumbCoder converts the BL instruction pairs of the Thumb
+// instruction set, which are aligned to 2 bytes.
+type armThumbCoder struct {
+	ip      uint32
+	encoder bool
+}
+
+// code converts the BL instruction pairs in p.
+func (c *armThumbCoder) code(p []byte) int {
+	i := 0
+	for ; i+4 <= len(p); i += 2 {
+		if p[i+1]&0xf8 != 0xf0 || p[i+3]&0xf8 != 0xf8 {
+			continue
+		}
+		src := (uint32(p[i+1]&7)<<19 | uint32(p[i])<<11 |
+			uint32(p[i+3]&7)<<8 | uint32(p[i+2])) << 1
+		pc := c.ip + uint32(i) + 4
+		var dest uint32
+		if c.encoder {
+			dest = src + pc
+		} else {
+			dest = src - pc
+		}
+		dest >>= 1
+		p[i+1] = 0xf0 | byte(dest>>19)&7
+		p[i] = byte(dest >> 11)
+		p[i+3] = 0xf8 | byte(dest>>8)&7
+		p[i+2] = byte(dest)
+		i += 2
+	}
+	c.ip += uint32(i)
+	return i
+}
@@ -0,0 +1,84 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// armCode generates n bytes resembling ARM machine code with many BL
+// instructions.
+func armCode(n int, seed int64) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	p := make([]byte, n)
+	for i := 0; i+4 <= n; i += 4 {
+		if rng.Intn(4) == 0 {
+			d := rng.Intn(1 << 12)
+			p[i], p[i+1], p[i+2], p[i+3] = byte(d), byte(d>>8),
+				0, 0xeb
+			continue
+		}
+		p[i], p[i+1] = byte(rng.Intn(256)), byte(rng.Intn(16))
+		p[i+2], p[i+3] = byte(rng.Intn(16)), 0xe0+byte(rng.Intn(4))
+	}
+	return p
+}
+
+// armThumbCode generates n bytes resembling Thumb machine code with many
+// BL instruction pairs.
+func armThumbCode(n int, seed int64) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	p := make([]byte, n)
+	for i := 0; i+2 <= n; i += 2 {
+		if i+4 <= n && rng.Intn(6) == 0 {
+			d := rng.Intn(1 << 12)
+			p[i], p[i+1] = 0, 0xf0
+			p[i+2], p[i+3] = byte(d), 0xf8|byte(d>>8)&7
+			i += 2
+			continue
+		}
+		p[i], p[i+1] = byte(rng.Intn(256)), byte(rng.Intn(0x70))
+	}
+	return p
+}
+
+func TestARMFilterFixture(t *testing.T) {
+	testBCJFixture(t, "testdata/arm.xz", &armFilter{}, armCode(1<<14, 1))
+}
+
+func TestARMThumbFilterFixture(t *testing.T) {
+	testBCJFixture(t, "testdata/armthumb.xz", &armThumbFilter{},
+		armThumbCode(1<<14, 1))
+}
+
+func TestARMFilterRoundTrip(t *testing.T) {
+	data := armCode(100002, 2)
+	testBCJRoundTrip(t, WriterConfig{ARM: true}, data)
+	testBCJRoundTrip(t, WriterConfig{ARM: true, BlockSize: 7001}, data)
+	data = armThumbCode(100001, 2)
+	testBCJRoundTrip(t, WriterConfig{ARMThumb: true}, data)
+	testBCJRoundTrip(t, WriterConfig{ARMThumb: true, BlockSize: 7001},
+		data)
+	testBCJRoundTrip(t, WriterConfig{X86: true, ARM: true,
+		ARMThumb: true}, data)
+}
+
+func TestARMFilterNotLast(t *testing.T) {
+	for _, f := range []filter{&armFilter{}, &armThumbFilter{}} {
+		if f.last() {
+			t.Errorf("%T.last() returned true", f)
+		}
+		if err := verifyFilters([]filter{f}); err != errWrongLastFilter {
+			t.Errorf("verifyFilters returned %v; want %v", err,
+				errWrongLastFilter)
+		}
+	}
+	// The start offset of the ARM filter must be aligned.
+	var f armFilter
+	if err := f.UnmarshalBinary([]byte{armFilterID, 4, 2, 0, 0, 0}); err == nil {
+		t.Errorf("unaligned start offset accepted")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"io"
+	"time"
+)
+
+// Limits for the batch size of the adaptive batch writer. The maximum
+// is used if OutputBatchSize is zero.
+const (
+	minAdaptiveBatch = 4 * 1024
+	maxAdaptiveBatch = 1024 * 1024
+)
+
+// defaultOutputBatch is the batch size selected by BufferOutput.
+const defaultOutputBatch = 64 * 1024
+
+// batchWriter coalesces writes to an underlying writer. The data is
+// written if the batch is full or Flush is called.
+type batchWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// adaptiveBatchWriter is a batch writer that adapts the batch size to
+// the speed of the underlying writer. If writing a batch takes longer
+// than producing it, the batch size is halved to reduce the latency;
+// otherwise it is doubled to reduce the overhead of the writes.
+type adaptiveBatchWriter struct {
+	w    io.Writer
+	buf  []byte
+	size int
+	min  int
+	max  int
+	// time the last batch has been written
+	last time.Time
+}
+
+// newAdaptiveBatchWriter creates an adaptive batch writer that starts
+// with the minimum batch size.
+func newAdaptiveBatchWriter(w io.Writer, min, max int) *adaptiveBatchWriter {
+	if max < min {
+		max = min
+	}
+	return &adaptiveBatchWriter{
+		w:    w,
+		buf:  make([]byte, 0, min),
+		size: min,
+		min:  min,
+		max:  max,
+		last: time.Now(),
+	}
+}
+
+// Write adds the data to the batch and writes full batches.
+func (b *adaptiveBatchWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		k := b.size - len(b.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		b.buf = append(b.buf, p[:k]...)
+		n += k
+		p = p[k:]
+		if len(b.buf) >= b.size {
+			if err = b.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes the current batch and adapts the batch size.
+func (b *adaptiveBatchWriter) Flush() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	start := time.Now()
+	_, err := b.w.Write(b.buf)
+	end := time.Now()
+	if err != nil {
+		return err
+	}
+	if end.Sub(start) > start.Sub(b.last) {
+		if b.size /= 2; b.size < b.min {
+			b.size = b.min
+		}
+	} else {
+		if b.size *= 2; b.size > b.max {
+			b.size = b.max
+		}
+	}
+	b.last = end
+	b.buf = b.buf[:0]
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"fmt"
+	"io"
+)
+
+// bcjCoder converts the branch targets of machine instructions between
+// relative and absolute addresses. The BCJ filters of the xz format
+// don't change the length of the data and only need a small look-ahead,
+// which is provided by the bcjReader and bcjWriter.
+type bcjCoder interface {
+	// code converts the instructions in p and returns the number
+	// of bytes processed. The remaining bytes might be the start of
+	// an instruction and must be provided again in the next call
+	// followed by more data. The coder tracks the position of the
+	// data in the stream.
+	code(p []byte) int
+}
+
+// bcjBufSize is the size of the buffers used by bcjReader and
+// bcjWriter.
+const bcjBufSize = 4096
+
+// marshalBCJ encodes the filter flags of a BCJ filter. The start offset
+// is only stored if it is not zero.
+func marshalBCJ(id uint64, start uint32) []byte {
+	if start == 0 {
+		return []byte{byte(id), 0}
+	}
+	data := []byte{byte(id), 4, 0, 0, 0, 0}
+	putUint32LE(data[2:], start)
+	return data
+}
+
+// unmarshalBCJ decodes the filter flags of the BCJ filter with the
+// given ID and returns the start offset, which must be a multiple of
+// align.
+func unmarshalBCJ(id uint64, name string, align uint32, data []byte,
+) (start uint32, err error) {
+	if len(data) < 2 {
+		return 0, fmt.Errorf("xz: data for %s filter too short", name)
+	}
+	if uint64(data[0]) != id {
+		return 0, fmt.Errorf("xz: wrong %s filter id", name)
+	}
+	switch data[1] {
+	case 0:
+		if len(data) != 2 {
+			return 0, fmt.Errorf(
+				"xz: data for %s filter has wrong length", name)
+		}
+		return 0, nil
+	case 4:
+		if len(data) != 6 {
+			return 0, fmt.Errorf(
+				"xz: data for %s filter has wrong length", name)
+		}
+		start = uint32LE(data[2:])
+		if start%align != 0 {
+			return 0, fmt.Errorf(
+				"xz: %s filter start offset not aligned", name)
+		}
+		return start, nil
+	}
+	return 0, fmt.Errorf("xz: wrong %s filter size", name)
+}
+
+// bcjReader decodes the data read from the underlying reader.
+type bcjReader struct {
+	r     io.Reader
+	coder bcjCoder
+	buf   []byte
+	// buf[start:conv] has been decoded, buf[conv:end] not yet
+	start, conv, end int
+	err              error
+}
+
+// newBCJReader creates a new reader decoding the data read from r with
+// the given coder.
+func newBCJReader(r io.Reader, coder bcjCoder) *bcjReader {
+	return &bcjReader{r: r, coder: coder, buf: make([]byte, bcjBufSize)}
+}
+
+// Read reads decoded data.
+func (br *bcjReader) Read(p []byte) (n int, err error) {
+	for {
+		if br.start < br.conv {
+			n = copy(p, br.buf[br.start:br.conv])
+			br.start += n
+			return n, nil
+		}
+		if br.err != nil {
+			return 0, br.err
+		}
+		copy(br.buf, br.buf[br.start:br.end])
+		br.end -= br.start
+		br.start, br.conv = 0, 0
+		k, err := br.r.Read(br.buf[br.end:])
+		br.end += k
+		br.conv = br.coder.code(br.buf[:br.end])
+		if err != nil {
+			if err == io.EOF {
+				// The tail can't contain a complete
+				// instruction and is passed unchanged.
+				br.conv = br.end
+			}
+			br.err = err
+		}
+	}
+}
+
+// bcjWriter encodes the data written and writes it to the underlying
+// writer.
+type bcjWriter struct {
+	w      io.WriteCloser
+	coder  bcjCoder
+	buf    []byte
+	n      int
+	closed bool
+}
+
+// newBCJWriter creates a new writer encoding the data with the given
+// coder.
+func newBCJWriter(w io.WriteCloser, coder bcjCoder) *bcjWriter {
+	return &bcjWriter{w: w, coder: coder, buf: make([]byte, bcjBufSize)}
+}
+
+// Write encodes p and writes the encoded data to the underlying writer.
+// A few bytes are kept back until more data is written or the writer is
+// closed.
+func (bw *bcjWriter) Write(p []byte) (n int, err error) {
+	if bw.closed {
+		return 0, errClosed
+	}
+	for len(p) > 0 {
+		k := copy(bw.buf[bw.n:], p)
+		bw.n += k
+		c := bw.coder.code(bw.buf[:bw.n])
+		if _, err = bw.w.Write(bw.buf[:c]); err != nil {
+			return n, err
+		}
+		copy(bw.buf, bw.buf[c:bw.n])
+		bw.n -= c
+		n += k
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// Close writes the bytes kept back unchanged and closes the underlying
+// writer.
+func (bw *bcjWriter) Close() error {
+	if bw.closed {
+		return errClosed
+	}
+	bw.closed = true
+	if _, err := bw.w.Write(bw.buf[:bw.n]); err != nil {
+		return err
+	}
+	return bw.w.Close()
+}
@@ -9,6 +9,32 @@ import (
 	"io"
 )
 
+// maxInt is the maximum value of the int type, which has only 32 bits
+// on 32-bit platforms.
+const maxInt = int(^uint(0) >> 1)
+
+// errSizeOverflow indicates that a size computed from the sizes stored
+// in the xz data cannot be represented.
+var errSizeOverflow = errors.New("xz: size overflow")
+
+// addSize adds the non-negative sizes a and b. It returns
+// errSizeOverflow if the sum exceeds the maximum int64 value.
+func addSize(a, b int64) (int64, error) {
+	if b > maxInt64-a {
+		return 0, errSizeOverflow
+	}
+	return a + b, nil
+}
+
+// intSize converts the size n to an int. It returns errSizeOverflow if
+// the value doesn't fit, which may happen on 32-bit platforms.
+func intSize(n uint64) (int, error) {
+	if n > uint64(maxInt) {
+		return 0, errSizeOverflow
+	}
+	return int(n), nil
+}
+
 // putUint32LE puts the little-endian representation of x into the first
 // four bytes of p.
 func putUint32LE(p []byte, x uint32) {
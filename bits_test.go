@@ -42,3 +42,33 @@ func TestUvarIntCVE_2020_16845(t *testing.T) {
 		t.Fatalf("readUvarint overflow not detected")
 	}
 }
+
+func TestAddSize(t *testing.T) {
+	tests := []struct {
+		a, b, sum int64
+		err       error
+	}{
+		{1, 2, 3, nil},
+		{maxInt64 - 1, 1, maxInt64, nil},
+		{maxInt64, 1, 0, errSizeOverflow},
+		{1 << 62, 1 << 62, 0, errSizeOverflow},
+	}
+	for _, tc := range tests {
+		sum, err := addSize(tc.a, tc.b)
+		if err != tc.err || sum != tc.sum {
+			t.Errorf("addSize(%d, %d) = %d, %v; want %d, %v",
+				tc.a, tc.b, sum, err, tc.sum, tc.err)
+		}
+	}
+}
+
+func TestIntSize(t *testing.T) {
+	n, err := intSize(uint64(maxInt))
+	if err != nil || n != maxInt {
+		t.Fatalf("intSize(%d) = %d, %v", maxInt, n, err)
+	}
+	if _, err = intSize(uint64(maxInt) + 1); err != errSizeOverflow {
+		t.Fatalf("intSize(%d) returned error %v; want %v",
+			uint64(maxInt)+1, err, errSizeOverflow)
+	}
+}
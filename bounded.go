@@ -0,0 +1,59 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// DecompressBounded decompresses the xz data from src and returns the
+// result as io.ReadSeeker. Up to memLimit bytes the decompressed data
+// is kept in memory; larger results are written to a temporary file.
+// The returned cleanup function releases the temporary file and must
+// be called after the data has been read.
+func DecompressBounded(src io.Reader, memLimit int64,
+) (rs io.ReadSeeker, cleanup func(), err error) {
+	if memLimit < 0 {
+		return nil, nil, errors.New("xz: negative memory limit")
+	}
+	r, err := NewReader(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, memLimit+1)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	if n <= memLimit {
+		return bytes.NewReader(buf.Bytes()), func() {}, nil
+	}
+
+	f, err := ioutil.TempFile("", "xz-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err = buf.WriteTo(f); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return f, cleanup, nil
+}
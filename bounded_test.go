@@ -0,0 +1,72 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestDecompressBounded(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(48)), 50000)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+
+	tests := []struct {
+		memLimit int64
+		file     bool
+	}{
+		{memLimit: 1 << 20, file: false},
+		{memLimit: int64(txt.Len()), file: false},
+		{memLimit: int64(txt.Len()) - 1, file: true},
+		{memLimit: 0, file: true},
+	}
+	for _, tc := range tests {
+		rs, cleanup, err := DecompressBounded(
+			bytes.NewReader(buf.Bytes()), tc.memLimit)
+		if err != nil {
+			t.Fatalf("DecompressBounded(%d) error %s",
+				tc.memLimit, err)
+		}
+		f, ok := rs.(*os.File)
+		if ok != tc.file {
+			t.Fatalf("memLimit %d: temporary file used %t; want %t",
+				tc.memLimit, ok, tc.file)
+		}
+		if _, err = rs.Seek(1000, io.SeekStart); err != nil {
+			t.Fatalf("Seek error %s", err)
+		}
+		p, err := ioutil.ReadAll(rs)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(p, txt.Bytes()[1000:]) {
+			t.Fatalf("memLimit %d: data differs", tc.memLimit)
+		}
+		cleanup()
+		if ok {
+			if _, err = os.Stat(f.Name()); !os.IsNotExist(err) {
+				t.Fatalf("temporary file %s not removed",
+					f.Name())
+			}
+		}
+	}
+}
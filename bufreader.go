@@ -0,0 +1,43 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"io"
+)
+
+// BufReader provides the methods of bufio.Reader, including Peek,
+// UnreadByte and UnreadRune, for the decompressed data of an xz
+// stream.
+type BufReader struct {
+	*bufio.Reader
+	xz io.Reader
+}
+
+// NewBufReader creates a buffered reader for the decompressed data of
+// the xz stream using the default parameters.
+func NewBufReader(xz io.Reader) (br *BufReader, err error) {
+	return ReaderConfig{}.NewBufReader(xz)
+}
+
+// NewBufReader creates a buffered reader for the decompressed data of
+// the xz stream.
+func (c ReaderConfig) NewBufReader(xz io.Reader) (br *BufReader, err error) {
+	r, err := c.NewReader(xz)
+	if err != nil {
+		return nil, err
+	}
+	br = &BufReader{Reader: bufio.NewReader(r), xz: xz}
+	return br, nil
+}
+
+// Close closes the underlying xz reader if it implements io.Closer.
+func (br *BufReader) Close() error {
+	if c, ok := br.xz.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBufReader(t *testing.T) {
+	const file = "fox.xz"
+	const want = "The quick brown fox jumps over the lazy dog.\n"
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("os.Open(%q) error %s", file, err)
+	}
+	br, err := NewBufReader(f)
+	if err != nil {
+		t.Fatalf("NewBufReader error %s", err)
+	}
+	p, err := br.Peek(9)
+	if err != nil {
+		t.Fatalf("Peek error %s", err)
+	}
+	if string(p) != want[:9] {
+		t.Fatalf("Peek returned %q; want %q", p, want[:9])
+	}
+	c, err := br.ReadByte()
+	if err != nil {
+		t.Fatalf("ReadByte error %s", err)
+	}
+	if err = br.UnreadByte(); err != nil {
+		t.Fatalf("UnreadByte error %s", err)
+	}
+	if c != want[0] {
+		t.Fatalf("ReadByte returned %q; want %q", c, want[0])
+	}
+	var buf bytes.Buffer
+	if _, err = br.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo error %s", err)
+	}
+	if buf.String() != want {
+		t.Fatalf("got %q; want %q", buf.String(), want)
+	}
+	if err = br.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if _, err = ioutil.ReadAll(f); err == nil {
+		t.Fatalf("file %s not closed", file)
+	}
+}
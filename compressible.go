@@ -0,0 +1,77 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "math"
+
+// Parameters of the compressibility estimate.
+const (
+	// maximum sample size evaluated
+	maxSampleLen = 64 * 1024
+	// number of bits of the hash table for 4-byte sequences
+	sampleHashBits = 14
+	// estimated cost of a match in bits
+	sampleMatchBits = 24
+	// maximum match length of LZMA
+	sampleMaxMatchLen = 273
+)
+
+// Compressible estimates the compression ratio, the compressed size
+// divided by the uncompressed size, for the sample. The estimate finds
+// repeated byte sequences with a simple hash table and computes the
+// entropy of the remaining literals. It is much faster than compression
+// but only an estimate; the actual ratio achieved by the Writer is
+// usually lower for text. Values near 1.0 indicate data that isn't
+// worth compressing. Only the first 64 KiB of the sample are
+// evaluated. The function returns 1.0 for an empty sample.
+func Compressible(sample []byte) float64 {
+	if len(sample) == 0 {
+		return 1.0
+	}
+	if len(sample) > maxSampleLen {
+		sample = sample[:maxSampleLen]
+	}
+	var (
+		table   [1 << sampleHashBits]int32
+		freq    [256]int
+		lits    int
+		matches int
+	)
+	for i := 0; i < len(sample); {
+		if i+4 <= len(sample) {
+			u := uint32(sample[i]) | uint32(sample[i+1])<<8 |
+				uint32(sample[i+2])<<16 | uint32(sample[i+3])<<24
+			h := (u * 2654435761) >> (32 - sampleHashBits)
+			j := int(table[h]) - 1
+			table[h] = int32(i + 1)
+			if j >= 0 {
+				n := 0
+				for i+n < len(sample) && n < sampleMaxMatchLen &&
+					sample[j+n] == sample[i+n] {
+					n++
+				}
+				if n >= 4 {
+					matches++
+					i += n
+					continue
+				}
+			}
+		}
+		freq[sample[i]]++
+		lits++
+		i++
+	}
+	bits := float64(matches * sampleMatchBits)
+	for _, f := range freq {
+		if f > 0 {
+			bits -= float64(f) * math.Log2(float64(f)/float64(lits))
+		}
+	}
+	ratio := bits / 8 / float64(len(sample))
+	if ratio > 1.0 {
+		ratio = 1.0
+	}
+	return ratio
+}
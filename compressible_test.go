@@ -0,0 +1,43 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestCompressible(t *testing.T) {
+	random := make([]byte, 32*1024)
+	rand.New(rand.NewSource(57)).Read(random)
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(57)), 32*1024)
+	repetitive := []byte(strings.Repeat(
+		"The quick brown fox jumps over the lazy dog.\n", 1000))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		min, max float64
+	}{
+		{"empty", nil, 1.0, 1.0},
+		{"random", random, 0.95, 1.0},
+		{"text", txt.Bytes(), 0.3, 0.9},
+		{"repetitive", repetitive, 0, 0.1},
+	}
+	for _, tc := range tests {
+		r := Compressible(tc.data)
+		t.Logf("%s: estimated ratio %.3f", tc.name, r)
+		if !(tc.min <= r && r <= tc.max) {
+			t.Errorf("%s: ratio %.3f not in [%.2f, %.2f]", tc.name,
+				r, tc.min, tc.max)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 
@@ -32,10 +33,11 @@ func ExampleReader() {
 }
 
 func ExampleWriter() {
-	f, err := os.Create("example.xz")
+	f, err := ioutil.TempFile("", "example-*.xz")
 	if err != nil {
-		log.Fatalf("os.Open(%q) error %s", "example.xz", err)
+		log.Fatalf("ioutil.TempFile error %s", err)
 	}
+	defer os.Remove(f.Name())
 	defer f.Close()
 	w, err := xz.NewWriter(f)
 	if err != nil {
@@ -55,15 +55,33 @@ const (
 // errInvalidFlags indicates that flags are invalid.
 var errInvalidFlags = errors.New("xz: invalid flags")
 
+// maxCheckID is the largest check ID defined by the xz format. The
+// upper four bits of the stream flags byte are reserved.
+const maxCheckID = 0x0f
+
+// checkSize returns the size of the check for the given check ID. The
+// xz format defines the size for all check IDs including the ones not
+// supported by this package.
+func checkSize(id byte) int {
+	if id == None {
+		return 0
+	}
+	return 4 << ((id - 1) / 3)
+}
+
 // verifyFlags returns the error errInvalidFlags if the value is
-// invalid.
+// invalid. An error mentioning the check type is returned for check
+// IDs that are defined by the xz format but not supported.
 func verifyFlags(flags byte) error {
 	switch flags {
 	case None, CRC32, CRC64, SHA256:
 		return nil
-	default:
+	}
+	if flags > maxCheckID {
 		return errInvalidFlags
 	}
+	return fmt.Errorf("xz: unsupported check type 0x%02x (%d-byte check)",
+		flags, checkSize(flags))
 }
 
 // flagstrings maps flag values to strings.
@@ -96,7 +114,7 @@ func newHashFunc(flags byte) (newHash func() hash.Hash, err error) {
 	case SHA256:
 		newHash = sha256.New
 	default:
-		err = errInvalidFlags
+		err = verifyFlags(flags)
 	}
 	return
 }
@@ -117,6 +135,20 @@ func ValidHeader(data []byte) bool {
 	return err == nil
 }
 
+// Detect reports whether prefix starts with an xz stream. The prefix
+// must contain at least the six magic bytes. If the prefix contains
+// the complete stream header of HeaderLen bytes, the flags and the
+// CRC-32 of the header are checked as well.
+func Detect(prefix []byte) bool {
+	if len(prefix) < len(headerMagic) {
+		return false
+	}
+	if len(prefix) < HeaderLen {
+		return bytes.Equal(prefix[:len(headerMagic)], headerMagic)
+	}
+	return ValidHeader(prefix[:HeaderLen])
+}
+
 // String returns a string representation of the flags.
 func (h header) String() string {
 	return flagString(h.flags)
@@ -310,8 +342,41 @@ const (
 // instead of an expected block header indicator.
 var errIndexIndicator = errors.New("xz: found index indicator")
 
+// ErrBadBlockHeader indicates that the header size declared by the
+// first byte of a block header doesn't match its content, because the
+// fields of the header don't fit into the declared size. A declared
+// size that is too large is deliberately not reported: the additional
+// bytes are read as padding, which must be zero, but its length is not
+// limited, because encoders in the wild produce more padding than the
+// format allows. MarshalBinary returns the error for a header
+// exceeding the format limit of 1024 bytes. The parser doesn't need to
+// check the limits of 1024 bytes and four filters, because the size
+// byte and the two-bit filter count field cannot express larger values.
+var ErrBadBlockHeader = errors.New("xz: block header size inconsistent")
+
+// maxBlockHeaderLen is the maximum length of a block header defined by
+// the format.
+const maxBlockHeaderLen = 1024
+
 // readBlockHeader reads the block header.
 func readBlockHeader(r io.Reader) (h *blockHeader, n int, err error) {
+	data, n, err := readBlockHeaderData(r)
+	if err != nil {
+		return nil, n, err
+	}
+
+	// unmarshal block header
+	h = new(blockHeader)
+	if err = h.UnmarshalBinary(data); err != nil {
+		return nil, n, err
+	}
+
+	return h, n, nil
+}
+
+// readBlockHeaderData reads the complete block header without
+// unmarshalling it.
+func readBlockHeaderData(r io.Reader) (data []byte, n int, err error) {
 	var buf bytes.Buffer
 	buf.Grow(20)
 
@@ -334,14 +399,7 @@ func readBlockHeader(r io.Reader) (h *blockHeader, n int, err error) {
 	if err != nil {
 		return nil, n, err
 	}
-
-	// unmarshal block header
-	h = new(blockHeader)
-	if err = h.UnmarshalBinary(buf.Bytes()); err != nil {
-		return nil, n, err
-	}
-
-	return h, n, nil
+	return buf.Bytes(), n, nil
 }
 
 // readSizeInBlockHeader reads the uncompressed or compressed size
@@ -395,19 +453,19 @@ func (h *blockHeader) UnmarshalBinary(data []byte) error {
 	h.compressedSize, err = readSizeInBlockHeader(
 		r, flags&compressedSizePresent != 0)
 	if err != nil {
-		return err
+		return blockHeaderError(err)
 	}
 
 	// Uncompressed size
 	h.uncompressedSize, err = readSizeInBlockHeader(
 		r, flags&uncompressedSizePresent != 0)
 	if err != nil {
-		return err
+		return blockHeaderError(err)
 	}
 
 	h.filters, err = readFilters(r, int(flags&filterCountMask)+1)
 	if err != nil {
-		return err
+		return blockHeaderError(err)
 	}
 
 	// Check padding
@@ -427,24 +485,48 @@ func (h *blockHeader) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// blockHeaderError translates the end of the header data into
+// ErrBadBlockHeader, because the fields didn't fit into the declared
+// header size.
+func blockHeaderError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrBadBlockHeader
+	}
+	return err
+}
+
+// BlockHeader provides the size information of an xz block header.
+// The sizes are only valid if the respective Has field is set.
+type BlockHeader struct {
+	HasCompressedSize   bool
+	HasUncompressedSize bool
+	CompressedSize      int64
+	UncompressedSize    int64
+}
+
+// UnmarshalBinary decodes the block header in data, which must have
+// exactly the length given by the header size byte.
+func (h *BlockHeader) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("xz: block header data empty")
+	}
+	var bh blockHeader
+	if err := bh.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	*h = BlockHeader{
+		HasCompressedSize:   bh.compressedSize >= 0,
+		HasUncompressedSize: bh.uncompressedSize >= 0,
+		CompressedSize:      bh.compressedSize,
+		UncompressedSize:    bh.uncompressedSize,
+	}
+	return nil
+}
+
 // MarshalBinary marshals the binary header.
 func (h *blockHeader) MarshalBinary() (data []byte, err error) {
-	if !(minFilters <= len(h.filters) && len(h.filters) <= maxFilters) {
-		return nil, errors.New("xz: filter count wrong")
-	}
-	for i, f := range h.filters {
-		if i < len(h.filters)-1 {
-			if f.id() == lzmaFilterID {
-				return nil, errors.New(
-					"xz: LZMA2 filter is not the last")
-			}
-		} else {
-			// last filter
-			if f.id() != lzmaFilterID {
-				return nil, errors.New("xz: " +
-					"last filter must be the LZMA2 filter")
-			}
-		}
+	if err = verifyFilters(h.filters); err != nil {
+		return nil, err
 	}
 
 	var buf bytes.Buffer
@@ -491,8 +573,11 @@ func (h *blockHeader) MarshalBinary() (data []byte, err error) {
 	if len(data)%4 != 0 {
 		panic("data length not aligned")
 	}
+	if len(data) > maxBlockHeaderLen {
+		return nil, ErrBadBlockHeader
+	}
 	s := len(data)/4 - 1
-	if !(1 < s && s <= 255) {
+	if s <= 1 {
 		panic("wrong block header size")
 	}
 	data[0] = byte(s)
@@ -523,8 +608,32 @@ type filter interface {
 	last() bool
 }
 
-// readFilter reads a block filter from the block header. At this point
-// in time only the LZMA2 filter is supported.
+// maxFilterPropsSize limits the size of the filter properties. A block
+// header cannot be longer than 1024 bytes.
+const maxFilterPropsSize = 1024
+
+// newFilterFunc returns a new filter value for the filter ID or nil if
+// the filter isn't supported.
+var newFilterFunc = func(id uint64) filter {
+	switch id {
+	case lzmaFilterID:
+		return new(lzmaFilter)
+	case x86FilterID:
+		return new(x86Filter)
+	case armFilterID:
+		return new(armFilter)
+	case armThumbFilterID:
+		return new(armThumbFilter)
+	case arm64FilterID:
+		return new(arm64Filter)
+	}
+	return nil
+}
+
+// readFilter reads a block filter from the block header. The filter
+// flags consist of the filter ID, the size of the properties and the
+// properties itself. Only the filters supported by newFilterFunc can be
+// read.
 func readFilter(r io.Reader) (f filter, err error) {
 	br := lzma.ByteReader(r)
 
@@ -533,17 +642,24 @@ func readFilter(r io.Reader) (f filter, err error) {
 	if err != nil {
 		return nil, err
 	}
+	size, _, err := readUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxFilterPropsSize {
+		return nil, errors.New("xz: filter properties too large")
+	}
 
-	var data []byte
-	switch id {
-	case lzmaFilterID:
-		data = make([]byte, lzmaFilterLen)
-		data[0] = lzmaFilterID
-		if _, err = io.ReadFull(r, data[1:]); err != nil {
-			return nil, err
-		}
-		f = new(lzmaFilter)
-	default:
+	p := make([]byte, 20)
+	k := putUvarint(p, id)
+	k += putUvarint(p[k:], size)
+	data := make([]byte, k+int(size))
+	copy(data, p[:k])
+	if _, err = io.ReadFull(r, data[k:]); err != nil {
+		return nil, err
+	}
+
+	if f = newFilterFunc(id); f == nil {
 		if id >= minReservedID {
 			return nil, errors.New(
 				"xz: reserved filter id in block stream header")
@@ -553,20 +669,27 @@ func readFilter(r io.Reader) (f filter, err error) {
 	if err = f.UnmarshalBinary(data); err != nil {
 		return nil, err
 	}
-	return f, err
+	return f, nil
 }
 
-// readFilters reads count filters. At this point in time only the count
-// 1 is supported.
+// readFilters reads count filters and verifies that they form a valid
+// filter chain.
 func readFilters(r io.Reader, count int) (filters []filter, err error) {
-	if count != 1 {
+	if !(minFilters <= count && count <= maxFilters) {
 		return nil, errors.New("xz: unsupported filter count")
 	}
-	f, err := readFilter(r)
-	if err != nil {
+	filters = make([]filter, 0, count)
+	for i := 0; i < count; i++ {
+		f, err := readFilter(r)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if err = verifyFilters(filters); err != nil {
 		return nil, err
 	}
-	return []filter{f}, err
+	return filters, nil
 }
 
 /*** Index ***/
@@ -588,6 +711,10 @@ func readRecord(r io.ByteReader) (rec record, n int, err error) {
 	if rec.unpaddedSize < 0 {
 		return rec, n, errors.New("xz: unpadded size negative")
 	}
+	// The padded size must be representable.
+	if rec.unpaddedSize > maxInt64-3 {
+		return rec, n, errSizeOverflow
+	}
 
 	u, k, err = readUvarint(r)
 	n += k
@@ -675,9 +802,9 @@ func readIndexBody(r io.Reader, expectedRecordLen int) (records []record, n int6
 	if err != nil {
 		return nil, n, err
 	}
-	recLen := int(u)
-	if recLen < 0 || uint64(recLen) != u {
-		return nil, n, errors.New("xz: record number overflow")
+	recLen, err := intSize(u)
+	if err != nil {
+		return nil, n, err
 	}
 	if recLen != expectedRecordLen {
 		return nil, n, fmt.Errorf(
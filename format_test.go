@@ -6,6 +6,10 @@ package xz
 
 import (
 	"bytes"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"testing"
 )
 
@@ -24,6 +28,80 @@ func TestHeader(t *testing.T) {
 	}
 }
 
+func TestHeaderUnsupportedCheck(t *testing.T) {
+	h := header{flags: CRC32}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	// check ID 0x05 is defined by the format with 8 bytes but
+	// not supported
+	data[7] = 0x05
+	crc := crc32.NewIEEE()
+	crc.Write(data[6:8])
+	putUint32LE(data[8:], crc.Sum32())
+	var g header
+	err = g.UnmarshalBinary(data)
+	const want = "xz: unsupported check type 0x05 (8-byte check)"
+	if err == nil || err.Error() != want {
+		t.Fatalf("UnmarshalBinary returned %v; want %q", err, want)
+	}
+
+	data[7] = 0x15
+	crc.Reset()
+	crc.Write(data[6:8])
+	putUint32LE(data[8:], crc.Sum32())
+	if err = g.UnmarshalBinary(data); err != errInvalidFlags {
+		t.Fatalf("UnmarshalBinary returned %v; want %v", err,
+			errInvalidFlags)
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	tests := []struct {
+		id   byte
+		size int
+	}{
+		{None, 0}, {CRC32, 4}, {0x03, 4}, {CRC64, 8}, {0x07, 16},
+		{SHA256, 32}, {0x0d, 64}, {0x0f, 64},
+	}
+	for _, tc := range tests {
+		if n := checkSize(tc.id); n != tc.size {
+			t.Errorf("checkSize(%#02x) = %d; want %d", tc.id, n,
+				tc.size)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	data, err := ioutil.ReadFile("fox.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	for _, n := range []int{6, 8, HeaderLen, len(data)} {
+		if !Detect(data[:n]) {
+			t.Errorf("Detect returns false for prefix length %d;"+
+				" want true", n)
+		}
+	}
+	if Detect(data[:5]) {
+		t.Errorf("Detect returns true for prefix length 5; want false")
+	}
+	bad := append([]byte(nil), data[:HeaderLen]...)
+	bad[7] = 0x05
+	if Detect(bad) {
+		t.Errorf("Detect returns true for invalid header")
+	}
+	rnd := rand.New(rand.NewSource(1))
+	p := make([]byte, 64)
+	for i := 0; i < 100; i++ {
+		rnd.Read(p)
+		if Detect(p) {
+			t.Errorf("Detect returns true for random data")
+		}
+	}
+}
+
 func TestFooter(t *testing.T) {
 	f := footer{indexSize: 64, flags: CRC32}
 	data, err := f.MarshalBinary()
@@ -140,3 +218,255 @@ func TestBlockHeader(t *testing.T) {
 		t.Errorf("got dictCap %d; want %d", glf.dictCap, hlf.dictCap)
 	}
 }
+
+// testFilter is a non-last filter used to test filter chains.
+type testFilter struct {
+	filterID uint64
+}
+
+func (f testFilter) id() uint64                        { return f.filterID }
+func (f testFilter) UnmarshalBinary(data []byte) error { return nil }
+func (f testFilter) MarshalBinary() ([]byte, error) {
+	return []byte{byte(f.filterID), 0}, nil
+}
+func (f testFilter) reader(r io.Reader, c *ReaderConfig) (io.Reader, error) {
+	return r, nil
+}
+func (f testFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
+) (io.WriteCloser, error) {
+	return w, nil
+}
+func (f testFilter) last() bool { return false }
+
+func TestVerifyFilters(t *testing.T) {
+	delta := testFilter{0x03}
+	x86 := testFilter{0x04}
+	lzma2 := &lzmaFilter{1 << 20}
+	tests := []struct {
+		filters []filter
+		err     error
+	}{
+		{[]filter{lzma2}, nil},
+		{[]filter{delta, x86, lzma2}, nil},
+		{[]filter{x86, delta, lzma2}, nil},
+		{[]filter{delta, delta, x86, lzma2}, nil},
+		{nil, errNoFilters},
+		{[]filter{delta, delta, delta, x86, lzma2}, errTooManyFilters},
+		{[]filter{lzma2, delta}, errLastFilterInside},
+		{[]filter{lzma2, lzma2}, errLastFilterInside},
+		{[]filter{delta, x86}, errWrongLastFilter},
+	}
+	for i, tc := range tests {
+		if err := verifyFilters(tc.filters); err != tc.err {
+			t.Errorf("test %d: verifyFilters returned %v; want %v",
+				i, err, tc.err)
+		}
+	}
+}
+
+func TestBlockHeaderTwoLZMA2Filters(t *testing.T) {
+	// header size, flags with two filters, two LZMA2 filters,
+	// padding and CRC-32
+	data := []byte{2, 0x01, 0x21, 1, 16, 0x21, 1, 16, 0, 0, 0, 0}
+	putUint32LE(data[8:], crc32.ChecksumIEEE(data[:8]))
+	var h blockHeader
+	if err := h.UnmarshalBinary(data); err != errLastFilterInside {
+		t.Fatalf("UnmarshalBinary returned %v; want %v", err,
+			errLastFilterInside)
+	}
+}
+
+func TestBlockHeaderSizeMismatch(t *testing.T) {
+	tests := []struct {
+		data []byte
+		err  error
+	}{
+		// compressed size doesn't fit into the declared size
+		{[]byte{1, 0x40, 0x80, 0x80, 0, 0, 0, 0}, ErrBadBlockHeader},
+		// filter flags don't fit into the declared size
+		{[]byte{1, 0x00, 0x21, 1, 0, 0, 0, 0}, ErrBadBlockHeader},
+		// padding of 7 bytes is accepted
+		{append([]byte{3, 0x00, 0x21, 1, 16}, make([]byte, 11)...),
+			nil},
+		// a declared size that is too large is not detected,
+		// since the additional padding is accepted.
+		{append([]byte{4, 0x00, 0x21, 1, 16}, make([]byte, 15)...),
+			nil},
+	}
+	for i, tc := range tests {
+		n := len(tc.data) - 4
+		putUint32LE(tc.data[n:], crc32.ChecksumIEEE(tc.data[:n]))
+		var h blockHeader
+		if err := h.UnmarshalBinary(tc.data); err != tc.err {
+			t.Errorf("test %d: UnmarshalBinary returned %v; want %v",
+				i, err, tc.err)
+		}
+	}
+}
+
+// propsFilter is a non-last filter with properties of arbitrary length.
+type propsFilter struct {
+	testFilter
+	props []byte
+}
+
+func (f *propsFilter) MarshalBinary() ([]byte, error) {
+	data := []byte{byte(f.filterID), byte(len(f.props))}
+	return append(data, f.props...), nil
+}
+
+func (f *propsFilter) UnmarshalBinary(data []byte) error {
+	f.filterID = uint64(data[0])
+	f.props = append([]byte(nil), data[2:]...)
+	return nil
+}
+
+func TestBlockHeaderFilterProps(t *testing.T) {
+	newFilter := newFilterFunc
+	defer func() { newFilterFunc = newFilter }()
+	newFilterFunc = func(id uint64) filter {
+		if id == 0x03 || id == 0x04 {
+			return new(propsFilter)
+		}
+		return newFilter(id)
+	}
+
+	h := blockHeader{
+		compressedSize:   -1,
+		uncompressedSize: -1,
+		filters: []filter{
+			&propsFilter{testFilter{0x03}, []byte{7}},
+			&propsFilter{testFilter{0x04}, nil},
+			&propsFilter{testFilter{0x04}, []byte{1, 2, 3, 4}},
+			&lzmaFilter{1 << 20},
+		},
+	}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	var g blockHeader
+	if err = g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if len(g.filters) != len(h.filters) {
+		t.Fatalf("got %d filters; want %d", len(g.filters),
+			len(h.filters))
+	}
+	for i, f := range h.filters[:3] {
+		want := f.(*propsFilter)
+		got, ok := g.filters[i].(*propsFilter)
+		if !ok || got.filterID != want.filterID ||
+			!bytes.Equal(got.props, want.props) {
+			t.Fatalf("filter %d is %v; want %v", i, g.filters[i],
+				want)
+		}
+	}
+	f, ok := g.filters[3].(*lzmaFilter)
+	if !ok || f.dictCap != 1<<20 {
+		t.Fatalf("filter 3 is %v; want LZMA2 filter", g.filters[3])
+	}
+}
+
+func TestBlockHeaderLimits(t *testing.T) {
+	// The largest size byte declares a header of 1024 bytes.
+	data := make([]byte, 2*maxBlockHeaderLen)
+	data[0] = 0xff
+	_, n, err := readBlockHeaderData(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("readBlockHeaderData error %s", err)
+	}
+	if n != maxBlockHeaderLen {
+		t.Fatalf("readBlockHeaderData read %d bytes; want %d", n,
+			maxBlockHeaderLen)
+	}
+
+	// The two-bit count field of the flags byte of a header with four
+	// filters is full.
+	newFilter := newFilterFunc
+	defer func() { newFilterFunc = newFilter }()
+	newFilterFunc = func(id uint64) filter {
+		if id == 0x03 {
+			return new(propsFilter)
+		}
+		return newFilter(id)
+	}
+	h := blockHeader{
+		compressedSize:   -1,
+		uncompressedSize: -1,
+		filters: []filter{
+			&propsFilter{testFilter{0x03}, nil},
+			&propsFilter{testFilter{0x03}, nil},
+			&propsFilter{testFilter{0x03}, nil},
+			&lzmaFilter{1 << 20},
+		},
+	}
+	if data, err = h.MarshalBinary(); err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	if c := data[1] & filterCountMask; c != filterCountMask {
+		t.Fatalf("filter count field %d; want %d", c, filterCountMask)
+	}
+	if int(filterCountMask)+1 != maxFilters {
+		t.Fatalf("count field expresses %d filters; want %d",
+			filterCountMask+1, maxFilters)
+	}
+	var g blockHeader
+	if err = g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if len(g.filters) != maxFilters {
+		t.Fatalf("got %d filters; want %d", len(g.filters), maxFilters)
+	}
+
+	// Large filter properties must not produce a header beyond the
+	// limit.
+	for i := range h.filters[:3] {
+		h.filters[i] = &propsFilter{testFilter{0x03},
+			make([]byte, 400)}
+	}
+	if _, err = h.MarshalBinary(); err != ErrBadBlockHeader {
+		t.Fatalf("MarshalBinary of oversized header returned %v; "+
+			"want %v", err, ErrBadBlockHeader)
+	}
+}
+
+func TestBlockHeaderSizeFlags(t *testing.T) {
+	tests := []blockHeader{
+		{compressedSize: 1234, uncompressedSize: 5678},
+		{compressedSize: 1234, uncompressedSize: -1},
+		{compressedSize: -1, uncompressedSize: 5678},
+		{compressedSize: -1, uncompressedSize: -1},
+	}
+	for _, bh := range tests {
+		bh.filters = []filter{&lzmaFilter{4096}}
+		data, err := bh.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary error %s", err)
+		}
+		var h BlockHeader
+		if err = h.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary error %s", err)
+		}
+		if h.HasCompressedSize != (bh.compressedSize >= 0) {
+			t.Errorf("%v: HasCompressedSize %t", bh,
+				h.HasCompressedSize)
+		}
+		if h.HasUncompressedSize != (bh.uncompressedSize >= 0) {
+			t.Errorf("%v: HasUncompressedSize %t", bh,
+				h.HasUncompressedSize)
+		}
+		if h.HasCompressedSize && h.CompressedSize != bh.compressedSize {
+			t.Errorf("%v: CompressedSize %d", bh, h.CompressedSize)
+		}
+		if h.HasUncompressedSize &&
+			h.UncompressedSize != bh.uncompressedSize {
+			t.Errorf("%v: UncompressedSize %d", bh,
+				h.UncompressedSize)
+		}
+	}
+	var h BlockHeader
+	if err := h.UnmarshalBinary(nil); err == nil {
+		t.Fatalf("UnmarshalBinary(nil) returned no error")
+	}
+}
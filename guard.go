@@ -0,0 +1,28 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "sync/atomic"
+
+// useGuard detects the concurrent use of a reader or writer. A data
+// race on the internal state would silently corrupt the data, so the
+// guard panics instead. The check costs two atomic operations per call.
+type useGuard struct {
+	busy int32
+}
+
+// enter marks the start of the method op. It panics if another method
+// call is in progress.
+func (g *useGuard) enter(op string) {
+	if !atomic.CompareAndSwapInt32(&g.busy, 0, 1) {
+		panic("xz: concurrent call of " + op +
+			"; readers and writers are not safe for concurrent use")
+	}
+}
+
+// exit marks the end of the method call.
+func (g *useGuard) exit() {
+	atomic.StoreInt32(&g.busy, 0)
+}
@@ -0,0 +1,90 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "io"
+
+// Resources describes the resources required to decompress an xz
+// file as reported by InspectResources.
+type Resources struct {
+	// CheckSum is the check type of the first stream.
+	CheckSum byte
+	// DictCap is the dictionary capacity of the first block, which
+	// dominates the memory required for decompression. It is zero
+	// if the stream contains no block.
+	DictCap int64
+	// UncompressedSize is the total size of the decompressed data
+	// in bytes, which determines the decompression time. It is -1
+	// if the size cannot be determined.
+	UncompressedSize int64
+}
+
+// InspectResources reads the stream header and the first block header
+// from r and reports the resources required for decompression. No
+// block data is read. The total uncompressed size is only computed
+// if r supports io.ReaderAt and io.Seeker, so the index can be read
+// from the end of the file; the read position is restored afterwards.
+func InspectResources(r io.Reader) (res Resources, err error) {
+	res.UncompressedSize = -1
+	p := make([]byte, HeaderLen)
+	if _, err = io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return res, err
+	}
+	var h header
+	if err = h.UnmarshalBinary(p); err != nil {
+		return res, err
+	}
+	res.CheckSum = h.flags
+
+	bh, _, err := readBlockHeader(r)
+	switch err {
+	case nil:
+		for _, f := range bh.filters {
+			if lf, ok := f.(*lzmaFilter); ok {
+				res.DictCap = lf.dictCap
+			}
+		}
+	case errIndexIndicator:
+	default:
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return res, err
+	}
+
+	ra, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return res, nil
+	}
+	pos, err := ra.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return res, err
+	}
+	size, err := ra.Seek(0, io.SeekEnd)
+	if err != nil {
+		return res, err
+	}
+	if _, err = ra.Seek(pos, io.SeekStart); err != nil {
+		return res, err
+	}
+	blocks, err := readIndex(ra, size)
+	if err != nil {
+		return res, err
+	}
+	var u int64
+	for _, b := range blocks {
+		if u, err = addSize(u, b.rec.uncompressedSize); err != nil {
+			return res, err
+		}
+	}
+	res.UncompressedSize = u
+	return res, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestInspectResources(t *testing.T) {
+	const file = "fox.xz"
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("os.Open(%q) error %s", file, err)
+	}
+	defer f.Close()
+	want := Resources{
+		CheckSum:         CRC64,
+		DictCap:          8 * 1024 * 1024,
+		UncompressedSize: 45,
+	}
+	res, err := InspectResources(f)
+	if err != nil {
+		t.Fatalf("InspectResources error %s", err)
+	}
+	if res != want {
+		t.Fatalf("InspectResources returned %+v; want %+v", res, want)
+	}
+
+	// Without seeking support only the headers are read.
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek error %s", err)
+	}
+	cr := &countingReader{r: struct{ io.Reader }{f}}
+	want.UncompressedSize = -1
+	if res, err = InspectResources(cr); err != nil {
+		t.Fatalf("InspectResources error %s", err)
+	}
+	if res != want {
+		t.Fatalf("InspectResources returned %+v; want %+v", res, want)
+	}
+	if cr.n != HeaderLen+12 {
+		t.Fatalf("InspectResources read %d bytes; want %d", cr.n,
+			HeaderLen+12)
+	}
+}
@@ -43,6 +43,8 @@ type binTree struct {
 	x uint32
 	// preallocated array
 	data []byte
+	// match length at which the search for longer matches stops
+	niceLen int
 }
 
 // null represents the nonexistent index. We can't use zero because it
@@ -63,10 +65,11 @@ func newBinTree(capacity int) (t *binTree, err error) {
 			"newBinTree: capacity must less 2^{32}-1")
 	}
 	t = &binTree{
-		node: make([]node, capacity),
-		hoff: -int64(wordLen),
-		root: null,
-		data: make([]byte, maxMatchLen),
+		node:    make([]node, capacity),
+		hoff:    -int64(wordLen),
+		root:    null,
+		data:    make([]byte, maxMatchLen),
+		niceLen: maxMatchLen,
 	}
 	return t, nil
 }
@@ -458,7 +461,7 @@ func (t *binTree) NextOp(rep [4]uint32) operation {
 	)
 	p := matchParams{
 		rep:     rep,
-		nAccept: maxMatchLen,
+		nAccept: t.niceLen,
 		check:   32,
 	}
 	i := 4
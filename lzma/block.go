@@ -0,0 +1,63 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+)
+
+// CompressBlock compresses data into a complete LZMA2 chunk sequence
+// including the end-of-stream byte. The sequence starts with a
+// dictionary and properties reset, so it can be decoded without any
+// other data. The second result is the dictionary capacity property
+// byte as used by the LZMA2 filter of the xz format, which is required
+// by DecompressBlock.
+func CompressBlock(data []byte, cfg Writer2Config) ([]byte, byte, error) {
+	if err := cfg.Verify(); err != nil {
+		return nil, 0, err
+	}
+	prop := EncodeDictCap(int64(cfg.DictCap))
+	var buf bytes.Buffer
+	buf.Grow(RawSize(len(data)))
+	w, err := cfg.NewWriter2(&buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, 0, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), prop, nil
+}
+
+// DecompressBlock decompresses an LZMA2 chunk sequence created by
+// CompressBlock. The argument prop is the dictionary capacity property
+// byte returned by CompressBlock. The dictionary is allocated with the
+// capacity given by prop. Data following the end-of-stream byte is
+// reported as an error.
+func DecompressBlock(blob []byte, prop byte) ([]byte, error) {
+	dictCap, err := DecodeDictCap(prop)
+	if err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(blob)
+	r, err := Reader2Config{DictCap: int(dictCap)}.NewReader2(br)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if br.Len() > 0 {
+		return nil, errors.New(
+			"lzma: data after end of LZMA2 chunk sequence")
+	}
+	return data, nil
+}
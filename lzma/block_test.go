@@ -0,0 +1,52 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestCompressBlock(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(31)), 60000)
+	data := txt.Bytes()
+	parts := [][]byte{data[:0], data[:1], data[1:20000], data[20000:]}
+	blobs := make([][]byte, len(parts))
+	props := make([]byte, len(parts))
+	cfg := Writer2Config{DictCap: 3 << 16}
+	for i, p := range parts {
+		var err error
+		if blobs[i], props[i], err = CompressBlock(p, cfg); err != nil {
+			t.Fatalf("CompressBlock error %s", err)
+		}
+		dictCap, err := DecodeDictCap(props[i])
+		if err != nil {
+			t.Fatalf("DecodeDictCap error %s", err)
+		}
+		if dictCap != 3<<16 {
+			t.Fatalf("property %#02x decodes to %d; want %d",
+				props[i], dictCap, 3<<16)
+		}
+	}
+	// decode in reverse order to show that the blobs are independent
+	for i := len(parts) - 1; i >= 0; i-- {
+		p, err := DecompressBlock(blobs[i], props[i])
+		if err != nil {
+			t.Fatalf("DecompressBlock error %s", err)
+		}
+		if !bytes.Equal(p, parts[i]) {
+			t.Fatalf("blob %d: decompressed data differs", i)
+		}
+	}
+	blob := append(append([]byte(nil), blobs[1]...), 0)
+	if _, err := DecompressBlock(blob, props[1]); err == nil {
+		t.Fatalf("DecompressBlock accepted trailing data")
+	}
+}
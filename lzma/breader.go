@@ -26,6 +26,25 @@ func ByteReader(r io.Reader) io.ByteReader {
 	return br
 }
 
+// limitedByteReader reads at most n bytes from an io.ByteReader.
+type limitedByteReader struct {
+	br io.ByteReader
+	n  int64
+}
+
+// ReadByte reads the next byte. It returns io.EOF if n bytes have been
+// read.
+func (r *limitedByteReader) ReadByte() (c byte, err error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if c, err = r.br.ReadByte(); err != nil {
+		return 0, err
+	}
+	r.n--
+	return c, nil
+}
+
 // ReadByte read byte function.
 func (r *breader) ReadByte() (c byte, err error) {
 	n, err := r.Reader.Read(r.p)
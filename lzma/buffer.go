@@ -23,6 +23,24 @@ func newBuffer(size int) *buffer {
 	return &buffer{data: make([]byte, size+1)}
 }
 
+// Allocator provides the memory for the dictionary buffers of the
+// readers and writers. Alloc must return a slice of length n; Free is
+// called with the slice when the reader or writer is closed and the
+// memory is no longer used.
+type Allocator interface {
+	Alloc(n int) []byte
+	Free(p []byte)
+}
+
+// newBufferAlloc creates a buffer with the given size using the
+// allocator. If the allocator is nil make is used.
+func newBufferAlloc(size int, a Allocator) *buffer {
+	if a == nil {
+		return newBuffer(size)
+	}
+	return &buffer{data: a.Alloc(size + 1)}
+}
+
 // Cap returns the capacity of the buffer.
 func (b *buffer) Cap() int {
 	return len(b.data) - 1
@@ -28,6 +28,11 @@ type decoder struct {
 	eos bool
 	// EOS marker found
 	eosMarker bool
+	// lazy requests to decode only the data requested by Read
+	lazy bool
+	// m is returned by readOp for matches to avoid an allocation for
+	// every match operation
+	m match
 }
 
 // newDecoder creates a new decoder instance. The parameter size provides
@@ -52,8 +57,10 @@ func newDecoder(br io.ByteReader, state *state, dict *decoderDict, size int64) (
 // Reopen restarts the decoder with a new byte reader and a new size. Reopen
 // resets the Decompressed counter to zero.
 func (d *decoder) Reopen(br io.ByteReader, size int64) error {
-	var err error
-	if d.rd, err = newRangeDecoder(br); err != nil {
+	if d.rd == nil {
+		d.rd = new(rangeDecoder)
+	}
+	if err := d.rd.init(br); err != nil {
 		return err
 	}
 	d.start = d.Dict.pos()
@@ -123,9 +130,9 @@ func (d *decoder) readOp() (op operation, err error) {
 			d.eosMarker = true
 			return nil, errEOS
 		}
-		op = match{n: int(n) + minMatchLen,
+		d.m = match{n: int(n) + minMatchLen,
 			distance: int64(d.State.rep[0]) + minDistance}
-		return op, nil
+		return &d.m, nil
 	}
 	b, err = d.State.isRepG0[state].Decode(d.rd)
 	if err != nil {
@@ -140,8 +147,8 @@ func (d *decoder) readOp() (op operation, err error) {
 		}
 		if b == 0 {
 			d.State.updateStateShortRep()
-			op = match{n: 1, distance: int64(dist) + minDistance}
-			return op, nil
+			d.m = match{n: 1, distance: int64(dist) + minDistance}
+			return &d.m, nil
 		}
 	} else {
 		b, err = d.State.isRepG1[state].Decode(d.rd)
@@ -171,15 +178,15 @@ func (d *decoder) readOp() (op operation, err error) {
 		return nil, err
 	}
 	d.State.updateStateRep()
-	op = match{n: int(n) + minMatchLen, distance: int64(dist) + minDistance}
-	return op, nil
+	d.m = match{n: int(n) + minMatchLen, distance: int64(dist) + minDistance}
+	return &d.m, nil
 }
 
 // apply takes the operation and transforms the decoder dictionary accordingly.
 func (d *decoder) apply(op operation) error {
 	var err error
 	switch x := op.(type) {
-	case match:
+	case *match:
 		err = d.Dict.writeMatch(x.distance, x.n)
 	case lit:
 		err = d.Dict.WriteByte(x.b)
@@ -190,13 +197,22 @@ func (d *decoder) apply(op operation) error {
 }
 
 // decompress fills the dictionary unless no space for new data is
-// available. If the end of the LZMA stream has been reached io.EOF will
-// be returned.
-func (d *decoder) decompress() error {
+// available. If the decoder is lazy, it stops as soon as want bytes
+// are buffered. If the end of the LZMA stream has been reached io.EOF
+// will be returned.
+func (d *decoder) decompress(want int) error {
 	if d.eos {
 		return io.EOF
 	}
 	for d.Dict.Available() >= maxMatchLen {
+		// The check before reading an operation supports the
+		// declared size zero without an EOS marker.
+		if d.size >= 0 && d.Decompressed() >= d.size {
+			return d.sizeReached()
+		}
+		if d.lazy && d.Dict.buf.Buffered() >= want {
+			return nil
+		}
 		op, err := d.readOp()
 		switch err {
 		case nil:
@@ -220,28 +236,35 @@ func (d *decoder) decompress() error {
 			return err
 		}
 		if d.size >= 0 && d.Decompressed() >= d.size {
-			d.eos = true
-			if d.Decompressed() > d.size {
-				return errSize
-			}
-			if !d.rd.possiblyAtEnd() {
-				switch _, err = d.readOp(); err {
-				case nil:
-					return errSize
-				case io.EOF:
-					return io.ErrUnexpectedEOF
-				case errEOS:
-					break
-				default:
-					return err
-				}
-			}
-			return io.EOF
+			return d.sizeReached()
 		}
 	}
 	return nil
 }
 
+// sizeReached terminates the decoding after the declared size has been
+// decompressed. An EOS marker following the data is consumed; any other
+// operation is an error.
+func (d *decoder) sizeReached() error {
+	d.eos = true
+	if d.Decompressed() > d.size {
+		return errSize
+	}
+	if !d.rd.possiblyAtEnd() {
+		switch _, err := d.readOp(); err {
+		case nil:
+			return errSize
+		case io.EOF:
+			return io.ErrUnexpectedEOF
+		case errEOS:
+			break
+		default:
+			return err
+		}
+	}
+	return io.EOF
+}
+
 // Errors that may be returned while decoding data.
 var (
 	errDataAfterEOS = errors.New("lzma: data after end of stream marker")
@@ -265,7 +288,7 @@ func (d *decoder) Read(p []byte) (n int, err error) {
 		if n >= len(p) {
 			return n, nil
 		}
-		if err = d.decompress(); err != nil && err != io.EOF {
+		if err = d.decompress(len(p) - n); err != nil && err != io.EOF {
 			return n, err
 		}
 	}
@@ -6,6 +6,7 @@ package lzma
 
 import (
 	"bufio"
+	"bytes"
 	"io"
 	"io/ioutil"
 	"os"
@@ -31,7 +32,7 @@ func TestDecoder(t *testing.T) {
 		}
 		state := newState(props)
 		const capacity = 0x800000
-		dict, err := newDecoderDict(capacity)
+		dict, err := newDecoderDict(capacity, nil)
 		if err != nil {
 			t.Fatalf("newDecoderDict: error %s", err)
 		}
@@ -57,3 +58,50 @@ func TestDecoder(t *testing.T) {
 		}
 	}
 }
+
+func TestDecoderDistanceOutOfRange(t *testing.T) {
+	var buf bytes.Buffer
+	props := Properties{LC: 3, LP: 0, PB: 2}
+	m, err := newHashTable(4096, 4)
+	if err != nil {
+		t.Fatalf("newHashTable error %s", err)
+	}
+	edict, err := newEncoderDict(4096, 4096, m, nil)
+	if err != nil {
+		t.Fatalf("newEncoderDict error %s", err)
+	}
+	e, err := newEncoder(&buf, newState(props), edict, eosMarker)
+	if err != nil {
+		t.Fatalf("newEncoder error %s", err)
+	}
+	// Two literals followed by a match with a distance exceeding the
+	// two bytes in the dictionary.
+	if _, err = edict.Write([]byte("ab")); err != nil {
+		t.Fatalf("edict.Write error %s", err)
+	}
+	for _, op := range []operation{lit{'a'}, lit{'b'},
+		match{distance: 100, n: 10}} {
+		if err = e.writeOp(op); err != nil {
+			t.Fatalf("writeOp error %s", err)
+		}
+		if _, ok := op.(lit); ok {
+			edict.Discard(1)
+		}
+	}
+	if err = e.re.Close(); err != nil {
+		t.Fatalf("e.re.Close error %s", err)
+	}
+
+	ddict, err := newDecoderDict(4096, nil)
+	if err != nil {
+		t.Fatalf("newDecoderDict error %s", err)
+	}
+	d, err := newDecoder(&buf, newState(props), ddict, -1)
+	if err != nil {
+		t.Fatalf("newDecoder error %s", err)
+	}
+	if _, err = ioutil.ReadAll(d); err != errMatchDist {
+		t.Fatalf("ReadAll returned error %v; want %v", err,
+			errMatchDist)
+	}
+}
@@ -14,16 +14,18 @@ import (
 type decoderDict struct {
 	buf  buffer
 	head int64
+	// maximum match distance; zero permits the whole dictionary
+	window int
 }
 
 // newDecoderDict creates a new decoder dictionary. The whole dictionary
 // will be used as reader buffer.
-func newDecoderDict(dictCap int) (d *decoderDict, err error) {
+func newDecoderDict(dictCap int, a Allocator) (d *decoderDict, err error) {
 	// lower limit supports easy test cases
 	if !(1 <= dictCap && int64(dictCap) <= MaxDictCap) {
 		return nil, errors.New("lzma: dictCap out of range")
 	}
-	d = &decoderDict{buf: *newBuffer(dictCap)}
+	d = &decoderDict{buf: *newBufferAlloc(dictCap, a)}
 	return d, nil
 }
 
@@ -43,6 +45,22 @@ func (d *decoderDict) WriteByte(c byte) error {
 	return nil
 }
 
+// preset puts the data of p into the dictionary without making it
+// available for reading. Only the last bytes of p fitting into the
+// dictionary are used.
+func (d *decoderDict) preset(p []byte) {
+	if c := d.buf.Cap(); len(p) > c {
+		p = p[len(p)-c:]
+	}
+	for len(p) > 0 {
+		n, _ := d.Write(p)
+		if _, err := d.buf.Discard(n); err != nil {
+			panic(err)
+		}
+		p = p[n:]
+	}
+}
+
 // pos returns the position of the dictionary head.
 func (d *decoderDict) pos() int64 { return d.head }
 
@@ -69,19 +87,38 @@ func (d *decoderDict) byteAt(dist int) byte {
 	return d.buf.data[i]
 }
 
+// Errors returned by writeMatch for corrupt input data.
+var (
+	errMatchDist = errors.New(
+		"lzma: match distance exceeds dictionary length")
+	errMatchLen = errors.New("lzma: match length out of range")
+)
+
+// ErrCorrupt indicates corrupt compressed data. It is wrapped by
+// errors providing the details of the corruption.
+var ErrCorrupt = errors.New("lzma: corrupt data")
+
 // writeMatch writes the match at the top of the dictionary. The given
 // distance must point in the current dictionary and the length must not
-// exceed the maximum length 273 supported in LZMA.
+// exceed the maximum length 273 supported in LZMA. Corrupt data may
+// provide distances beyond the current dictionary length; errMatchDist
+// is returned in that case. Distances exceeding the window are reported
+// with an error wrapping ErrCorrupt.
 //
 // The error value ErrNoSpace indicates that no space is available in
 // the dictionary for writing. You need to read from the dictionary
 // first.
 func (d *decoderDict) writeMatch(dist int64, length int) error {
+	if d.window > 0 && dist > int64(d.window) {
+		return fmt.Errorf(
+			"%w: match distance %d exceeds dictionary window %d",
+			ErrCorrupt, dist, d.window)
+	}
 	if !(0 < dist && dist <= int64(d.dictLen())) {
-		return errors.New("writeMatch: distance out of range")
+		return errMatchDist
 	}
 	if !(0 < length && length <= maxMatchLen) {
-		return errors.New("writeMatch: length out of range")
+		return errMatchLen
 	}
 	if length > d.buf.Available() {
 		return ErrNoSpace
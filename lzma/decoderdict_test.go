@@ -5,14 +5,40 @@
 package lzma
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
 func TestNewDecoderDict(t *testing.T) {
-	if _, err := newDecoderDict(0); err == nil {
+	if _, err := newDecoderDict(0, nil); err == nil {
 		t.Fatalf("no error for zero dictionary capacity")
 	}
-	if _, err := newDecoderDict(8); err != nil {
+	if _, err := newDecoderDict(8, nil); err != nil {
 		t.Fatalf("error %s", err)
 	}
 }
+
+func TestDecoderDictWindow(t *testing.T) {
+	d, err := newDecoderDict(64, nil)
+	if err != nil {
+		t.Fatalf("newDecoderDict error %s", err)
+	}
+	d.window = 16
+	for i := 0; i < 32; i++ {
+		if err = d.WriteByte(byte(i)); err != nil {
+			t.Fatalf("WriteByte error %s", err)
+		}
+	}
+	if err = d.writeMatch(16, 2); err != nil {
+		t.Fatalf("writeMatch(16, 2) error %s", err)
+	}
+	err = d.writeMatch(17, 2)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("writeMatch(17, 2) returned %v; want %v", err,
+			ErrCorrupt)
+	}
+	if !strings.Contains(err.Error(), "17") {
+		t.Fatalf("error %q doesn't report the distance", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"math"
+)
+
+// dictCapForPreset returns the default dictionary capacity for a preset
+// dictionary of length n. It provides space for the preset dictionary
+// and a maximum sized uncompressed chunk.
+func dictCapForPreset(n int) int {
+	c := NearestDictCap(int64(n) + maxUncompressedChunk)
+	if int64(int(c)) != c {
+		// 32-bit platform
+		return math.MaxInt32
+	}
+	return int(c)
+}
+
+// DictCompressor compresses records into independent LZMA2 frames
+// using a shared preset dictionary. The dictionary is not part of the
+// frames, so small records that are similar to the dictionary compress
+// well. A frame can only be decompressed by a DictDecompressor using
+// the same dictionary.
+type DictCompressor struct {
+	cfg  Writer2Config
+	dict []byte
+}
+
+// NewDictCompressor creates a DictCompressor for the given preset
+// dictionary. If DictCap is zero, a capacity is chosen that holds the
+// dictionary and a record of 64 KiB.
+func (c Writer2Config) NewDictCompressor(dict []byte,
+) (dc *DictCompressor, err error) {
+	if c.DictCap == 0 {
+		c.DictCap = dictCapForPreset(len(dict))
+	}
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	dc = &DictCompressor{
+		cfg:  c,
+		dict: append([]byte(nil), dict...),
+	}
+	return dc, nil
+}
+
+// Compress compresses the record into an LZMA2 frame terminated by an
+// end-of-stream chunk.
+func (dc *DictCompressor) Compress(record []byte) (frame []byte, err error) {
+	var buf bytes.Buffer
+	w, err := dc.cfg.NewWriter2Dict(&buf, dc.dict)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(record); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DictDecompressor decompresses frames created by a DictCompressor.
+type DictDecompressor struct {
+	cfg  Reader2Config
+	dict []byte
+}
+
+// NewDictDecompressor creates a DictDecompressor for the given preset
+// dictionary. If DictCap is zero, the same default as for
+// NewDictCompressor is used.
+func (c Reader2Config) NewDictDecompressor(dict []byte,
+) (dd *DictDecompressor, err error) {
+	if c.DictCap == 0 {
+		c.DictCap = dictCapForPreset(len(dict))
+	}
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	dd = &DictDecompressor{
+		cfg:  c,
+		dict: append([]byte(nil), dict...),
+	}
+	return dd, nil
+}
+
+// Decompress decompresses a single frame and returns the record.
+func (dd *DictDecompressor) Decompress(frame []byte) (record []byte,
+	err error) {
+
+	r, err := dd.cfg.NewReader2Dict(bytes.NewReader(frame), dd.dict)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
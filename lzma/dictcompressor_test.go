@@ -0,0 +1,64 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestDictCompressor(t *testing.T) {
+	rnd := rand.New(rand.NewSource(29))
+	record := func() []byte {
+		return []byte(fmt.Sprintf(
+			`{"user":"user%d","action":"login","status":"success",`+
+				`"client":"Mozilla/5.0 (X11; Linux x86_64)",`+
+				`"duration_ms":%d}`,
+			rnd.Intn(1000), rnd.Intn(5000)))
+	}
+	var dict bytes.Buffer
+	for i := 0; i < 20; i++ {
+		dict.Write(record())
+	}
+	records := make([][]byte, 100)
+	for i := range records {
+		records[i] = record()
+	}
+
+	size := func(dict []byte) int {
+		dc, err := Writer2Config{}.NewDictCompressor(dict)
+		if err != nil {
+			t.Fatalf("NewDictCompressor error %s", err)
+		}
+		dd, err := Reader2Config{}.NewDictDecompressor(dict)
+		if err != nil {
+			t.Fatalf("NewDictDecompressor error %s", err)
+		}
+		n := 0
+		for _, rec := range records {
+			frame, err := dc.Compress(rec)
+			if err != nil {
+				t.Fatalf("Compress error %s", err)
+			}
+			n += len(frame)
+			out, err := dd.Decompress(frame)
+			if err != nil {
+				t.Fatalf("Decompress error %s", err)
+			}
+			if !bytes.Equal(out, rec) {
+				t.Fatalf("decompressed %q; want %q", out, rec)
+			}
+		}
+		return n
+	}
+	with, without := size(dict.Bytes()), size(nil)
+	t.Logf("with dictionary %d bytes; without %d bytes", with, without)
+	if with >= without {
+		t.Fatalf("with dictionary %d bytes; want less than %d",
+			with, without)
+	}
+}
@@ -74,6 +74,12 @@ func newEncoder(bw io.ByteWriter, state *state, dict *encoderDict,
 // space is available the data in the dictionary buffer will be
 // compressed to make additional space available. If the limit of the
 // underlying writer has been reached ErrLimit will be returned.
+//
+// The data is compressed as soon as it has been written, keeping only
+// the lookahead for the match finder in the buffer, so the compressed
+// data is produced incrementally and not only when the buffer is full.
+// The output doesn't depend on it, because the match finder only sees
+// data that has been compressed.
 func (e *encoder) Write(p []byte) (n int, err error) {
 	for {
 		k, err := e.dict.Write(p[n:])
@@ -84,7 +90,10 @@ func (e *encoder) Write(p []byte) (n int, err error) {
 			}
 			continue
 		}
-		return n, err
+		if err != nil {
+			return n, err
+		}
+		return n, e.compress(0)
 	}
 }
 
@@ -39,7 +39,7 @@ func cycle(t *testing.T, n int) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	encoderDict, err := newEncoderDict(dictCap, dictCap+1024, m)
+	encoderDict, err := newEncoderDict(dictCap, dictCap+1024, m, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -66,7 +66,7 @@ func cycle(t *testing.T, n int) {
 		t.Fatalf("w.Close error %s", err)
 	}
 	t.Logf("buf.Len() %d len(orig) %d", buf.Len(), len(orig))
-	decoderDict, err := newDecoderDict(dictCap)
+	decoderDict, err := newDecoderDict(dictCap, nil)
 	if err != nil {
 		t.Fatalf("newDecoderDict error %s", err)
 	}
@@ -104,7 +104,7 @@ func TestEncoderCycle2(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	encoderDict, err := newEncoderDict(dictCap, dictCap+1024, m)
+	encoderDict, err := newEncoderDict(dictCap, dictCap+1024, m, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -127,7 +127,7 @@ func TestEncoderCycle2(t *testing.T) {
 	}
 	n := w.Compressed()
 	txt = txt[:n]
-	decoderDict, err := newDecoderDict(dictCap)
+	decoderDict, err := newDecoderDict(dictCap, nil)
 	if err != nil {
 		t.Fatalf("NewDecoderDict error %s", err)
 	}
@@ -31,7 +31,8 @@ type encoderDict struct {
 
 // newEncoderDict creates the encoder dictionary. The argument bufSize
 // defines the size of the additional buffer.
-func newEncoderDict(dictCap, bufSize int, m matcher) (d *encoderDict, err error) {
+func newEncoderDict(dictCap, bufSize int, m matcher, a Allocator,
+) (d *encoderDict, err error) {
 	if !(1 <= dictCap && int64(dictCap) <= MaxDictCap) {
 		return nil, errors.New(
 			"lzma: dictionary capacity out of range")
@@ -41,7 +42,7 @@ func newEncoderDict(dictCap, bufSize int, m matcher) (d *encoderDict, err error)
 			"lzma: buffer size must be larger than zero")
 	}
 	d = &encoderDict{
-		buf:      *newBuffer(dictCap + bufSize),
+		buf:      *newBufferAlloc(dictCap+bufSize, a),
 		capacity: dictCap,
 		m:        m,
 	}
@@ -61,6 +62,27 @@ func (d *encoderDict) Discard(n int) {
 	d.m.Write(p)
 }
 
+// preset puts the data of p into the dictionary without encoding it.
+// Only the last bytes of p fitting into the dictionary are used.
+func (d *encoderDict) preset(p []byte) error {
+	if len(p) > d.capacity {
+		p = p[len(p)-d.capacity:]
+	}
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxMatchLen {
+			n = maxMatchLen
+		}
+		k, err := d.Write(p[:n])
+		if err != nil {
+			return err
+		}
+		d.Discard(k)
+		p = p[k:]
+	}
+	return nil
+}
+
 // Len returns the data available in the encoder dictionary.
 func (d *encoderDict) Len() int {
 	n := d.buf.Available()
@@ -78,6 +100,20 @@ func (d *encoderDict) DictLen() int {
 	return d.capacity
 }
 
+// history returns the data of the dictionary in the order it has been
+// written. Data buffered but not yet compressed is not included.
+func (d *encoderDict) history() []byte {
+	n := d.DictLen()
+	p := make([]byte, n)
+	i := d.buf.rear - n
+	if i < 0 {
+		i += len(d.buf.data)
+	}
+	k := copy(p, d.buf.data[i:])
+	copy(p[k:], d.buf.data[:d.buf.rear])
+	return p
+}
+
 // Available returns the number of bytes that can be written by a
 // following Write call.
 func (d *encoderDict) Available() int {
@@ -0,0 +1,124 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// reset prepares the reader for a new chunk sequence read from lzma2.
+// The dictionary, the decoder and the chunk readers are reused.
+func (r *Reader2) reset(lzma2 io.Reader) {
+	r.r = lzma2
+	r.ResetState(true)
+}
+
+// ResetState prepares the reader for the next chunk sequence in the
+// underlying reader, usually after the end-of-stream chunk of the
+// previous sequence has been read. The probability model and the
+// repetition distances of the decoder are reset and the chunk state
+// machine is restarted. The allocated dictionary buffer is kept, so
+// the method doesn't allocate memory. If clearDict is set the
+// dictionary content is discarded and the sequence must start with a
+// dictionary reset. Otherwise the sequence may refer to the data of
+// the previous sequences, but its first compressed chunk must reset
+// the state and provide the properties. ResetState has no effect on a
+// closed reader.
+//
+// Note that the method reads the header of the first chunk of the
+// sequence from the underlying reader, so it may block; an error
+// reading it is returned by the next call of Read. The expected size
+// set by Reader2Config.ExpectedSize applies only to the first sequence
+// and is discarded, so the sequence is read up to its end-of-stream
+// chunk.
+func (r *Reader2) ResetState(clearDict bool) {
+	if r.err == errReaderClosed {
+		return
+	}
+	r.err = nil
+	r.remaining = -1
+	r.chunkReader = nil
+	r.chunk = -1
+	if r.decoder != nil {
+		r.decoder.State.Reset()
+	}
+	if clearDict || r.dict.head == 0 {
+		r.dict.buf.Reset()
+		r.dict.Reset()
+		r.cstate = start
+	} else {
+		r.cstate = 'R'
+	}
+	if err := r.startChunk(); err != nil {
+		r.err = err
+	}
+}
+
+// Continue prepares the reader for a chunk sequence read from lzma2,
+// which may refer to the dictionary content of the sequences read so
+// far, for instance the sequences written by Writer2.Continue. A
+// sequence starting with a dictionary reset is read as well. It is
+// equivalent to ResetState(false) with a new underlying reader, so it
+// reads the first chunk header from lzma2 and discards the expected
+// size.
+func (r *Reader2) Continue(lzma2 io.Reader) {
+	if r.err == errReaderClosed {
+		return
+	}
+	r.r = lzma2
+	r.ResetState(false)
+}
+
+// FrameDecoder decodes many small LZMA2 frames. A frame is a complete
+// chunk sequence terminated by an end-of-stream chunk as written by
+// Writer2. The dictionary and the decoder state are allocated once and
+// reused for every frame, so the allocations per frame are minimal. A
+// FrameDecoder must not be used concurrently.
+type FrameDecoder struct {
+	r   *Reader2
+	src bytes.Reader
+}
+
+// NewFrameDecoder creates a frame decoder. The dictionary capacity of
+// the configuration should be chosen to fit the frames. The field
+// ExpectedSize is ignored.
+func (c Reader2Config) NewFrameDecoder() (d *FrameDecoder, err error) {
+	c.ExpectedSize = 0
+	d = new(FrameDecoder)
+	if d.r, err = c.NewReader2(&d.src); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// errFrameData indicates data after the end-of-stream chunk of a frame.
+var errFrameData = errors.New("lzma: data after end of frame")
+
+// Decode decodes the frame src and appends the uncompressed data to
+// dst. It returns the extended slice. Providing a dst slice with
+// sufficient capacity avoids allocations.
+func (d *FrameDecoder) Decode(dst, src []byte) ([]byte, error) {
+	d.src.Reset(src)
+	d.r.reset(&d.src)
+	for {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+		n, err := d.r.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if err != nil {
+			if err != io.EOF {
+				return dst, err
+			}
+			break
+		}
+	}
+	if d.src.Len() > 0 {
+		return dst, errFrameData
+	}
+	return dst, nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// makeFrames compresses n frames of random text with sizes up to
+// maxSize.
+func makeFrames(tb testing.TB, n, maxSize int) (frames, texts [][]byte) {
+	rng := rand.New(rand.NewSource(19))
+	txt := randtxt.NewReader(rand.NewSource(23))
+	for i := 0; i < n; i++ {
+		var t bytes.Buffer
+		io.CopyN(&t, txt, int64(1+rng.Intn(maxSize)))
+		var f bytes.Buffer
+		w, err := Writer2Config{DictCap: 4096}.NewWriter2(&f)
+		if err != nil {
+			tb.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(t.Bytes()); err != nil {
+			tb.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			tb.Fatalf("w.Close error %s", err)
+		}
+		frames = append(frames, f.Bytes())
+		texts = append(texts, t.Bytes())
+	}
+	return frames, texts
+}
+
+func TestFrameDecoder(t *testing.T) {
+	frames, texts := makeFrames(t, 50, 1000)
+	d, err := Reader2Config{DictCap: 4096}.NewFrameDecoder()
+	if err != nil {
+		t.Fatalf("NewFrameDecoder error %s", err)
+	}
+	var out []byte
+	for i, f := range frames {
+		if out, err = d.Decode(out[:0], f); err != nil {
+			t.Fatalf("frame %d: Decode error %s", i, err)
+		}
+		if !bytes.Equal(out, texts[i]) {
+			t.Fatalf("frame %d: decoded data differs", i)
+		}
+	}
+	_, err = d.Decode(nil, append(frames[0], 0))
+	if err != errFrameData {
+		t.Fatalf("Decode returned %v; want %v", err, errFrameData)
+	}
+	_, err = d.Decode(nil, frames[0][:len(frames[0])-1])
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("Decode returned %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+	// The decoder must recover from errors.
+	if out, err = d.Decode(out[:0], frames[1]); err != nil {
+		t.Fatalf("Decode error %s", err)
+	}
+	if !bytes.Equal(out, texts[1]) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+func BenchmarkFrameDecoder(b *testing.B) {
+	frames, _ := makeFrames(b, 1000, 1000)
+	d, err := Reader2Config{DictCap: 4096}.NewFrameDecoder()
+	if err != nil {
+		b.Fatalf("NewFrameDecoder error %s", err)
+	}
+	out := make([]byte, 0, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if out, err = d.Decode(out[:0], frames[i%len(frames)]); err != nil {
+			b.Fatalf("Decode error %s", err)
+		}
+	}
+}
+
+func BenchmarkFrameReader2(b *testing.B) {
+	frames, _ := makeFrames(b, 1000, 1000)
+	out := make([]byte, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := frames[i%len(frames)]
+		r, err := Reader2Config{DictCap: 4096}.NewReader2(
+			bytes.NewReader(f))
+		if err != nil {
+			b.Fatalf("NewReader2 error %s", err)
+		}
+		for {
+			if _, err = r.Read(out); err != nil {
+				break
+			}
+		}
+		if err != io.EOF {
+			b.Fatalf(fmt.Sprintf("Read error %s", err))
+		}
+	}
+}
+
+func TestReader2ResetState(t *testing.T) {
+	frames, texts := makeFrames(t, 20, 1000)
+	r, err := Reader2Config{DictCap: 4096}.NewReader2(
+		bytes.NewReader(bytes.Join(frames, nil)))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	for i := range frames {
+		if i > 0 {
+			r.ResetState(true)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("frame %d: ReadAll error %s", i, err)
+		}
+		if !bytes.Equal(p, texts[i]) {
+			t.Fatalf("frame %d: decoded data differs", i)
+		}
+	}
+
+	// The second frame refers to the data of the first frame.
+	var buf bytes.Buffer
+	buf.Write(frames[0])
+	w, err := Writer2Config{DictCap: 4096}.NewWriter2Dict(&buf, texts[0])
+	if err != nil {
+		t.Fatalf("NewWriter2Dict error %s", err)
+	}
+	if _, err = w.Write(texts[0]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err = Reader2Config{DictCap: 4096}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			r.ResetState(false)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("frame %d: ReadAll error %s", i, err)
+		}
+		if !bytes.Equal(p, texts[0]) {
+			t.Fatalf("frame %d: decoded data differs", i)
+		}
+	}
+}
+
+func BenchmarkReader2ResetState(b *testing.B) {
+	frames, texts := makeFrames(b, 100, 1000)
+	var src bytes.Reader
+	data := bytes.Join(frames, nil)
+	src.Reset(data)
+	r, err := Reader2Config{DictCap: 4096}.NewReader2(&src)
+	if err != nil {
+		b.Fatalf("NewReader2 error %s", err)
+	}
+	out := make([]byte, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := i % len(frames)
+		if i > 0 {
+			if k == 0 {
+				src.Reset(data)
+			}
+			r.ResetState(true)
+		}
+		n, err := io.ReadFull(r, out[:len(texts[k])])
+		if err != nil {
+			b.Fatalf("frame %d: ReadFull error %s", k, err)
+		}
+		if n != len(texts[k]) {
+			b.Fatalf("frame %d: read %d bytes; want %d", k, n,
+				len(texts[k]))
+		}
+		if _, err = r.Read(out[:1]); err != io.EOF {
+			b.Fatalf("frame %d: Read returned %v; want EOF", k,
+				err)
+		}
+	}
+}
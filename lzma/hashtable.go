@@ -20,15 +20,26 @@ import (
 // function. This controls the speed of the overall encoding.
 const maxMatches = 16
 
+// Incompressible data is detected by a run of failed match searches.
+// After missShift failed searches NextOp returns literals without
+// searching for matches; the number of skipped searches grows with the
+// run length up to maxSkip.
+const (
+	missShift = 5
+	maxSkip   = 32
+)
+
 // shortDists defines the number of short distances supported by the
 // implementation.
 const shortDists = 8
 
 // The minimum is somehow arbitrary but the maximum is limited by the
-// memory requirements of the hash table.
+// memory requirements of the hash table. Larger tables up to
+// maxHashBits can be requested explicitly.
 const (
 	minTableExponent = 9
 	maxTableExponent = 20
+	maxHashBits      = 24
 )
 
 // newRoller contains the function used to create an instance of the
@@ -53,11 +64,17 @@ type hashTable struct {
 	hoff int64
 	// length of the hashed word
 	wordLen int
+	// match length at which the search for longer matches stops
+	niceLen int
 	// hash roller for computing the hash values for the Write
 	// method
 	wr hash.Roller
 	// hash roller for computing arbitrary hashes
 	hr hash.Roller
+	// number of consecutive failed match searches
+	misses int
+	// number of literals to return without searching for matches
+	skip int
 	// preallocated slices
 	p         [maxMatches]int64
 	distances [maxMatches + shortDists]int
@@ -78,11 +95,17 @@ func hashTableExponent(n uint32) int {
 
 // newHashTable creates a new hash table for words of length wordLen
 func newHashTable(capacity int, wordLen int) (t *hashTable, err error) {
+	return newHashTableBits(capacity, wordLen,
+		hashTableExponent(uint32(capacity)))
+}
+
+// newHashTableBits creates a new hash table for words of length wordLen
+// with 2^exp entries.
+func newHashTableBits(capacity, wordLen, exp int) (t *hashTable, err error) {
 	if !(0 < capacity) {
 		return nil, errors.New(
 			"newHashTable: capacity must not be negative")
 	}
-	exp := hashTableExponent(uint32(capacity))
 	if !(1 <= wordLen && wordLen <= 4) {
 		return nil, errors.New("newHashTable: " +
 			"argument wordLen out of range")
@@ -97,6 +120,7 @@ func newHashTable(capacity int, wordLen int) (t *hashTable, err error) {
 		mask:    (uint64(1) << uint(exp)) - 1,
 		hoff:    -int64(wordLen),
 		wordLen: wordLen,
+		niceLen: maxMatchLen,
 		wr:      newRoller(wordLen),
 		hr:      newRoller(wordLen),
 	}
@@ -237,6 +261,12 @@ func (t *hashTable) Matches(p []byte, positions []int64) int {
 //
 // TODO: Use all repetitions to find matches.
 func (t *hashTable) NextOp(rep [4]uint32) operation {
+	if t.skip > 0 {
+		// fast path for incompressible data
+		t.skip--
+		return lit{t.dict.buf.data[t.dict.buf.rear]}
+	}
+
 	// get positions
 	data := t.dict.data[:maxMatchLen]
 	n, _ := t.dict.buf.Peek(data)
@@ -295,7 +325,7 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 		}
 		if n > m.n {
 			m = match{int64(dist), n}
-			if n == len(data) {
+			if n == len(data) || n >= t.niceLen {
 				// No better match will be found.
 				break
 			}
@@ -303,7 +333,12 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 	}
 
 	if m.n == 0 {
+		t.misses++
+		if t.skip = t.misses >> missShift; t.skip > maxSkip {
+			t.skip = maxSkip
+		}
 		return lit{data[0]}
 	}
+	t.misses = 0
 	return m
 }
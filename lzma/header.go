@@ -88,7 +88,7 @@ func (h *header) marshalBinary() (data []byte, err error) {
 
 	// uncompressed size
 	var s uint64
-	if h.size > 0 {
+	if h.size >= 0 {
 		s = uint64(h.size)
 	} else {
 		s = noHeaderSize
@@ -165,3 +165,16 @@ func ValidHeader(data []byte) bool {
 	}
 	return h.size < 0 || h.size <= 1<<38
 }
+
+// Detect reports whether prefix might start with an LZMA file in the
+// classic format. The format has no magic bytes, so the function checks
+// the header using the heuristics of ValidHeader. The prefix must
+// contain the complete header of HeaderLen bytes. Random data may
+// still be classified as LZMA file, so the result should only be used
+// to decide whether NewReader is worth a try.
+func Detect(prefix []byte) bool {
+	if len(prefix) < HeaderLen {
+		return false
+	}
+	return ValidHeader(prefix[:HeaderLen])
+}
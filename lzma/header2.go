@@ -15,6 +15,8 @@ const (
 	maxCompressed = 1 << 16
 	// maximum size of uncompressed data in a chunk
 	maxUncompressed = 1 << 21
+	// maximum size of data in an uncompressed chunk
+	maxUncompressedChunk = 1 << 16
 )
 
 // chunkType represents the type of an LZMA2 chunk. Note that this
@@ -179,8 +181,15 @@ func (h *chunkHeader) UnmarshalBinary(data []byte) error {
 	return err
 }
 
+// ErrChunkTooLarge indicates a chunk whose size exceeds the limits of
+// the LZMA2 format. An uncompressed chunk may hold 64 KiB, a compressed
+// chunk 64 KiB of compressed data that decompresses to at most 2 MiB.
+var ErrChunkTooLarge = errors.New("lzma: chunk too large")
+
 // MarshalBinary encodes the chunk header value. The function checks
-// whether the content of the chunk header is correct.
+// whether the content of the chunk header is correct. Sizes that
+// cannot be represented by the header are rejected with
+// ErrChunkTooLarge instead of being truncated.
 func (h *chunkHeader) MarshalBinary() (data []byte, err error) {
 	if h.ctype > cLRND {
 		return nil, errors.New("invalid chunk type")
@@ -188,6 +197,18 @@ func (h *chunkHeader) MarshalBinary() (data []byte, err error) {
 	if err = h.props.verify(); err != nil {
 		return nil, err
 	}
+	// The header stores the sizes minus one.
+	switch {
+	case h.ctype == cEOS:
+	case h.ctype <= cU:
+		if h.uncompressed >= maxUncompressedChunk {
+			return nil, ErrChunkTooLarge
+		}
+	default:
+		if h.uncompressed >= maxUncompressed {
+			return nil, ErrChunkTooLarge
+		}
+	}
 
 	data = make([]byte, headerLen(h.ctype))
 
@@ -225,23 +246,30 @@ func (h *chunkHeader) MarshalBinary() (data []byte, err error) {
 
 // readChunkHeader reads the chunk header from the IO reader.
 func readChunkHeader(r io.Reader) (h *chunkHeader, err error) {
-	p := make([]byte, 1, 6)
-	if _, err = io.ReadFull(r, p); err != nil {
-		return
+	h = new(chunkHeader)
+	if err = readChunkHeaderBuf(r, h, make([]byte, 6)); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// readChunkHeaderBuf reads a chunk header into h using the buffer p,
+// which must have a length of at least 6 bytes. The function allows the
+// reuse of the header and the buffer.
+func readChunkHeaderBuf(r io.Reader, h *chunkHeader, p []byte) error {
+	p = p[:1]
+	if _, err := io.ReadFull(r, p); err != nil {
+		return err
 	}
 	c, err := headerChunkType(p[0])
 	if err != nil {
-		return
+		return err
 	}
 	p = p[:headerLen(c)]
 	if _, err = io.ReadFull(r, p[1:]); err != nil {
-		return
-	}
-	h = new(chunkHeader)
-	if err = h.UnmarshalBinary(p); err != nil {
-		return nil, err
+		return err
 	}
-	return h, nil
+	return h.UnmarshalBinary(p)
 }
 
 // uint16BE converts a big-endian uint16 representation to an uint16
@@ -377,6 +405,38 @@ func DecodeDictCap(c byte) (n int64, err error) {
 	return decodeDictCap(c), nil
 }
 
+// NearestDictCap returns the smallest dictionary capacity that can be
+// represented by the LZMA2 dictionary capacity encoding and is greater
+// or equal n. If n exceeds the maximum supported dictionary capacity,
+// the maximum value is returned.
+func NearestDictCap(n int64) int64 {
+	c, err := DecodeDictCap(EncodeDictCap(n))
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// maxSuggestedDictCap is the largest capacity returned by
+// SuggestDictCap. It is the capacity used by the highest xz preset.
+const maxSuggestedDictCap = 64 << 20
+
+// SuggestDictCap returns a dictionary capacity for compressing size
+// bytes. A dictionary larger than the input wastes memory, so the
+// function returns the smallest capacity representable by the LZMA2
+// dictionary capacity encoding that covers size bytes. The result is
+// never less than MinDictCap and never larger than 64 MiB, the
+// capacity used by the highest xz preset.
+func SuggestDictCap(size int64) int64 {
+	switch {
+	case size < MinDictCap:
+		size = MinDictCap
+	case size > maxSuggestedDictCap:
+		size = maxSuggestedDictCap
+	}
+	return NearestDictCap(size)
+}
+
 // EncodeDictCap encodes a dictionary capacity. The function returns the
 // code for the capacity that is greater or equal n. If n exceeds the
 // maximum support dictionary capacity, the maximum value is returned.
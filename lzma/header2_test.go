@@ -151,3 +151,91 @@ func TestReadEOS(t *testing.T) {
 		t.Errorf("props got %v; want %v", h.props, wantProps)
 	}
 }
+
+func TestNearestDictCap(t *testing.T) {
+	tests := []struct {
+		n, want int64
+	}{
+		{1, 1 << 12},
+		{1 << 12, 1 << 12},
+		{1<<12 + 1, 3 << 11},
+		{5000000, 3 << 21},
+		{1 << 30, 1 << 30},
+		{MaxDictCap, MaxDictCap},
+		{1 << 40, MaxDictCap},
+	}
+	for _, tc := range tests {
+		if c := NearestDictCap(tc.n); c != tc.want {
+			t.Errorf("NearestDictCap(%d) = %d; want %d", tc.n, c,
+				tc.want)
+		}
+	}
+}
+
+func TestSuggestDictCap(t *testing.T) {
+	tests := []struct {
+		n, want int64
+	}{
+		{0, MinDictCap},
+		{100, MinDictCap},
+		{100 << 10, 1 << 17},
+		{3 << 20, 3 << 20},
+		{1 << 40, 64 << 20},
+	}
+	for _, tc := range tests {
+		c := SuggestDictCap(tc.n)
+		if c != tc.want {
+			t.Errorf("SuggestDictCap(%d) = %d; want %d", tc.n, c,
+				tc.want)
+		}
+		if NearestDictCap(c) != c {
+			t.Errorf("SuggestDictCap(%d) = %d isn't representable",
+				tc.n, c)
+		}
+	}
+}
+
+func TestChunkHeaderTooLarge(t *testing.T) {
+	tests := []struct {
+		h   chunkHeader
+		err error
+	}{
+		{chunkHeader{ctype: cU, uncompressed: maxUncompressedChunk - 1}, nil},
+		{chunkHeader{ctype: cUD, uncompressed: maxUncompressedChunk},
+			ErrChunkTooLarge},
+		{chunkHeader{ctype: cL, uncompressed: maxUncompressed - 1,
+			compressed: maxCompressed - 1}, nil},
+		{chunkHeader{ctype: cLR, uncompressed: maxUncompressed},
+			ErrChunkTooLarge},
+	}
+	for _, tc := range tests {
+		data, err := tc.h.MarshalBinary()
+		if err != tc.err {
+			t.Errorf("%s: MarshalBinary error %v; want %v", &tc.h,
+				err, tc.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var g chunkHeader
+		if err = g.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary error %s", err)
+		}
+		if g != tc.h {
+			t.Errorf("got %s; want %s", &g, &tc.h)
+		}
+	}
+
+	// The largest uncompressed size that can be encoded in a
+	// header is the limit of the format.
+	data := []byte{hLRND | 0x1f, 0xff, 0xff, 0x00, 0x00, 0x5d}
+	var h chunkHeader
+	if err := h.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if h.uncompressed+1 != maxUncompressed {
+		t.Fatalf("uncompressed size %d; want %d", h.uncompressed+1,
+			maxUncompressed)
+	}
+}
@@ -4,7 +4,11 @@
 
 package lzma
 
-import "testing"
+import (
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
 
 func TestHeaderMarshalling(t *testing.T) {
 	tests := []header{
@@ -50,3 +54,24 @@ func TestValidHeader(t *testing.T) {
 		t.Errorf("ValidHeader returns true for %s; want false", a)
 	}
 }
+
+func TestDetect(t *testing.T) {
+	data, err := ioutil.ReadFile("fox.lzma")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	if !Detect(data) {
+		t.Errorf("Detect returns false for fox.lzma; want true")
+	}
+	if Detect(data[:HeaderLen-1]) {
+		t.Errorf("Detect returns true for short prefix; want false")
+	}
+	rnd := rand.New(rand.NewSource(1))
+	p := make([]byte, HeaderLen)
+	for i := 0; i < 100; i++ {
+		rnd.Read(p)
+		if Detect(p) {
+			t.Errorf("Detect returns true for random data %v", p)
+		}
+	}
+}
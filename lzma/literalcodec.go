@@ -28,7 +28,9 @@ func (c *literalCodec) init(lc, lp int) {
 	case !(minLP <= lp && lp <= maxLP):
 		panic("lp out of range")
 	}
-	c.probs = make([]prob, 0x300<<uint(lc+lp))
+	if n := 0x300 << uint(lc+lp); len(c.probs) != n {
+		c.probs = make([]prob, n)
+	}
 	for i := range c.probs {
 		c.probs[i] = probInit
 	}
@@ -41,12 +41,70 @@ func (a MatchAlgorithm) verify() error {
 	return nil
 }
 
-func (a MatchAlgorithm) new(dictCap int) (m matcher, err error) {
+// new creates a matcher for the algorithm. The matcher stops searching
+// for longer matches if a match of length niceLen has been found. A
+// non-positive niceLen selects the maximum match length. The hash
+// table has 2^hashBits entries; a non-positive value derives the size
+// from the dictionary capacity. The binary tree doesn't use hashBits.
+func (a MatchAlgorithm) new(dictCap, niceLen, hashBits int,
+) (m matcher, err error) {
 	switch a {
 	case HashTable4:
-		return newHashTable(dictCap, 4)
+		if hashBits <= 0 {
+			hashBits = hashTableExponent(uint32(dictCap))
+		}
+		t, err := newHashTableBits(dictCap, 4, hashBits)
+		if err != nil {
+			return nil, err
+		}
+		if niceLen > 0 {
+			t.niceLen = niceLen
+		}
+		return t, nil
 	case BinaryTree:
-		return newBinTree(dictCap)
+		t, err := newBinTree(dictCap)
+		if err != nil {
+			return nil, err
+		}
+		if niceLen > 0 {
+			t.niceLen = niceLen
+		}
+		return t, nil
 	}
 	return nil, errUnsupportedMatchAlgorithm
 }
+
+// memUsage estimates the number of bytes used by the matcher.
+func (a MatchAlgorithm) memUsage(dictCap, hashBits int) int64 {
+	switch a {
+	case HashTable4:
+		if hashBits <= 0 {
+			hashBits = hashTableExponent(uint32(dictCap))
+		}
+		return 8<<uint(hashBits) + 4*int64(dictCap)
+	case BinaryTree:
+		return 16 * int64(dictCap)
+	}
+	return 0
+}
+
+// verifyHashBits checks whether the number of hash bits is supported.
+// Zero is accepted and selects the default.
+func verifyHashBits(hashBits int) error {
+	if hashBits == 0 {
+		return nil
+	}
+	if !(minTableExponent <= hashBits && hashBits <= maxHashBits) {
+		return errors.New("lzma: hash bits out of range")
+	}
+	return nil
+}
+
+// verifyNiceLen checks whether the nice length is in the range of
+// supported match lengths.
+func verifyNiceLen(niceLen int) error {
+	if !(minMatchLen <= niceLen && niceLen <= maxMatchLen) {
+		return errors.New("lzma: nice length is out of range")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+// MatchFinder is the extension point for custom match finders, which
+// can be supplied by Writer2Config.NewMatchFinder instead of one of the
+// match algorithms provided by the package.
+//
+// The encoder calls FindMatch for the data at the head of the
+// dictionary and writes the data it has encoded to the finder, so the
+// finder sees every byte once and in order. Matches returned by
+// FindMatch are verified against the dictionary. Invalid matches are
+// shortened or replaced by a literal, so a faulty finder reduces the
+// compression ratio but never corrupts the stream.
+type MatchFinder interface {
+	// Write adds data that has been encoded to the history searched
+	// by the finder. The data includes a preset dictionary. Write
+	// must consume all data.
+	Write(p []byte) (n int, err error)
+	// FindMatch returns the distance and the length of a match for
+	// the start of lookahead in the history. Distance 1 refers to
+	// the last byte written. The length may exceed the distance. A
+	// length less than 2 requests a literal. The lookahead contains
+	// at most 273 bytes and is only valid during the call.
+	FindMatch(lookahead []byte) (distance, length int)
+}
+
+// finderMatcher adapts a MatchFinder to the matcher interface.
+type finderMatcher struct {
+	dict *encoderDict
+	mf   MatchFinder
+}
+
+// SetDict sets the dictionary of the matcher.
+func (m *finderMatcher) SetDict(d *encoderDict) { m.dict = d }
+
+// Write forwards the encoded data to the match finder.
+func (m *finderMatcher) Write(p []byte) (n int, err error) {
+	return m.mf.Write(p)
+}
+
+// NextOp asks the match finder for a match and checks it.
+func (m *finderMatcher) NextOp(rep [4]uint32) operation {
+	data := m.dict.data[:maxMatchLen]
+	n, _ := m.dict.buf.Peek(data)
+	data = data[:n]
+	dist, k := m.mf.FindMatch(data)
+	if k > n {
+		k = n
+	}
+	if k < minMatchLen || !(0 < dist && dist <= m.dict.DictLen()) {
+		return lit{data[0]}
+	}
+	if k = m.dict.buf.matchLen(dist, data[:k]); k < minMatchLen {
+		return lit{data[0]}
+	}
+	return match{int64(dist), k}
+}
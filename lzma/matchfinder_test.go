@@ -0,0 +1,118 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// runFinder finds runs of the byte written last.
+type runFinder struct {
+	last    byte
+	written bool
+}
+
+func (f *runFinder) Write(p []byte) (n int, err error) {
+	if len(p) > 0 {
+		f.last, f.written = p[len(p)-1], true
+	}
+	return len(p), nil
+}
+
+func (f *runFinder) FindMatch(lookahead []byte) (distance, length int) {
+	if !f.written {
+		return 0, 0
+	}
+	for length < len(lookahead) && lookahead[length] == f.last {
+		length++
+	}
+	return 1, length
+}
+
+// The example compresses runs of bytes using a custom match finder.
+func Example_matchFinder() {
+	cfg := Writer2Config{
+		NewMatchFinder: func(dictCap int) MatchFinder {
+			return new(runFinder)
+		},
+	}
+	var buf bytes.Buffer
+	w, err := cfg.NewWriter2(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("aaaaaaaabbbbbbbbbbbbcccc"), 1000)
+	if _, err = w.Write(data); err != nil {
+		log.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	r, err := NewReader2(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(bytes.Equal(out, data))
+	// Output:
+	// true
+}
+
+// randomFinder returns arbitrary matches.
+type randomFinder struct {
+	rnd *rand.Rand
+}
+
+func (f randomFinder) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+func (f randomFinder) FindMatch(lookahead []byte) (distance, length int) {
+	return f.rnd.Intn(1<<16) - 8, f.rnd.Intn(300)
+}
+
+func TestMatchFinderInvalidMatches(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(3)), 100000)
+	data := txt.Bytes()
+	cfg := Writer2Config{
+		NewMatchFinder: func(dictCap int) MatchFinder {
+			return randomFinder{rand.New(rand.NewSource(1))}
+		},
+	}
+	var buf bytes.Buffer
+	w, err := cfg.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// LZMA2 has no in-band marker that could be used to delimit messages.
+// WriteMessage and ReadMessage use the convention that every message is
+// preceded by its length encoded as uvarint in the uncompressed data.
+// WriteMessage flushes the writer after every message, so the reader
+// can decode the message as soon as it has been written. The dictionary
+// is not reset between messages.
+
+// WriteMessage writes the message p into the LZMA2 stream and flushes
+// the writer, so that the message can be read completely by a reader
+// using ReadMessage.
+func (w *Writer2) WriteMessage(p []byte) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	k := binary.PutUvarint(lenBuf[:], uint64(len(p)))
+	if _, err := w.Write(lenBuf[:k]); err != nil {
+		return err
+	}
+	if _, err := w.Write(p); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// errMessageLen indicates that the length of a message is too large.
+var errMessageLen = errors.New("lzma: message length out of range")
+
+// ReadMessage reads the next message written by WriteMessage from the
+// LZMA2 stream. It returns io.EOF if the stream ends before a new
+// message starts.
+func (r *Reader2) ReadMessage() (p []byte, err error) {
+	u, err := binary.ReadUvarint(ByteReader(r))
+	if err != nil {
+		return nil, err
+	}
+	n := int64(u)
+	if n < 0 || uint64(n) != u {
+		return nil, errMessageLen
+	}
+	// The buffer grows with the data read, so a corrupt length
+	// doesn't lead to a huge allocation.
+	var buf bytes.Buffer
+	k, err := io.CopyN(&buf, r, n)
+	if err != nil {
+		if err == io.EOF && k < n {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
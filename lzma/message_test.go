@@ -0,0 +1,54 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestMessages(t *testing.T) {
+	rnd := rand.New(rand.NewSource(11))
+	msgs := make([][]byte, 20)
+	for i := range msgs {
+		msgs[i] = bytes.Repeat([]byte{byte('a' + i)}, rnd.Intn(5000))
+	}
+	msgs[3] = nil
+
+	var buf bytes.Buffer
+	w, err := NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	var r *Reader2
+	for i, m := range msgs {
+		if err = w.WriteMessage(m); err != nil {
+			t.Fatalf("WriteMessage error %s", err)
+		}
+		if i == 0 {
+			if r, err = NewReader2(&buf); err != nil {
+				t.Fatalf("NewReader2 error %s", err)
+			}
+		}
+		// the message must be readable right after it has been
+		// written
+		p, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage error %s", err)
+		}
+		if !bytes.Equal(p, m) {
+			t.Fatalf("message %d has length %d; want %d", i,
+				len(p), len(m))
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if _, err = r.ReadMessage(); err != io.EOF {
+		t.Fatalf("ReadMessage returned %v; want %v", err, io.EOF)
+	}
+}
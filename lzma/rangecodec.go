@@ -134,27 +134,37 @@ type rangeDecoder struct {
 // newRangeDecoder initializes a range decoder. It reads five bytes from the
 // reader and therefore may return an error.
 func newRangeDecoder(br io.ByteReader) (d *rangeDecoder, err error) {
-	d = &rangeDecoder{br: br, nrange: 0xffffffff}
+	d = new(rangeDecoder)
+	if err = d.init(br); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// init initializes the range decoder for a new stream. It reads five
+// bytes from the reader.
+func (d *rangeDecoder) init(br io.ByteReader) error {
+	*d = rangeDecoder{br: br, nrange: 0xffffffff}
 
 	b, err := d.br.ReadByte()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if b != 0 {
-		return nil, errors.New("newRangeDecoder: first byte not zero")
+		return errors.New("newRangeDecoder: first byte not zero")
 	}
 
 	for i := 0; i < 4; i++ {
 		if err = d.updateCode(); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	if d.code >= d.nrange {
-		return nil, errors.New("newRangeDecoder: d.code >= d.nrange")
+		return errors.New("newRangeDecoder: d.code >= d.nrange")
 	}
 
-	return d, nil
+	return nil
 }
 
 // possiblyAtEnd checks whether the decoder may be at the end of the stream.
@@ -19,6 +19,10 @@ import (
 // format.
 type ReaderConfig struct {
 	DictCap int
+	// Lazy requests the reader to consume only the compressed data
+	// required to satisfy the current Read call instead of filling
+	// the whole dictionary buffer. This reduces the throughput.
+	Lazy bool
 }
 
 // fill converts the zero values of the configuration to the default values.
@@ -78,7 +82,7 @@ func (c ReaderConfig) NewReader(lzma io.Reader) (r *Reader, err error) {
 	}
 
 	state := newState(r.h.properties)
-	dict, err := newDecoderDict(dictCap)
+	dict, err := newDecoderDict(dictCap, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -86,6 +90,7 @@ func (c ReaderConfig) NewReader(lzma io.Reader) (r *Reader, err error) {
 	if err != nil {
 		return nil, err
 	}
+	r.d.lazy = c.Lazy
 	return r, nil
 }
 
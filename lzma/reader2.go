@@ -15,6 +15,27 @@ import (
 // format.
 type Reader2Config struct {
 	DictCap int
+	// ExpectedSize provides the exact uncompressed size of the
+	// chunk sequence. If it is positive the reader returns io.EOF
+	// after ExpectedSize bytes without reading further chunk
+	// headers, so the chunk sequence doesn't need an end-of-stream
+	// chunk. Zero means that the size is unknown.
+	ExpectedSize int64
+	// Lazy requests the reader to consume only the compressed data
+	// required to satisfy the current Read call instead of filling
+	// the whole dictionary buffer. This reduces the throughput.
+	Lazy bool
+	// Allocator provides the memory for the dictionary. The
+	// memory is returned to the allocator by Close. If Allocator is
+	// nil the memory is allocated on the Go heap.
+	Allocator Allocator
+	// Window limits the match distances to the dictionary capacity
+	// declared by the container format, for instance by the LZMA2
+	// filter property of an xz block, which may be smaller than
+	// DictCap. Larger distances are reported as ErrCorrupt, because
+	// the encoder cannot have produced them. Zero permits distances
+	// up to DictCap.
+	Window int
 }
 
 // fill converts the zero values of the configuration to the default values.
@@ -31,6 +52,12 @@ func (c *Reader2Config) Verify() error {
 	if !(MinDictCap <= c.DictCap && int64(c.DictCap) <= MaxDictCap) {
 		return errors.New("lzma: dictionary capacity is out of range")
 	}
+	if c.ExpectedSize < 0 {
+		return errors.New("lzma: negative expected size")
+	}
+	if !(0 <= c.Window && c.Window <= c.DictCap) {
+		return errors.New("lzma: window out of range")
+	}
 	return nil
 }
 
@@ -48,6 +75,21 @@ type Reader2 struct {
 	chunkReader io.Reader
 
 	cstate chunkState
+	// index of the current chunk in the sequence
+	chunk int
+
+	// remaining uncompressed bytes; negative if the size is unknown
+	remaining int64
+	lazy      bool
+	// limited readers for the compressed chunks
+	clr io.LimitedReader
+	cbr breader
+	lbr limitedByteReader
+	// allocator for the dictionary
+	alloc Allocator
+	// reused chunk header and buffer for reading it
+	header chunkHeader
+	hbuf   [6]byte
 }
 
 // NewReader2 creates a reader for an LZMA2 chunk sequence.
@@ -57,14 +99,37 @@ func NewReader2(lzma2 io.Reader) (r *Reader2, err error) {
 
 // NewReader2 creates an LZMA2 reader using the given configuration.
 func (c Reader2Config) NewReader2(lzma2 io.Reader) (r *Reader2, err error) {
+	return c.NewReader2Dict(lzma2, nil)
+}
+
+// NewReader2Dict creates an LZMA2 reader with a dictionary preset to the
+// data in dict. This supports the decoding of chunk sequences that
+// continue a previous sequence without resetting the dictionary, for
+// instance the streams created by Writer2Config.NewWriter2Dict. The
+// first chunk must not depend on the decoder state of the previous
+// sequence. If dict is empty the function is equivalent to
+// NewReader2.
+func (c Reader2Config) NewReader2Dict(lzma2 io.Reader, dict []byte,
+) (r *Reader2, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	r = &Reader2{r: lzma2, cstate: start}
-	r.dict, err = newDecoderDict(c.DictCap)
+	r = &Reader2{r: lzma2, cstate: start, chunk: -1, remaining: -1,
+		lazy: c.Lazy, alloc: c.Allocator}
+	if c.ExpectedSize > 0 {
+		r.remaining = c.ExpectedSize
+	}
+	r.dict, err = newDecoderDict(c.DictCap, c.Allocator)
 	if err != nil {
 		return nil, err
 	}
+	r.dict.window = c.Window
+	if len(dict) > 0 {
+		r.dict.preset(dict)
+		// A dictionary reset is not required, but the first
+		// compressed chunk must provide the properties.
+		r.cstate = 'R'
+	}
 	if err = r.startChunk(); err != nil {
 		r.err = err
 	}
@@ -80,7 +145,9 @@ func uncompressed(ctype chunkType) bool {
 // startChunk parses a new chunk.
 func (r *Reader2) startChunk() error {
 	r.chunkReader = nil
-	header, err := readChunkHeader(r.r)
+	r.chunk++
+	header := &r.header
+	err := readChunkHeaderBuf(r.r, header, r.hbuf[:])
 	if err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
@@ -103,17 +170,30 @@ func (r *Reader2) startChunk() error {
 			r.ur.Reopen(r.r, size)
 		} else {
 			r.ur = newUncompressedReader(r.r, r.dict, size)
+			r.ur.lazy = r.lazy
 		}
 		r.chunkReader = r.ur
 		return nil
 	}
-	br := ByteReader(io.LimitReader(r.r, int64(header.compressed)+1))
+	var br io.ByteReader
+	n := int64(header.compressed) + 1
+	if rbr, ok := r.r.(io.ByteReader); ok {
+		r.lbr = limitedByteReader{br: rbr, n: n}
+		br = &r.lbr
+	} else {
+		r.clr = io.LimitedReader{R: r.r, N: n}
+		if r.cbr.p == nil {
+			r.cbr = breader{&r.clr, make([]byte, 1)}
+		}
+		br = &r.cbr
+	}
 	if r.decoder == nil {
 		state := newState(header.props)
 		r.decoder, err = newDecoder(br, state, r.dict, size)
 		if err != nil {
 			return err
 		}
+		r.decoder.lazy = r.lazy
 		r.chunkReader = r.decoder
 		return nil
 	}
@@ -121,7 +201,8 @@ func (r *Reader2) startChunk() error {
 	case cLR:
 		r.decoder.State.Reset()
 	case cLRN, cLRND:
-		r.decoder.State = newState(header.props)
+		r.decoder.State.Properties = header.props
+		r.decoder.State.Reset()
 	}
 	err = r.decoder.Reopen(br, size)
 	if err != nil {
@@ -133,6 +214,30 @@ func (r *Reader2) startChunk() error {
 
 // Read reads data from the LZMA2 chunk sequence.
 func (r *Reader2) Read(p []byte) (n int, err error) {
+	if r.remaining < 0 {
+		return r.read(p)
+	}
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err = r.read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF {
+		// end-of-stream chunk before the expected size
+		r.err = io.ErrUnexpectedEOF
+		return n, r.err
+	}
+	if err == nil && r.remaining == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// read reads data from the LZMA2 chunk sequence.
+func (r *Reader2) read(p []byte) (n int, err error) {
 	if r.err != nil {
 		return 0, r.err
 	}
@@ -158,18 +263,55 @@ func (r *Reader2) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// errReaderClosed indicates that the reader has been closed.
+var errReaderClosed = errors.New("lzma: reader closed")
+
+// Close returns the memory of the dictionary to the allocator provided
+// by the configuration. The reader cannot be used afterwards.
+func (r *Reader2) Close() error {
+	if r.err == errReaderClosed {
+		return errReaderClosed
+	}
+	r.err = errReaderClosed
+	if r.alloc != nil {
+		r.alloc.Free(r.dict.buf.data)
+		r.dict.buf.data = nil
+	}
+	return nil
+}
+
 // EOS returns whether the LZMA2 stream has been terminated by an
 // end-of-stream chunk.
 func (r *Reader2) EOS() bool {
 	return r.cstate == stop
 }
 
+// Chunk returns the index of the chunk currently read, starting at
+// zero for the first chunk of the sequence. It is useful to locate
+// errors in the chunk sequence.
+func (r *Reader2) Chunk() int {
+	return r.chunk
+}
+
+// Dict returns a copy of the current dictionary content, which
+// includes data not yet returned by Read. After the chunk sequence has
+// been read completely, the result can be used as dictionary for
+// NewReader2Dict to decode a sequence continuing this one.
+func (r *Reader2) Dict() []byte {
+	if r.err == errReaderClosed {
+		return nil
+	}
+	return r.dict.history()
+}
+
 // uncompressedReader is used to read uncompressed chunks.
 type uncompressedReader struct {
 	lr   io.LimitedReader
 	Dict *decoderDict
 	eof  bool
 	err  error
+	// lazy requests to copy only the data requested by Read
+	lazy bool
 }
 
 // newUncompressedReader initializes a new uncompressedReader.
@@ -188,10 +330,15 @@ func (ur *uncompressedReader) Reopen(r io.Reader, size int64) {
 	ur.lr = io.LimitedReader{R: r, N: size}
 }
 
-// fill reads uncompressed data into the dictionary.
-func (ur *uncompressedReader) fill() error {
+// fill reads uncompressed data into the dictionary. If the reader is
+// lazy only want bytes are read.
+func (ur *uncompressedReader) fill(want int) error {
 	if !ur.eof {
-		n, err := io.CopyN(ur.Dict, &ur.lr, int64(ur.Dict.Available()))
+		m := ur.Dict.Available()
+		if ur.lazy && want < m {
+			m = want
+		}
+		n, err := io.CopyN(ur.Dict, &ur.lr, int64(m))
 		if err != io.EOF {
 			return err
 		}
@@ -221,7 +368,7 @@ func (ur *uncompressedReader) Read(p []byte) (n int, err error) {
 		if err != nil {
 			break
 		}
-		err = ur.fill()
+		err = ur.fill(len(p) - n)
 		if err != nil {
 			break
 		}
@@ -112,6 +112,84 @@ func TestReaderAll(t *testing.T) {
 	}
 }
 
+func TestReaderEOSVariants(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  WriterConfig
+		eos  bool
+	}{
+		{"size and EOS", WriterConfig{SizeInHeader: true,
+			EOSMarker: true}, true},
+		{"size only", WriterConfig{SizeInHeader: true}, false},
+		{"EOS only", WriterConfig{}, true},
+	}
+	lengths := []int{0, 1, 2, 300, 5000}
+	for _, tc := range tests {
+		for _, n := range lengths {
+			data := bytes.Repeat([]byte("abc"), n)[:n]
+			cfg := tc.cfg
+			if cfg.SizeInHeader {
+				cfg.Size = int64(n)
+			}
+			var buf bytes.Buffer
+			w, err := cfg.NewWriter(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			if _, err = w.Write(data); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatalf("%s %d bytes: NewReader error %s",
+					tc.name, n, err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s %d bytes: ReadAll error %s",
+					tc.name, n, err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("%s %d bytes: decoded data differs",
+					tc.name, n)
+			}
+			if r.EOSMarker() != tc.eos {
+				t.Fatalf("%s %d bytes: EOSMarker %t; want %t",
+					tc.name, n, r.EOSMarker(), tc.eos)
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("%s %d bytes: %d bytes not read",
+					tc.name, n, buf.Len())
+			}
+		}
+	}
+
+	// The EOS marker before the declared size is an error.
+	var buf bytes.Buffer
+	w, err := WriterConfig{}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write([]byte("abcabc")); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+	putUint64LE(data[5:], 7)
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != errSize {
+		t.Fatalf("ReadAll returned %v; want %v", err, errSize)
+	}
+}
+
 func Example_reader() {
 	f, err := os.Open("fox.lzma")
 	if err != nil {
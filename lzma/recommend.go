@@ -0,0 +1,69 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "bytes"
+
+// maxRecommendSample limits the part of the sample that is compressed
+// by RecommendProperties.
+const maxRecommendSample = 256 * 1024
+
+// RecommendProperties selects the properties that compress the sample
+// best by compressing it with every candidate. The candidates have LP
+// and PB values from 0 to 2, which covers data aligned to 1, 2 and 4
+// bytes, and LC values respecting the LZMA2 limit LC+LP <= 4. Only the
+// first 256 KiB of the sample are used; since every candidate requires
+// a complete compression run, small samples are recommended. The
+// default properties LC 3, LP 0 and PB 2 are returned if no candidate
+// compresses the sample better or if the sample is empty.
+func RecommendProperties(sample []byte) Properties {
+	best := Properties{LC: 3, LP: 0, PB: 2}
+	if len(sample) == 0 {
+		return best
+	}
+	if len(sample) > maxRecommendSample {
+		sample = sample[:maxRecommendSample]
+	}
+	var buf bytes.Buffer
+	bestSize := trialSize(&buf, sample, best)
+	def := best
+	for pb := 0; pb <= 2; pb++ {
+		for lp := 0; lp <= 2; lp++ {
+			for lc := 0; lc+lp <= 4; lc++ {
+				p := Properties{LC: lc, LP: lp, PB: pb}
+				if p == def {
+					continue
+				}
+				if n := trialSize(&buf, sample, p); n < bestSize {
+					best, bestSize = p, n
+				}
+			}
+		}
+	}
+	return best
+}
+
+// trialSize returns the size of the LZMA2 chunk sequence for the sample
+// compressed with the given properties. The buffer is used for the
+// output.
+func trialSize(buf *bytes.Buffer, sample []byte, p Properties) int {
+	buf.Reset()
+	dictCap := len(sample)
+	if dictCap < MinDictCap {
+		dictCap = MinDictCap
+	}
+	w, err := Writer2Config{Properties: &p, DictCap: dictCap}.NewWriter2(
+		buf)
+	if err != nil {
+		panic(err)
+	}
+	if _, err = w.Write(sample); err != nil {
+		panic(err)
+	}
+	if err = w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Len()
+}
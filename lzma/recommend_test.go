@@ -0,0 +1,107 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// cyrillicText returns UTF-8 text of two-byte characters created by
+// mapping the ASCII letters of random text to the Cyrillic alphabet.
+func cyrillicText(n int64) []byte {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(71)), n)
+	var out bytes.Buffer
+	for _, c := range txt.Bytes() {
+		switch {
+		case 'a' <= c && c <= 'z':
+			out.WriteRune(rune(0x430 + int(c-'a')))
+		case 'A' <= c && c <= 'Z':
+			out.WriteRune(rune(0x410 + int(c-'A')))
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.Bytes()
+}
+
+// int32Table returns a table of slowly growing little-endian 32-bit
+// integers.
+func int32Table(n int) []byte {
+	rng := rand.New(rand.NewSource(72))
+	p := make([]byte, 4*n)
+	var v uint32 = 1 << 20
+	for i := 0; i < n; i++ {
+		v += uint32(rng.Intn(512))
+		binary.LittleEndian.PutUint32(p[4*i:], v)
+	}
+	return p
+}
+
+func TestRecommendProperties(t *testing.T) {
+	def := Properties{LC: 3, LP: 0, PB: 2}
+	if p := RecommendProperties(nil); p != def {
+		t.Fatalf("RecommendProperties(nil) returned %v; want %v",
+			&p, &def)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"UTF-8 text", cyrillicText(40000)},
+		{"32-bit table", int32Table(20000)},
+	}
+	var props []Properties
+	var buf bytes.Buffer
+	for _, tc := range tests {
+		p := RecommendProperties(tc.data)
+		n := trialSize(&buf, tc.data, p)
+		m := trialSize(&buf, tc.data, def)
+		t.Logf("%s: %v %d bytes; default %d bytes", tc.name, &p, n, m)
+		if n >= m {
+			t.Fatalf("%s: recommended properties %v don't "+
+				"improve on the default", tc.name, &p)
+		}
+
+		// the properties must work end-to-end
+		buf.Reset()
+		w, err := Writer2Config{Properties: &p}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(tc.data); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		r, err := NewReader2(&buf)
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		var out bytes.Buffer
+		if _, err = io.Copy(&out, r); err != nil {
+			t.Fatalf("io.Copy error %s", err)
+		}
+		if !bytes.Equal(out.Bytes(), tc.data) {
+			t.Fatalf("%s: decompressed data differs", tc.name)
+		}
+		props = append(props, p)
+	}
+	if props[0] == props[1] {
+		t.Fatalf("same properties %v for text and 32-bit table",
+			&props[0])
+	}
+	if props[1].LP != 2 && props[1].PB != 2 {
+		t.Fatalf("32-bit table: properties %v not aligned to 4 bytes",
+			&props[1])
+	}
+}
@@ -0,0 +1,420 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// snapshotVersion identifies the format of the snapshots created by
+// Reader2.Snapshot.
+const snapshotVersion = 1
+
+// errNoChunkBoundary indicates that the reader is not positioned at a
+// chunk boundary.
+var errNoChunkBoundary = errors.New(
+	"lzma: snapshot requires the reader to be at a chunk boundary")
+
+// errSnapshot indicates a malformed snapshot.
+var errSnapshot = errors.New("lzma: invalid snapshot")
+
+// probSlices returns all probability slices of the state in a fixed
+// order.
+func (s *state) probSlices() [][]prob {
+	ps := [][]prob{
+		s.isMatch[:], s.isRepG0Long[:], s.isRep[:],
+		s.isRepG0[:], s.isRepG1[:], s.isRepG2[:],
+		s.litCodec.probs,
+	}
+	for _, lc := range []*lengthCodec{&s.lenCodec, &s.repLenCodec} {
+		ps = append(ps, lc.choice[:])
+		for i := range lc.low {
+			ps = append(ps, lc.low[i].probs)
+		}
+		for i := range lc.mid {
+			ps = append(ps, lc.mid[i].probs)
+		}
+		ps = append(ps, lc.high.probs)
+	}
+	dc := &s.distCodec
+	for i := range dc.posSlotCodecs {
+		ps = append(ps, dc.posSlotCodecs[i].probs)
+	}
+	for i := range dc.posModel {
+		ps = append(ps, dc.posModel[i].probs)
+	}
+	return append(ps, dc.alignCodec.probs)
+}
+
+// history returns the data of the dictionary in the order it has been
+// written. The function must only be called if no data is buffered for
+// reading.
+func (d *decoderDict) history() []byte {
+	n := d.dictLen()
+	p := make([]byte, n)
+	i := d.buf.front - n
+	if i < 0 {
+		i += len(d.buf.data)
+	}
+	k := copy(p, d.buf.data[i:])
+	copy(p[k:], d.buf.data[:d.buf.front])
+	return p
+}
+
+// atChunkBoundary checks whether the current chunk has been read
+// completely, so the underlying reader is positioned at the next chunk
+// header.
+func (r *Reader2) atChunkBoundary() bool {
+	if r.dict.buf.Buffered() > 0 {
+		return false
+	}
+	switch {
+	case r.chunkReader == nil:
+		return false
+	case r.chunkReader == r.ur:
+		return r.ur.lr.N == 0
+	}
+	return r.decoder.eos
+}
+
+// Snapshot returns the complete state of the reader, which includes the
+// dictionary content and the probability model. The reader must have
+// read all data of the chunks consumed so far from the underlying
+// reader, which means a Read call must have ended exactly at a chunk
+// boundary. The snapshot can be used to continue reading by calling
+// Reader2Config.NewReader2Snapshot with a reader positioned directly
+// behind the last chunk consumed. The size of the snapshot is
+// dominated by the dictionary size.
+func (r *Reader2) Snapshot() ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !r.atChunkBoundary() {
+		return nil, errNoChunkBoundary
+	}
+	var (
+		buf bytes.Buffer
+		a   [binary.MaxVarintLen64]byte
+	)
+	putUvarint := func(u uint64) {
+		buf.Write(a[:binary.PutUvarint(a[:], u)])
+	}
+	buf.WriteByte(snapshotVersion)
+	buf.WriteByte(byte(r.cstate))
+	putUvarint(uint64(r.dict.buf.Cap()))
+	putUvarint(uint64(r.dict.head))
+	buf.Write(a[:binary.PutVarint(a[:], r.remaining)])
+	h := r.dict.history()
+	putUvarint(uint64(len(h)))
+	buf.Write(h)
+	if r.decoder == nil {
+		buf.WriteByte(0)
+		return buf.Bytes(), nil
+	}
+	s := r.decoder.State
+	buf.WriteByte(1)
+	buf.WriteByte(s.Properties.Code())
+	for _, u := range s.rep {
+		putUvarint(uint64(u))
+	}
+	putUvarint(uint64(s.state))
+	for _, p := range s.probSlices() {
+		for _, q := range p {
+			binary.Write(&buf, binary.LittleEndian, uint16(q))
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// readSnapshotState reads the decoder state from the snapshot.
+func readSnapshotState(br *bytes.Reader) (s *state, err error) {
+	c, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	props, err := PropertiesForCode(c)
+	if err != nil {
+		return nil, err
+	}
+	s = newState(props)
+	for i := range s.rep {
+		u, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		s.rep[i] = uint32(u)
+	}
+	u, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if u >= states {
+		return nil, errSnapshot
+	}
+	s.state = uint32(u)
+	for _, p := range s.probSlices() {
+		if err = binary.Read(br, binary.LittleEndian, p); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// NewReader2Snapshot creates an LZMA2 reader that continues reading
+// at the position the snapshot has been taken by Reader2.Snapshot. The
+// reader lzma2 must be positioned at the chunk header following the
+// last chunk consumed before the snapshot has been taken. The
+// dictionary capacity and the expected size are taken from the
+// snapshot.
+func (c Reader2Config) NewReader2Snapshot(lzma2 io.Reader, snapshot []byte,
+) (r *Reader2, err error) {
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	r, err = readSnapshot(snapshot)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errSnapshot
+		}
+		return nil, err
+	}
+	r.r = lzma2
+	r.lazy = c.Lazy
+	if r.decoder != nil {
+		r.decoder.lazy = c.Lazy
+	}
+	if err = r.startChunk(); err != nil {
+		r.err = err
+	}
+	return r, nil
+}
+
+// readSnapshot creates the reader from the snapshot without setting the
+// underlying reader.
+func readSnapshot(snapshot []byte) (r *Reader2, err error) {
+	br := bytes.NewReader(snapshot)
+	v, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if v != snapshotVersion {
+		return nil, errors.New("lzma: unsupported snapshot version")
+	}
+	cs, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	r = &Reader2{cstate: chunkState(cs)}
+	dictCap, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if dictCap > uint64(MaxDictCap) {
+		return nil, errSnapshot
+	}
+	if r.dict, err = newDecoderDict(int(dictCap), nil); err != nil {
+		return nil, err
+	}
+	head, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if r.remaining, err = binary.ReadVarint(br); err != nil {
+		return nil, err
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if n > dictCap || n > head || n > uint64(br.Len()) {
+		return nil, errSnapshot
+	}
+	h := make([]byte, n)
+	if _, err = io.ReadFull(br, h); err != nil {
+		return nil, err
+	}
+	r.dict.preset(h)
+	r.dict.head = int64(head)
+	hasState, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch hasState {
+	case 0:
+	case 1:
+		s, err := readSnapshotState(br)
+		if err != nil {
+			return nil, err
+		}
+		r.decoder = &decoder{State: s, Dict: r.dict, eos: true}
+	default:
+		return nil, errSnapshot
+	}
+	if br.Len() != 0 {
+		return nil, errSnapshot
+	}
+	return r, nil
+}
+
+// writerSnapshotVersion identifies the format of the snapshots created
+// by Writer2.Snapshot. It differs from snapshotVersion, so reader and
+// writer snapshots can't be confused.
+const writerSnapshotVersion = 0x80 | snapshotVersion
+
+// posAlign is the alignment of the dictionary position that keeps the
+// position-dependent contexts of the encoder unchanged. The values pb
+// and lp are limited to 4.
+const posAlign = 1 << 4
+
+// Snapshot returns the complete state of the writer, which includes
+// the configuration, the dictionary content and the probability model.
+// The writer must be at a chunk boundary, so Flush must be called
+// before Snapshot if data has been written. The snapshot can be used
+// by RestoreWriter2 to continue the chunk sequence, for instance in
+// another process. The Allocator is not part of the snapshot. The size
+// of the snapshot is dominated by the dictionary size.
+func (w *Writer2) Snapshot() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	if w.cstate == stop {
+		return nil, errClosed
+	}
+	if w.written() > 0 {
+		return nil, errors.New(
+			"lzma: snapshot requires the writer to be flushed")
+	}
+	var (
+		buf bytes.Buffer
+		a   [binary.MaxVarintLen64]byte
+	)
+	putUvarint := func(u uint64) {
+		buf.Write(a[:binary.PutUvarint(a[:], u)])
+	}
+	c := &w.cfg
+	buf.WriteByte(writerSnapshotVersion)
+	buf.WriteByte(byte(w.cstate))
+	buf.WriteByte(byte(w.ctype))
+	var flags byte
+	if w.resetState {
+		flags |= 1
+	}
+	if c.FlushResetsState {
+		flags |= 2
+	}
+	buf.WriteByte(flags)
+	buf.WriteByte(byte(c.Matcher))
+	for _, u := range []int{c.DictCap, c.BufSize, c.NiceLen, c.HashBits,
+		c.ChunkSize, c.EncoderFlushSize} {
+		putUvarint(uint64(u))
+	}
+	putUvarint(uint64(c.SyncEvery))
+	// The restored dictionary position is the length of the history.
+	// The oldest bytes are dropped to keep the position aligned.
+	d := w.encoder.dict
+	h := d.history()
+	k := (int64(len(h)) - d.head) % posAlign
+	if k < 0 {
+		k += posAlign
+	}
+	h = h[k:]
+	putUvarint(uint64(len(h)))
+	buf.Write(h)
+	s := w.encoder.state
+	buf.WriteByte(s.Properties.Code())
+	for _, u := range s.rep {
+		putUvarint(uint64(u))
+	}
+	putUvarint(uint64(s.state))
+	for _, p := range s.probSlices() {
+		for _, q := range p {
+			binary.Write(&buf, binary.LittleEndian, uint16(q))
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// RestoreWriter2 creates an LZMA2 writer that continues the chunk
+// sequence at the position the snapshot has been taken by
+// Writer2.Snapshot. The output written to lzma2 must be appended to
+// the chunks written before the snapshot has been taken.
+func RestoreWriter2(lzma2 io.Writer, snapshot []byte) (w *Writer2, err error) {
+	w, err = restoreWriter2(lzma2, snapshot)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = errSnapshot
+	}
+	return w, err
+}
+
+// restoreWriter2 implements RestoreWriter2 without translating the
+// errors for truncated snapshots.
+func restoreWriter2(lzma2 io.Writer, snapshot []byte,
+) (w *Writer2, err error) {
+	br := bytes.NewReader(snapshot)
+	var hdr [5]byte
+	if _, err = io.ReadFull(br, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[0] != writerSnapshotVersion {
+		return nil, errors.New("lzma: unsupported snapshot version")
+	}
+	if hdr[3]&^3 != 0 {
+		return nil, errSnapshot
+	}
+	var u [7]uint64
+	for i := range u {
+		if u[i], err = binary.ReadUvarint(br); err != nil {
+			return nil, err
+		}
+		if u[i] > uint64(MaxDictCap) {
+			return nil, errSnapshot
+		}
+	}
+	c := Writer2Config{
+		Matcher:          MatchAlgorithm(hdr[4]),
+		DictCap:          int(u[0]),
+		BufSize:          int(u[1]),
+		NiceLen:          int(u[2]),
+		HashBits:         int(u[3]),
+		ChunkSize:        int(u[4]),
+		EncoderFlushSize: int(u[5]),
+		SyncEvery:        int64(u[6]),
+		FlushResetsState: hdr[3]&2 != 0,
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(c.DictCap) || n > uint64(br.Len()) {
+		return nil, errSnapshot
+	}
+	h := make([]byte, n)
+	if _, err = io.ReadFull(br, h); err != nil {
+		return nil, err
+	}
+	s, err := readSnapshotState(br)
+	if err != nil {
+		return nil, err
+	}
+	if br.Len() != 0 {
+		return nil, errSnapshot
+	}
+	c.Properties = &s.Properties
+	if w, err = c.NewWriter2Dict(lzma2, h); err != nil {
+		return nil, err
+	}
+	cs, ctype := chunkState(hdr[1]), chunkType(hdr[2])
+	if next := cs; cs == stop || next.next(ctype) != nil {
+		return nil, errSnapshot
+	}
+	w.cstate, w.ctype = cs, ctype
+	w.resetState = hdr[3]&1 != 0
+	w.encoder.state = s
+	w.start = cloneState(s)
+	return w, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestReader2Snapshot(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(5)), 600000)
+	data := txt.Bytes()
+	var buf bytes.Buffer
+	w, err := NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	compressed := buf.Bytes()
+
+	src := bytes.NewReader(compressed)
+	r, err := NewReader2(src)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if _, err = r.Snapshot(); err != errNoChunkBoundary {
+		t.Fatalf("r.Snapshot returned %v; want %v", err,
+			errNoChunkBoundary)
+	}
+	var out bytes.Buffer
+	if _, err = io.CopyN(&out, r, int64(len(data)/2)); err != nil {
+		t.Fatalf("io.CopyN error %s", err)
+	}
+	var snapshot []byte
+	p := make([]byte, 1)
+	for {
+		if _, err = r.Read(p); err != nil {
+			t.Fatalf("r.Read error %s", err)
+		}
+		out.Write(p)
+		if snapshot, err = r.Snapshot(); err == nil {
+			break
+		}
+		if err != errNoChunkBoundary {
+			t.Fatalf("r.Snapshot error %s", err)
+		}
+	}
+	offset := len(compressed) - src.Len()
+	t.Logf("snapshot of %d bytes at offset %d (uncompressed %d)",
+		len(snapshot), offset, out.Len())
+
+	r, err = Reader2Config{}.NewReader2Snapshot(
+		bytes.NewReader(compressed[offset:]), snapshot)
+	if err != nil {
+		t.Fatalf("NewReader2Snapshot error %s", err)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll error %s", err)
+	}
+	out.Write(rest)
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("restored reader returned wrong data")
+	}
+
+	if _, err = (Reader2Config{}).NewReader2Snapshot(
+		bytes.NewReader(compressed[offset:]),
+		snapshot[:len(snapshot)-1]); err != errSnapshot {
+		t.Fatalf("NewReader2Snapshot with truncated snapshot"+
+			" returned %v; want %v", err, errSnapshot)
+	}
+}
+
+func TestWriter2Snapshot(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(7)), 300000)
+	data := txt.Bytes()
+	for _, c := range []Writer2Config{
+		{DictCap: 64 * 1024},
+		{DictCap: 64 * 1024, Matcher: BinaryTree,
+			Properties: &Properties{LC: 0, LP: 2, PB: 3}},
+		{FlushResetsState: true},
+	} {
+		var buf bytes.Buffer
+		w, err := c.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		half := len(data)/2 + 13
+		if _, err = w.Write(data[:half]); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if _, err = w.Snapshot(); err == nil {
+			t.Fatalf("w.Snapshot succeeded before Flush")
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("w.Flush error %s", err)
+		}
+		snapshot, err := w.Snapshot()
+		if err != nil {
+			t.Fatalf("w.Snapshot error %s", err)
+		}
+		w, err = RestoreWriter2(&buf, snapshot)
+		if err != nil {
+			t.Fatalf("RestoreWriter2 error %s", err)
+		}
+		if _, err = w.Write(data[half:]); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		r, err := Reader2Config{DictCap: w.cfg.DictCap}.NewReader2(&buf)
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("decompressed data differs from original")
+		}
+		_, err = RestoreWriter2(&buf, snapshot[:len(snapshot)-1])
+		if err != errSnapshot {
+			t.Fatalf("RestoreWriter2 returned %v; want %v",
+				err, errSnapshot)
+		}
+	}
+}
@@ -30,6 +30,15 @@ type WriterConfig struct {
 	BufSize int
 	// Match algorithm
 	Matcher MatchAlgorithm
+	// NiceLen is the match length at which the matcher stops
+	// searching for longer matches. The value 0 selects the maximum
+	// match length 273.
+	NiceLen int
+	// HashBits sets the size of the hash table of the HashTable4
+	// matcher to 2^HashBits entries. Smaller tables reduce the
+	// memory usage, larger tables may improve the compression
+	// ratio. The value 0 derives the size from DictCap.
+	HashBits int
 	// SizeInHeader indicates that the header will contain an
 	// explicit size.
 	SizeInHeader bool
@@ -40,6 +49,18 @@ type WriterConfig struct {
 	// If no explicit size is been given the EOSMarker will be
 	// set automatically.
 	EOSMarker bool
+	// AllowSizeOverrun treats Size as an estimate. By default Write
+	// doesn't accept more than Size bytes and returns ErrNoSpace.
+	// If AllowSizeOverrun is set, the writer accepts more data and
+	// switches to the EOS marker mode as soon as Size is exceeded.
+	// The header with the declared size has already been written
+	// at this point. If the underlying writer is an io.WriteSeeker,
+	// Close replaces the size in the header by the value for an
+	// unknown size. Otherwise Close returns ErrSizeOverrun after
+	// the stream has been completed, because decoders reject the
+	// data beyond the declared size; the caller must then set the
+	// size field in the bytes 5 to 12 of the header to all ones.
+	AllowSizeOverrun bool
 }
 
 // fill converts zero-value fields to their explicit default values.
@@ -53,6 +74,9 @@ func (c *WriterConfig) fill() {
 	if c.BufSize == 0 {
 		c.BufSize = 4096
 	}
+	if c.NiceLen == 0 {
+		c.NiceLen = maxMatchLen
+	}
 	if c.Size > 0 {
 		c.SizeInHeader = true
 	}
@@ -91,6 +115,12 @@ func (c *WriterConfig) Verify() error {
 	if err = c.Matcher.verify(); err != nil {
 		return err
 	}
+	if err = verifyNiceLen(c.NiceLen); err != nil {
+		return err
+	}
+	if err = verifyHashBits(c.HashBits); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -108,12 +138,26 @@ func (c *WriterConfig) header() header {
 	return h
 }
 
+// ErrSizeOverrun is returned by Writer.Close if more data than the
+// declared size has been written with AllowSizeOverrun set and the
+// size in the header couldn't be replaced.
+var ErrSizeOverrun = errors.New(
+	"lzma: size exceeded; header declares wrong size")
+
 // Writer writes an LZMA stream in the classic format.
 type Writer struct {
 	h   header
 	bw  io.ByteWriter
 	buf *bufio.Writer
 	e   *encoder
+
+	allowOverrun bool
+	// more data than the declared size has been written
+	overrun bool
+	// underlying writer if it is an io.WriteSeeker
+	ws io.WriteSeeker
+	// offset of the header in ws
+	start int64
 }
 
 // NewWriter creates a new LZMA writer for the classic format. The
@@ -122,7 +166,12 @@ func (c WriterConfig) NewWriter(lzma io.Writer) (w *Writer, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	w = &Writer{h: c.header()}
+	w = &Writer{h: c.header(), allowOverrun: c.AllowSizeOverrun}
+	if ws, ok := lzma.(io.WriteSeeker); ok && c.AllowSizeOverrun {
+		if w.start, err = ws.Seek(0, io.SeekCurrent); err == nil {
+			w.ws = ws
+		}
+	}
 
 	var ok bool
 	w.bw, ok = lzma.(io.ByteWriter)
@@ -131,11 +180,11 @@ func (c WriterConfig) NewWriter(lzma io.Writer) (w *Writer, err error) {
 		w.bw = w.buf
 	}
 	state := newState(w.h.properties)
-	m, err := c.Matcher.new(w.h.dictCap)
+	m, err := c.Matcher.new(w.h.dictCap, c.NiceLen, c.HashBits)
 	if err != nil {
 		return nil, err
 	}
-	dict, err := newEncoderDict(w.h.dictCap, c.BufSize, m)
+	dict, err := newEncoderDict(w.h.dictCap, c.BufSize, m, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -171,13 +220,22 @@ func (w *Writer) writeHeader() error {
 
 // Write puts data into the Writer.
 func (w *Writer) Write(p []byte) (n int, err error) {
-	if w.h.size >= 0 {
+	if w.h.size >= 0 && !w.overrun {
 		m := w.h.size
 		m -= w.e.Compressed() + int64(w.e.dict.Buffered())
 		if m < 0 {
 			m = 0
 		}
-		if m < int64(len(p)) {
+		switch {
+		case m >= int64(len(p)):
+		case w.allowOverrun:
+			// switch to EOS marker mode
+			w.overrun = true
+			if !w.e.marker {
+				w.e.marker = true
+				w.e.margin += 5
+			}
+		default:
 			p = p[:m]
 			err = ErrNoSpace
 		}
@@ -192,7 +250,7 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 // Close closes the writer stream. It ensures that all data from the
 // buffer will be compressed and the LZMA stream will be finished.
 func (w *Writer) Close() error {
-	if w.h.size >= 0 {
+	if w.h.size >= 0 && !w.overrun {
 		n := w.e.Compressed() + int64(w.e.dict.Buffered())
 		if n != w.h.size {
 			return errSize
@@ -205,5 +263,30 @@ func (w *Writer) Close() error {
 			err = ferr
 		}
 	}
+	if err != nil || !w.overrun {
+		return err
+	}
+	if w.ws == nil {
+		return ErrSizeOverrun
+	}
+	return w.rewriteSize()
+}
+
+// rewriteSize replaces the size in the header written to w.ws by the
+// value for an unknown size and restores the write position.
+func (w *Writer) rewriteSize() error {
+	end, err := w.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = w.ws.Seek(w.start+5, io.SeekStart); err != nil {
+		return err
+	}
+	var p [8]byte
+	putUint64LE(p[:], noHeaderSize)
+	if _, err = w.ws.Write(p[:]); err != nil {
+		return err
+	}
+	_, err = w.ws.Seek(end, io.SeekStart)
 	return err
 }
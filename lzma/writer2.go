@@ -10,6 +10,9 @@ import (
 	"io"
 )
 
+// minEncoderFlushSize is the minimum of Writer2Config.EncoderFlushSize.
+const minEncoderFlushSize = 256
+
 // Writer2Config is used to create a Writer2 using parameters.
 type Writer2Config struct {
 	// The properties for the encoding. If the it is nil the value
@@ -23,6 +26,64 @@ type Writer2Config struct {
 	BufSize int
 	// Match algorithm
 	Matcher MatchAlgorithm
+	// NewMatchFinder creates a custom match finder for the given
+	// dictionary capacity. If it is set, Matcher, NiceLen and
+	// HashBits are ignored. A writer restored from a snapshot uses
+	// Matcher instead.
+	NewMatchFinder func(dictCap int) MatchFinder
+	// NiceLen is the match length at which the matcher stops
+	// searching for longer matches. Higher values improve the
+	// compression ratio at the cost of speed. The value 0 selects
+	// the maximum match length 273.
+	NiceLen int
+	// HashBits sets the size of the hash table of the HashTable4
+	// matcher to 2^HashBits entries. Smaller tables reduce the
+	// memory usage, larger tables may improve the compression
+	// ratio. The value 0 derives the size from DictCap.
+	HashBits int
+	// FlushResetsState requests that the chunk following a Flush
+	// resets the state and the probability model of the encoder.
+	// The chunks after the flush can then be decoded by a reader
+	// created with Reader2Config.NewReader2Dict, which only needs
+	// the data decompressed so far. The reset reduces the
+	// compression ratio, because the statistics collected for the
+	// data before the flush are lost. Without the reset the chunks
+	// after the flush can only be decoded by the reader that
+	// decoded the data before them.
+	FlushResetsState bool
+	// ChunkSize limits the uncompressed size of the LZMA2 chunks.
+	// A chunk is written to the underlying writer as soon as it
+	// is complete, so smaller chunks reduce the amount of data
+	// buffered by the writer and the latency of streaming
+	// applications at the cost of the compression ratio. Each
+	// compressed chunk costs a header of 5 or 6 bytes and the
+	// flush of the range encoder of up to 5 bytes. The value 0
+	// selects the maximum of 2 MiB, which minimizes the number of
+	// chunks for bulk compression. The compressed size of a chunk
+	// is limited by EncoderFlushSize, so for most data the chunks
+	// are smaller. Chunks are also terminated by Flush and
+	// SyncEvery. ChunkSize doesn't affect the latency of Flush:
+	// Flush closes the pending chunk and writes it out, so all data
+	// written before it can be decoded immediately. A reduction of
+	// the chunk size near flush points is therefore not required.
+	ChunkSize int
+	// SyncEvery requests a flush after every SyncEvery bytes of
+	// uncompressed data, so a reader can decode the data without
+	// explicit calls of Flush. The dictionary is not reset, so the
+	// compression ratio is mostly preserved. The value 0 disables
+	// the automatic flushes.
+	SyncEvery int64
+	// Allocator provides the memory for the dictionary buffer. The
+	// memory is returned to the allocator by Close. If Allocator is
+	// nil the memory is allocated on the Go heap.
+	Allocator Allocator
+	// EncoderFlushSize limits the compressed data buffered for a
+	// chunk. The chunk is written to the underlying writer when the
+	// limit has been reached, so a small value reduces the memory
+	// required for the buffer at the cost of more writes and a
+	// slightly lower compression ratio. The value must be between
+	// 256 and 64 KiB; 0 selects 64 KiB.
+	EncoderFlushSize int
 }
 
 // fill replaces zero values with default values.
@@ -36,6 +97,15 @@ func (c *Writer2Config) fill() {
 	if c.BufSize == 0 {
 		c.BufSize = 4096
 	}
+	if c.NiceLen == 0 {
+		c.NiceLen = maxMatchLen
+	}
+	if c.ChunkSize == 0 {
+		c.ChunkSize = maxUncompressed
+	}
+	if c.EncoderFlushSize == 0 {
+		c.EncoderFlushSize = maxCompressed
+	}
 }
 
 // Verify checks the Writer2Config for correctness. Zero values will be
@@ -64,9 +134,41 @@ func (c *Writer2Config) Verify() error {
 	if err = c.Matcher.verify(); err != nil {
 		return err
 	}
+	if err = verifyNiceLen(c.NiceLen); err != nil {
+		return err
+	}
+	if err = verifyHashBits(c.HashBits); err != nil {
+		return err
+	}
+	if !(0 < c.ChunkSize && c.ChunkSize <= maxUncompressed) {
+		return errors.New("lzma: chunk size out of range")
+	}
+	if c.SyncEvery < 0 {
+		return errors.New("lzma: negative SyncEvery")
+	}
+	if !(minEncoderFlushSize <= c.EncoderFlushSize &&
+		c.EncoderFlushSize <= maxCompressed) {
+		return errors.New("lzma: encoder flush size out of range")
+	}
 	return nil
 }
 
+// newMatcher creates the matcher for the configuration.
+func (c *Writer2Config) newMatcher() (matcher, error) {
+	if c.NewMatchFinder != nil {
+		return &finderMatcher{mf: c.NewMatchFinder(c.DictCap)}, nil
+	}
+	return c.Matcher.new(c.DictCap, c.NiceLen, c.HashBits)
+}
+
+// memUsage estimates the memory used by a Writer2 for the configuration
+// in bytes. The configuration must have been verified.
+func (c *Writer2Config) memUsage() int64 {
+	return int64(c.DictCap) + int64(c.BufSize) +
+		int64(c.EncoderFlushSize) +
+		c.Matcher.memUsage(c.DictCap, c.HashBits)
+}
+
 // Writer2 supports the creation of an LZMA2 stream. But note that
 // written data is buffered, so call Flush or Close to write data to the
 // underlying writer. The Close method writes the end-of-stream marker
@@ -76,6 +178,19 @@ func (c *Writer2Config) Verify() error {
 //
 // Any change to the fields Properties, DictCap must be done before the
 // first call to Write, Flush or Close.
+//
+// The chunk boundaries and the output depend only on the configuration,
+// the data and the calls of Flush, but not on how the data is split
+// into Write calls. So the output is reproducible.
+//
+// After an error all calls of Write, Flush and Close return the first
+// error.
+//
+// A raw LZMA2 chunk sequence is the output format with the smallest
+// overhead. It can be used if an outer format stores the length of the
+// compressed data. The only overhead are the chunk headers and the
+// terminating end-of-stream byte; see RawSize for an upper bound of
+// the output size.
 type Writer2 struct {
 	w io.Writer
 
@@ -87,6 +202,33 @@ type Writer2 struct {
 
 	buf bytes.Buffer
 	lbw LimitedByteWriter
+
+	flushResetsState bool
+	chunkSize        int
+	syncEvery        int64
+	// uncompressed bytes written since the last flush
+	sinceSync int64
+	// allocator for the dictionary buffer
+	alloc Allocator
+	// limit for the compressed data of a chunk
+	flushSize int
+	// the next compressed chunk must reset the state
+	resetState bool
+	// verified configuration used by Snapshot
+	cfg Writer2Config
+	// first error returned to the caller
+	err error
+}
+
+// RawSize returns the size of an LZMA2 chunk sequence that stores n
+// bytes in uncompressed chunks of 64 KiB including the end-of-stream
+// byte: n + 3*ceil(n/65536) + 1. Since a Writer2 stores a chunk
+// uncompressed if compression doesn't reduce its size, the value is an
+// upper bound for the output of a Writer2 with a ChunkSize of 64 KiB or
+// less. It is reached for incompressible data.
+func RawSize(n int) int {
+	chunks := (n + maxUncompressedChunk - 1) / maxUncompressedChunk
+	return n + chunks*uncompressedHeaderLen + 1
 }
 
 // NewWriter2 creates an LZMA2 chunk sequence writer with the default
@@ -97,6 +239,16 @@ func NewWriter2(lzma2 io.Writer) (w *Writer2, err error) {
 
 // NewWriter2 creates a new LZMA2 writer using the given configuration.
 func (c Writer2Config) NewWriter2(lzma2 io.Writer) (w *Writer2, err error) {
+	return c.NewWriter2Dict(lzma2, nil)
+}
+
+// NewWriter2Dict creates a new LZMA2 writer with a dictionary preset
+// to the data in dict. The written chunk sequence doesn't start with a
+// dictionary reset and can only be decoded by a reader created with
+// Reader2Config.NewReader2Dict using the same dictionary data. If dict
+// is empty the function is equivalent to NewWriter2.
+func (c Writer2Config) NewWriter2Dict(lzma2 io.Writer, dict []byte,
+) (w *Writer2, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
@@ -105,17 +257,33 @@ func (c Writer2Config) NewWriter2(lzma2 io.Writer) (w *Writer2, err error) {
 		start:  newState(*c.Properties),
 		cstate: start,
 		ctype:  start.defaultChunkType(),
-	}
-	w.buf.Grow(maxCompressed)
-	w.lbw = LimitedByteWriter{BW: &w.buf, N: maxCompressed}
-	m, err := c.Matcher.new(c.DictCap)
+
+		flushResetsState: c.FlushResetsState,
+		chunkSize:        c.ChunkSize,
+		syncEvery:        c.SyncEvery,
+		alloc:            c.Allocator,
+		flushSize:        c.EncoderFlushSize,
+		cfg:              c,
+	}
+	w.buf.Grow(c.EncoderFlushSize)
+	w.lbw = LimitedByteWriter{BW: &w.buf, N: int64(c.EncoderFlushSize)}
+	m, err := c.newMatcher()
 	if err != nil {
 		return nil, err
 	}
-	d, err := newEncoderDict(c.DictCap, c.BufSize, m)
+	d, err := newEncoderDict(c.DictCap, c.BufSize, m, c.Allocator)
 	if err != nil {
 		return nil, err
 	}
+	if len(dict) > 0 {
+		if err = d.preset(dict); err != nil {
+			return nil, err
+		}
+		// The dictionary must not be reset, but the first
+		// compressed chunk must provide the properties.
+		w.cstate = 'R'
+		w.ctype = w.cstate.defaultChunkType()
+	}
 	w.encoder, err = newEncoder(&w.lbw, cloneState(w.start), d, 0)
 	if err != nil {
 		return nil, err
@@ -134,17 +302,56 @@ func (w *Writer2) written() int {
 // errClosed indicates that the writer is closed.
 var errClosed = errors.New("lzma: writer closed")
 
+// fail records the first error of the writer. All following calls of
+// Write, Flush and Close return the error without doing any work,
+// because the state of the stream is undefined after an error.
+func (w *Writer2) fail(err error) error {
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return err
+}
+
 // Writes data to LZMA2 stream. Note that written data will be buffered.
 // Use Flush or Close to ensure that data is written to the underlying
-// writer.
+// writer. If SyncEvery is set, Write flushes the data automatically.
 func (w *Writer2) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
 	if w.cstate == stop {
 		return 0, errClosed
 	}
+	if w.syncEvery == 0 {
+		n, err = w.write(p)
+		return n, w.fail(err)
+	}
 	for n < len(p) {
-		m := maxUncompressed - w.written()
+		q := p[n:]
+		if r := w.syncEvery - w.sinceSync; int64(len(q)) > r {
+			q = q[:r]
+		}
+		k, err := w.write(q)
+		n += k
+		w.sinceSync += int64(k)
+		if err != nil {
+			return n, w.fail(err)
+		}
+		if w.sinceSync >= w.syncEvery {
+			if err = w.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// write writes data to the LZMA2 stream without automatic flushes.
+func (w *Writer2) write(p []byte) (n int, err error) {
+	for n < len(p) {
+		m := w.chunkSize - w.written()
 		if m <= 0 {
-			panic("lzma: maxUncompressed reached")
+			panic("lzma: chunk size reached")
 		}
 		var q []byte
 		if n+m < len(p) {
@@ -166,8 +373,10 @@ func (w *Writer2) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
-// writeUncompressedChunk writes an uncompressed chunk to the LZMA2
-// stream.
+// writeUncompressedChunk writes the data of the current chunk as
+// uncompressed chunks to the LZMA2 stream. Since an uncompressed chunk
+// can hold only 64 KiB the data may be split into multiple chunks. Only
+// the first chunk may reset the dictionary.
 func (w *Writer2) writeUncompressedChunk() error {
 	u := w.encoder.Compressed()
 	if u <= 0 {
@@ -184,19 +393,34 @@ func (w *Writer2) writeUncompressedChunk() error {
 	}
 	w.encoder.state = w.start
 
-	header := chunkHeader{
-		ctype:        w.ctype,
-		uncompressed: uint32(u - 1),
-	}
-	hdata, err := header.MarshalBinary()
-	if err != nil {
+	var data bytes.Buffer
+	data.Grow(int(u))
+	if _, err := w.encoder.dict.CopyN(&data, int(u)); err != nil {
 		return err
 	}
-	if _, err = w.w.Write(hdata); err != nil {
-		return err
+	ctype := w.ctype
+	for data.Len() > 0 {
+		n := data.Len()
+		if n > maxUncompressedChunk {
+			n = maxUncompressedChunk
+		}
+		header := chunkHeader{
+			ctype:        ctype,
+			uncompressed: uint32(n - 1),
+		}
+		hdata, err := header.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err = w.w.Write(hdata); err != nil {
+			return err
+		}
+		if _, err = w.w.Write(data.Next(n)); err != nil {
+			return err
+		}
+		ctype = cU
 	}
-	_, err = w.encoder.dict.CopyN(w.w, int(u))
-	return err
+	return nil
 }
 
 // writeCompressedChunk writes a compressed chunk to the underlying
@@ -260,8 +484,11 @@ func (w *Writer2) flushChunk() error {
 	if err = w.writeChunk(); err != nil {
 		return err
 	}
+	if !uncompressed(w.ctype) {
+		w.resetState = false
+	}
 	w.buf.Reset()
-	w.lbw.N = maxCompressed
+	w.lbw.N = int64(w.flushSize)
 	if err = w.encoder.Reopen(&w.lbw); err != nil {
 		return err
 	}
@@ -269,6 +496,9 @@ func (w *Writer2) flushChunk() error {
 		return err
 	}
 	w.ctype = w.cstate.defaultChunkType()
+	if w.resetState && w.ctype == cL {
+		w.ctype = cLRN
+	}
 	w.start = cloneState(w.encoder.state)
 	return nil
 }
@@ -276,30 +506,146 @@ func (w *Writer2) flushChunk() error {
 // Flush writes all buffered data out to the underlying stream. This
 // could result in multiple chunks to be created.
 func (w *Writer2) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
 	if w.cstate == stop {
 		return errClosed
 	}
+	w.sinceSync = 0
+	if w.written() == 0 {
+		return nil
+	}
 	for w.written() > 0 {
 		if err := w.flushChunk(); err != nil {
-			return err
+			return w.fail(err)
+		}
+	}
+	if w.flushResetsState {
+		// The properties reset allows the chunk to be the first
+		// chunk read by NewReader2Dict.
+		w.resetState = true
+		if w.ctype == cL {
+			w.ctype = cLRN
 		}
+		w.encoder.state.Reset()
+		w.start = cloneState(w.encoder.state)
+	}
+	return nil
+}
+
+// errNotClosed indicates that Continue has been called before Close.
+var errNotClosed = errors.New("lzma: writer not closed")
+
+// Continue starts a new chunk sequence on lzma2 after Close. The
+// dictionary is kept, so the new sequence may refer to the data of
+// the previous sequences. It can be read by a Reader2 that has read
+// the previous sequences using Reader2.Continue or by NewReader2Dict.
+// The probability model is reset, so the first compressed chunk
+// provides the properties. A writer using an Allocator cannot be
+// continued, because Close returns the dictionary buffer.
+func (w *Writer2) Continue(lzma2 io.Writer) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.cstate != stop {
+		return errNotClosed
+	}
+	if w.alloc != nil {
+		return errors.New(
+			"lzma: can't continue writer using an allocator")
+	}
+	w.w = lzma2
+	w.encoder.state.Reset()
+	w.start = cloneState(w.encoder.state)
+	w.sinceSync = 0
+	w.resetState = false
+	w.cstate = start
+	if w.encoder.dict.DictLen() > 0 {
+		w.cstate = 'R'
+	}
+	w.ctype = w.cstate.defaultChunkType()
+	return nil
+}
+
+// ShrinkDict flushes the writer and replaces the dictionary by an
+// empty dictionary of capacity newSize, which must not exceed the
+// current capacity. The following chunk resets the dictionary and the
+// state, so the compression ratio drops for the data written directly
+// after the call. The method reduces the memory of a long-lived writer
+// that needed a large dictionary only for an initial burst of data. The
+// chunk sequence can still be read by a reader configured for the
+// original capacity.
+func (w *Writer2) ShrinkDict(newSize int) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.cstate == stop {
+		return errClosed
+	}
+	if !(MinDictCap <= newSize && newSize <= w.cfg.DictCap) {
+		return errors.New("lzma: new dictionary size out of range")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	c := w.cfg
+	c.DictCap = newSize
+	m, err := c.newMatcher()
+	if err != nil {
+		return w.fail(err)
 	}
+	d, err := newEncoderDict(c.DictCap, c.BufSize, m, c.Allocator)
+	if err != nil {
+		return w.fail(err)
+	}
+	w.start = newState(*c.Properties)
+	e, err := newEncoder(&w.lbw, cloneState(w.start), d, 0)
+	if err != nil {
+		return w.fail(err)
+	}
+	if w.alloc != nil {
+		w.alloc.Free(w.encoder.dict.buf.data)
+	}
+	w.encoder = e
+	w.cfg = c
+	w.resetState = false
+	w.cstate = start
+	w.ctype = w.cstate.defaultChunkType()
 	return nil
 }
 
+// Dict returns a copy of the data compressed so far that is still in
+// the dictionary. Call Flush first to include all data written. The
+// result can be provided to NewWriter2Dict to write a chunk sequence
+// continuing this one. Dict returns nil after Close.
+func (w *Writer2) Dict() []byte {
+	if w.cstate == stop {
+		return nil
+	}
+	return w.encoder.dict.history()
+}
+
 // Close terminates the LZMA2 stream with an EOS chunk.
 func (w *Writer2) Close() error {
+	if w.err != nil {
+		return w.err
+	}
 	if w.cstate == stop {
 		return errClosed
 	}
 	if err := w.Flush(); err != nil {
-		return nil
+		return err
 	}
 	// write zero byte EOS chunk
 	_, err := w.w.Write([]byte{0})
 	if err != nil {
-		return err
+		return w.fail(err)
 	}
 	w.cstate = stop
+	if w.alloc != nil {
+		w.alloc.Free(w.encoder.dict.buf.data)
+		w.encoder.dict.buf.data = nil
+	}
 	return nil
 }
@@ -6,7 +6,10 @@ package lzma
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"strings"
 	"testing"
@@ -44,6 +47,47 @@ func TestWriter2(t *testing.T) {
 	}
 }
 
+func TestWriter2FlushWithoutData(t *testing.T) {
+	for _, c := range []Writer2Config{
+		{},
+		{FlushResetsState: true},
+		{SyncEvery: 100},
+	} {
+		var buf bytes.Buffer
+		w, err := c.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		for i := 0; i < 3; i++ {
+			if err = w.Flush(); err != nil {
+				t.Fatalf("w.Flush() error %s", err)
+			}
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("Flush without data wrote %d bytes", buf.Len())
+		}
+		if _, err = w.Write([]byte{'a'}); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err = w.Flush(); err != nil {
+				t.Fatalf("w.Flush() error %s", err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close() error %s", err)
+		}
+		if err = w.Flush(); err != errClosed {
+			t.Fatalf("w.Flush() after Close returned %v; want %v",
+				err, errClosed)
+		}
+		want := []byte{1, 0, 0, 'a', 0}
+		if p := buf.Bytes(); !bytes.Equal(p, want) {
+			t.Fatalf("bytes written %#v; want %#v", p, want)
+		}
+	}
+}
+
 func TestCycle1(t *testing.T) {
 	var buf bytes.Buffer
 	w, err := Writer2Config{DictCap: 4096}.NewWriter2(&buf)
@@ -107,3 +151,1181 @@ func TestCycle2(t *testing.T) {
 		t.Fatal("decompressed data differs from original")
 	}
 }
+
+// chunkTypes returns the chunk types of the LZMA2 stream in data.
+func chunkTypes(t *testing.T, data []byte) []chunkType {
+	var types []chunkType
+	r := bytes.NewReader(data)
+	for {
+		h, err := readChunkHeader(r)
+		if err != nil {
+			t.Fatalf("readChunkHeader error %s", err)
+		}
+		types = append(types, h.ctype)
+		var n int64
+		switch h.ctype {
+		case cEOS:
+			return types
+		case cU, cUD:
+			n = int64(h.uncompressed) + 1
+		default:
+			n = int64(h.compressed) + 1
+		}
+		if _, err = r.Seek(n, io.SeekCurrent); err != nil {
+			t.Fatalf("r.Seek error %s", err)
+		}
+	}
+}
+
+func TestWriter2MixedChunks(t *testing.T) {
+	const regionLen = 1 << 17
+	rnd := rand.New(rand.NewSource(7))
+	var txt bytes.Buffer
+	for i := 0; i < 3; i++ {
+		if _, err := io.CopyN(&txt, rnd, regionLen); err != nil {
+			t.Fatalf("io.CopyN error %s", err)
+		}
+		rep := strings.Repeat("abcdefgh", regionLen/8)
+		txt.WriteString(rep)
+	}
+	orig := txt.Bytes()
+
+	var buf bytes.Buffer
+	w, err := Writer2Config{}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(orig); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+
+	var compressed, uncompressed int
+	for _, c := range chunkTypes(t, buf.Bytes()) {
+		switch c {
+		case cU, cUD:
+			uncompressed++
+		case cL, cLR, cLRN, cLRND:
+			compressed++
+		}
+	}
+	if compressed == 0 || uncompressed == 0 {
+		t.Fatalf("got %d compressed and %d uncompressed chunks;"+
+			" want both", compressed, uncompressed)
+	}
+
+	r, err := NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	var out bytes.Buffer
+	if _, err = io.Copy(&out, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), orig) {
+		t.Fatal("decompressed data differs from original")
+	}
+}
+
+func TestWriter2NiceLen(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(3)), 20000)
+	txt.Write(txt.Bytes())
+
+	compressedLen := func(niceLen int) int {
+		var buf bytes.Buffer
+		cfg := Writer2Config{NiceLen: niceLen}
+		w, err := cfg.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(txt.Bytes()); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return buf.Len()
+	}
+	low, high := compressedLen(minMatchLen), compressedLen(maxMatchLen)
+	t.Logf("NiceLen %d: %d bytes; NiceLen %d: %d bytes",
+		minMatchLen, low, maxMatchLen, high)
+	if high >= low {
+		t.Fatalf("NiceLen %d gives %d bytes; want less than %d",
+			maxMatchLen, high, low)
+	}
+
+	for _, n := range []int{-1, 1, maxMatchLen + 1} {
+		cfg := Writer2Config{NiceLen: n}
+		if err := cfg.Verify(); err == nil {
+			t.Fatalf("Verify accepted NiceLen %d", n)
+		}
+	}
+}
+
+func TestWriter2Dict(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(17)), 60000)
+	a := txt.Bytes()[:30000]
+	b := txt.Bytes()[30000:]
+
+	compress := func(p, dict []byte) []byte {
+		var buf bytes.Buffer
+		w, err := Writer2Config{}.NewWriter2Dict(&buf, dict)
+		if err != nil {
+			t.Fatalf("NewWriter2Dict error %s", err)
+		}
+		if _, err = w.Write(p); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return buf.Bytes()
+	}
+	decompress := func(p, dict []byte) []byte {
+		r, err := Reader2Config{}.NewReader2Dict(bytes.NewReader(p),
+			dict)
+		if err != nil {
+			t.Fatalf("NewReader2Dict error %s", err)
+		}
+		var out bytes.Buffer
+		if _, err = io.Copy(&out, r); err != nil {
+			t.Fatalf("io.Copy error %s", err)
+		}
+		return out.Bytes()
+	}
+
+	frame1 := compress(a, nil)
+	frame2 := compress(b, a)
+	if c := chunkTypes(t, frame2)[0]; c != cLRN {
+		t.Fatalf("first chunk of second frame has type %v; want %v",
+			c, cLRN)
+	}
+	if !bytes.Equal(decompress(frame1, nil), a) {
+		t.Fatal("first frame differs from original")
+	}
+	if !bytes.Equal(decompress(frame2, a), b) {
+		t.Fatal("second frame differs from original")
+	}
+	standalone := compress(b, nil)
+	t.Logf("second frame %d bytes; standalone %d bytes", len(frame2),
+		len(standalone))
+	if len(frame2) >= len(standalone) {
+		t.Fatalf("second frame has %d bytes; want less than %d",
+			len(frame2), len(standalone))
+	}
+
+	// a reader without the dictionary must reject the frame
+	r, err := NewReader2(bytes.NewReader(frame2))
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, r)
+	}
+	if err == nil {
+		t.Fatal("reader without dictionary accepted second frame")
+	}
+}
+
+func TestDict(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(18)), 50000)
+	data := txt.Bytes()
+	const dictCap = MinDictCap
+	last := func(n int) []byte {
+		if n > dictCap {
+			return data[n-dictCap : n]
+		}
+		return data[:n]
+	}
+
+	var buf bytes.Buffer
+	w, err := Writer2Config{DictCap: dictCap}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	prev := 0
+	for _, n := range []int{1000, 20000, len(data)} {
+		if _, err = w.Write(data[prev:n]); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("w.Flush error %s", err)
+		}
+		if !bytes.Equal(w.Dict(), last(n)) {
+			t.Fatalf("w.Dict() after %d bytes differs", n)
+		}
+		prev = n
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if w.Dict() != nil {
+		t.Fatal("w.Dict() after Close returned data")
+	}
+
+	r, err := Reader2Config{DictCap: dictCap}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if !bytes.Equal(r.Dict(), last(len(data))) {
+		t.Fatal("r.Dict() differs from the end of the data")
+	}
+}
+
+func TestContinue(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(19)), 60000)
+	parts := [][]byte{
+		txt.Bytes()[:20000],
+		txt.Bytes()[20000:40000],
+		txt.Bytes()[40000:],
+	}
+
+	seqs := make([]bytes.Buffer, len(parts))
+	w, err := NewWriter2(&seqs[0])
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if err = w.Continue(&seqs[1]); err != errNotClosed {
+		t.Fatalf("w.Continue before Close returned %v; want %v",
+			err, errNotClosed)
+	}
+	for i, p := range parts {
+		if i > 0 {
+			if err = w.Continue(&seqs[i]); err != nil {
+				t.Fatalf("w.Continue error %s", err)
+			}
+		}
+		if _, err = w.Write(p); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+	}
+	var sbuf bytes.Buffer
+	sw, err := NewWriter2(&sbuf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = sw.Write(parts[1]); err != nil {
+		t.Fatalf("sw.Write error %s", err)
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatalf("sw.Close error %s", err)
+	}
+	standalone := sbuf.Bytes()
+	if seqs[1].Len() >= len(standalone) {
+		t.Fatalf("continued sequence has %d bytes; want less than %d",
+			seqs[1].Len(), len(standalone))
+	}
+
+	// The third sequence is replaced by a standalone sequence
+	// starting with a dictionary reset.
+	inputs := [][]byte{seqs[0].Bytes(), seqs[1].Bytes(), standalone}
+	wants := [][]byte{parts[0], parts[1], parts[1]}
+	r, err := NewReader2(bytes.NewReader(inputs[0]))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	for i, in := range inputs {
+		if i > 0 {
+			r.Continue(bytes.NewReader(in))
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("sequence %d: ReadAll error %s", i, err)
+		}
+		if !bytes.Equal(out, wants[i]) {
+			t.Fatalf("sequence %d differs from original", i)
+		}
+	}
+
+	r, err = Reader2Config{}.NewReader2Dict(
+		bytes.NewReader(seqs[1].Bytes()), parts[0])
+	if err != nil {
+		t.Fatalf("NewReader2Dict error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, parts[1]) {
+		t.Fatal("NewReader2Dict output differs from original")
+	}
+}
+
+func TestReader2ExpectedSize(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(23)), 50000)
+	orig := txt.Bytes()
+
+	var buf bytes.Buffer
+	w, err := NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(orig); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	// Flush doesn't write an end-of-stream chunk.
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush error %s", err)
+	}
+	const sentinel = "sentinel"
+	buf.WriteString(sentinel)
+
+	cfg := Reader2Config{ExpectedSize: int64(len(orig))}
+	r, err := cfg.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, orig) {
+		t.Fatal("decompressed data differs from original")
+	}
+	if s := buf.String(); s != sentinel {
+		t.Fatalf("remaining data %q; want %q", s, sentinel)
+	}
+
+	// a stream shorter than the expected size
+	buf.Reset()
+	w, err = NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(orig[:100]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if r, err = cfg.NewReader2(&buf); err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadAll returned %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+}
+
+func TestReader2Chunk(t *testing.T) {
+	// two uncompressed chunks followed by the end-of-stream chunk
+	lzma2 := []byte{
+		0x01, 0x00, 0x02, 'a', 'b', 'c',
+		0x02, 0x00, 0x01, 'd', 'e',
+		0x00,
+	}
+	r, err := NewReader2(bytes.NewReader(lzma2))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if c := r.Chunk(); c != 0 {
+		t.Fatalf("r.Chunk() returned %d; want %d", c, 0)
+	}
+	p := make([]byte, 4)
+	if _, err = io.ReadFull(r, p); err != nil {
+		t.Fatalf("io.ReadFull error %s", err)
+	}
+	if c := r.Chunk(); c != 1 {
+		t.Fatalf("r.Chunk() returned %d; want %d", c, 1)
+	}
+	if _, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if c := r.Chunk(); c != 2 {
+		t.Fatalf("r.Chunk() returned %d; want %d", c, 2)
+	}
+}
+
+func TestWriter2HashBits(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(37)), 200000)
+	data := txt.Bytes()
+	var prevMem int64
+	for _, hashBits := range []int{20, 16, 12} {
+		c := Writer2Config{DictCap: 1 << 20, HashBits: hashBits}
+		if err := c.Verify(); err != nil {
+			t.Fatalf("Verify error %s", err)
+		}
+		mem := c.memUsage()
+		if prevMem > 0 && mem >= prevMem {
+			t.Fatalf("HashBits %d: memory usage %d; want less than %d",
+				hashBits, mem, prevMem)
+		}
+		prevMem = mem
+
+		var buf bytes.Buffer
+		w, err := c.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		t.Logf("HashBits %d: memory usage %d; compressed %d bytes",
+			hashBits, mem, buf.Len())
+		r, err := Reader2Config{DictCap: 1 << 20}.NewReader2(&buf)
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("HashBits %d: decompressed data differs",
+				hashBits)
+		}
+	}
+
+	for _, hashBits := range []int{-1, minTableExponent - 1, maxHashBits + 1} {
+		c := Writer2Config{HashBits: hashBits}
+		if err := c.Verify(); err == nil {
+			t.Fatalf("Verify accepted HashBits %d", hashBits)
+		}
+	}
+}
+
+func TestWriter2FlushResetsState(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(44)), 40000)
+	a, b := txt.Bytes()[:20000], txt.Bytes()[20000:]
+	for _, reset := range []bool{false, true} {
+		var buf bytes.Buffer
+		w, err := Writer2Config{
+			DictCap:          1 << 16,
+			FlushResetsState: reset,
+		}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(a); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("w.Flush error %s", err)
+		}
+		n := buf.Len()
+		if _, err = w.Write(b); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		data := buf.Bytes()
+
+		// flushed prefix
+		r, err := NewReader2(bytes.NewReader(data[:n]))
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		p := make([]byte, len(a))
+		if _, err = io.ReadFull(r, p); err != nil {
+			t.Fatalf("reset %t: io.ReadFull error %s", reset, err)
+		}
+		if !bytes.Equal(p, a) {
+			t.Fatalf("reset %t: prefix differs", reset)
+		}
+
+		// whole stream
+		r, err = NewReader2(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		p, err = ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("reset %t: ReadAll error %s", reset, err)
+		}
+		if !bytes.Equal(p, txt.Bytes()) {
+			t.Fatalf("reset %t: decompressed data differs", reset)
+		}
+
+		// continuation using only the decompressed prefix
+		r, err = Reader2Config{DictCap: 1 << 16}.NewReader2Dict(
+			bytes.NewReader(data[n:]), a)
+		if err != nil {
+			t.Fatalf("NewReader2Dict error %s", err)
+		}
+		p, err = ioutil.ReadAll(r)
+		if !reset {
+			if err == nil {
+				t.Fatalf("continuation without state reset " +
+					"decoded")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("continuation ReadAll error %s", err)
+		}
+		if !bytes.Equal(p, b) {
+			t.Fatalf("continuation differs")
+		}
+	}
+}
+
+// decodable returns the number of bytes that can be decoded from the
+// given LZMA2 chunks.
+func decodable(t *testing.T, lzma2 []byte) int {
+	r, err := NewReader2(bytes.NewReader(lzma2))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("io.Copy returned error %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+	return int(n)
+}
+
+func TestWriter2ChunkSize(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(45)), 60000)
+	const step = 1000
+	latency := func(chunkSize int) int {
+		var buf bytes.Buffer
+		w, err := Writer2Config{ChunkSize: chunkSize}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		var maxLatency int
+		for p := txt.Bytes(); len(p) > 0; p = p[step:] {
+			if _, err = w.Write(p[:step]); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			written := txt.Len() - len(p) + step
+			if d := written - decodable(t, buf.Bytes()); d > maxLatency {
+				maxLatency = d
+			}
+		}
+		return maxLatency
+	}
+	def := latency(0)
+	small := latency(4096)
+	t.Logf("maximum latency default %d bytes; chunk size 4096 %d bytes",
+		def, small)
+	if small > 4096+step {
+		t.Fatalf("latency %d for chunk size 4096 too large", small)
+	}
+	if small >= def {
+		t.Fatalf("latency %d for chunk size 4096 not smaller than "+
+			"default %d", small, def)
+	}
+	if _, err := (Writer2Config{ChunkSize: maxUncompressed + 1}).NewWriter2(
+		ioutil.Discard); err == nil {
+		t.Fatalf("NewWriter2 accepted chunk size out of range")
+	}
+}
+
+func TestWriter2FlushLatency(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(45)), 60000)
+	const step = 1000
+	for _, chunkSize := range []int{0, 4096} {
+		var buf bytes.Buffer
+		w, err := Writer2Config{ChunkSize: chunkSize}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		for p := txt.Bytes(); len(p) > 0; p = p[step:] {
+			if _, err = w.Write(p[:step]); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Flush(); err != nil {
+				t.Fatalf("w.Flush error %s", err)
+			}
+			// Flush terminates the pending chunk, so all data
+			// written is decodable whatever the chunk size.
+			written := txt.Len() - len(p) + step
+			if d := written - decodable(t, buf.Bytes()); d != 0 {
+				t.Fatalf("chunk size %d: latency %d after "+
+					"Flush; want 0", chunkSize, d)
+			}
+		}
+	}
+}
+
+func TestRawSize(t *testing.T) {
+	tests := []struct{ n, size int }{
+		{0, 1},
+		{1, 5},
+		{65536, 65540},
+		{65537, 65544},
+	}
+	for _, tc := range tests {
+		if size := RawSize(tc.n); size != tc.size {
+			t.Errorf("RawSize(%d) = %d; want %d", tc.n, size,
+				tc.size)
+		}
+	}
+
+	compress := func(data []byte) int {
+		var buf bytes.Buffer
+		w, err := Writer2Config{ChunkSize: maxUncompressedChunk}.
+			NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return buf.Len()
+	}
+
+	// Random data can't be compressed, so the output has exactly
+	// the size computed by RawSize.
+	data := make([]byte, 200000)
+	rand.New(rand.NewSource(52)).Read(data)
+	if n := compress(data); n != RawSize(len(data)) {
+		t.Fatalf("compressed size %d for random data; want %d", n,
+			RawSize(len(data)))
+	}
+
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(52)), 200000)
+	if n := compress(txt.Bytes()); n > RawSize(txt.Len()) {
+		t.Fatalf("compressed size %d for text exceeds %d", n,
+			RawSize(txt.Len()))
+	}
+}
+
+func TestWriter2ChunkLimits(t *testing.T) {
+	// Zeros compress well, so the uncompressed size limit of the
+	// compressed chunks is reached first.
+	data := make([]byte, 5*maxUncompressed+1000)
+	var buf bytes.Buffer
+	w, err := NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+	var full, total int
+	for {
+		h, err := readChunkHeader(r)
+		if err != nil {
+			t.Fatalf("readChunkHeader error %s", err)
+		}
+		if h.ctype == cEOS {
+			break
+		}
+		u := int(h.uncompressed) + 1
+		if uncompressed(h.ctype) {
+			if u > maxUncompressedChunk {
+				t.Fatalf("uncompressed chunk size %d", u)
+			}
+			r.Seek(int64(u), io.SeekCurrent)
+		} else {
+			if u > maxUncompressed {
+				t.Fatalf("compressed chunk with %d bytes", u)
+			}
+			if u == maxUncompressed {
+				full++
+			}
+			r.Seek(int64(h.compressed)+1, io.SeekCurrent)
+		}
+		total += u
+	}
+	if total != len(data) {
+		t.Fatalf("chunks contain %d bytes; want %d", total, len(data))
+	}
+	if full != 5 {
+		t.Fatalf("%d chunks with 2 MiB; want %d", full, 5)
+	}
+}
+
+func TestWriter2SyncEvery(t *testing.T) {
+	const (
+		syncEvery = 10000
+		step      = 3000
+	)
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(54)), 50000)
+	var buf bytes.Buffer
+	w, err := Writer2Config{SyncEvery: syncEvery}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	for p := txt.Bytes(); len(p) > 0; {
+		k := step
+		if k > len(p) {
+			k = len(p)
+		}
+		if _, err = w.Write(p[:k]); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		p = p[k:]
+		written := txt.Len() - len(p)
+		want := written / syncEvery * syncEvery
+		if n := decodable(t, buf.Bytes()); n != want {
+			t.Fatalf("after %d bytes %d bytes decodable; want %d",
+				written, n, want)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, txt.Bytes()) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestWriter2WritePatterns(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(55)), 1<<20)
+	data := txt.Bytes()
+	compress := func(sizes func() int) []byte {
+		var buf bytes.Buffer
+		w, err := Writer2Config{DictCap: 1 << 16}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		for p := data; len(p) > 0; {
+			k := sizes()
+			if k > len(p) {
+				k = len(p)
+			}
+			if _, err = w.Write(p[:k]); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			p = p[k:]
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return buf.Bytes()
+	}
+	want := compress(func() int { return len(data) })
+	rng := rand.New(rand.NewSource(56))
+	patterns := []func() int{
+		func() int { return 1 },
+		func() int { return 4096 },
+		func() int { return 1 + rng.Intn(100000) },
+	}
+	for i, sizes := range patterns {
+		if got := compress(sizes); !bytes.Equal(got, want) {
+			t.Fatalf("pattern %d: output differs", i)
+		}
+	}
+}
+
+// testAllocator records the allocations and frees.
+type testAllocator struct {
+	allocs []int
+	frees  []int
+}
+
+func (a *testAllocator) Alloc(n int) []byte {
+	a.allocs = append(a.allocs, n)
+	return make([]byte, n)
+}
+
+func (a *testAllocator) Free(p []byte) {
+	a.frees = append(a.frees, len(p))
+}
+
+func TestAllocator(t *testing.T) {
+	const dictCap = 1 << 16
+	var wa, ra testAllocator
+	var buf bytes.Buffer
+	w, err := Writer2Config{
+		DictCap:   dictCap,
+		Allocator: &wa,
+	}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	const txt = "The quick brown fox jumps over the lazy dog.\n"
+	if _, err = io.WriteString(w, txt); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if len(wa.allocs) != 1 || wa.allocs[0] <= dictCap {
+		t.Fatalf("writer allocations %v", wa.allocs)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if len(wa.frees) != 1 || wa.frees[0] != wa.allocs[0] {
+		t.Fatalf("writer frees %v; allocations %v", wa.frees,
+			wa.allocs)
+	}
+
+	r, err := Reader2Config{
+		DictCap:   dictCap,
+		Allocator: &ra,
+	}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if len(ra.allocs) != 1 || ra.allocs[0] != dictCap+1 {
+		t.Fatalf("reader allocations %v", ra.allocs)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(p) != txt {
+		t.Fatalf("got %q; want %q", p, txt)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatalf("r.Close error %s", err)
+	}
+	if len(ra.frees) != 1 || ra.frees[0] != ra.allocs[0] {
+		t.Fatalf("reader frees %v; allocations %v", ra.frees,
+			ra.allocs)
+	}
+	if _, err = r.Read(p); err == nil {
+		t.Fatalf("Read after Close succeeded")
+	}
+}
+
+func TestWriter2ShrinkDict(t *testing.T) {
+	const (
+		dictCap = 1 << 20
+		newSize = 1 << 16
+	)
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(23)), 400000)
+	data := txt.Bytes()
+	var wa testAllocator
+	var buf bytes.Buffer
+	w, err := Writer2Config{
+		DictCap:   dictCap,
+		Allocator: &wa,
+	}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data[:300000]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.ShrinkDict(2 * dictCap); err == nil {
+		t.Fatalf("ShrinkDict to a larger size succeeded")
+	}
+	if err = w.ShrinkDict(newSize); err != nil {
+		t.Fatalf("w.ShrinkDict error %s", err)
+	}
+	if len(wa.allocs) != 2 || wa.allocs[1] >= wa.allocs[0] {
+		t.Fatalf("writer allocations %v", wa.allocs)
+	}
+	if len(wa.frees) != 1 || wa.frees[0] != wa.allocs[0] {
+		t.Fatalf("writer frees %v; allocations %v", wa.frees,
+			wa.allocs)
+	}
+	if _, err = w.Write(data[300000:]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := Reader2Config{DictCap: dictCap}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
+
+// callCounter counts the calls of Write.
+type callCounter struct {
+	w     io.Writer
+	calls int
+}
+
+func (c *callCounter) Write(p []byte) (n int, err error) {
+	c.calls++
+	return c.w.Write(p)
+}
+
+func TestWriter2EncoderFlushSize(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(58)), 100000)
+	compress := func(flushSize int) (data []byte, calls int) {
+		var buf bytes.Buffer
+		cc := &callCounter{w: &buf}
+		w, err := Writer2Config{EncoderFlushSize: flushSize}.
+			NewWriter2(cc)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(txt.Bytes()); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		r, err := NewReader2(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(p, txt.Bytes()) {
+			t.Fatalf("flush size %d: decompressed data differs",
+				flushSize)
+		}
+		return buf.Bytes(), cc.calls
+	}
+	_, defCalls := compress(0)
+	data, calls := compress(minEncoderFlushSize)
+	t.Logf("writes default %d; flush size %d: %d", defCalls,
+		minEncoderFlushSize, calls)
+	if calls <= defCalls {
+		t.Fatalf("%d writes for flush size %d; default %d", calls,
+			minEncoderFlushSize, defCalls)
+	}
+	r := bytes.NewReader(data)
+	for {
+		h, err := readChunkHeader(r)
+		if err != nil {
+			t.Fatalf("readChunkHeader error %s", err)
+		}
+		if h.ctype == cEOS {
+			break
+		}
+		n := int64(h.uncompressed) + 1
+		if !uncompressed(h.ctype) {
+			n = int64(h.compressed) + 1
+			if n > minEncoderFlushSize {
+				t.Fatalf("compressed chunk size %d exceeds %d",
+					n, minEncoderFlushSize)
+			}
+		}
+		r.Seek(n, io.SeekCurrent)
+	}
+	if _, err := (Writer2Config{EncoderFlushSize: 100}).NewWriter2(
+		ioutil.Discard); err == nil {
+		t.Fatalf("NewWriter2 accepted flush size 100")
+	}
+}
+
+// failingWriter accepts n bytes and fails afterwards.
+type failingWriter struct {
+	n     int
+	calls int
+}
+
+var errFailingWriter = errors.New("failingWriter: write error")
+
+func (w *failingWriter) Write(p []byte) (n int, err error) {
+	w.calls++
+	if len(p) > w.n {
+		n = w.n
+		w.n = 0
+		return n, errFailingWriter
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriter2LatchesError(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(60)), 100000)
+	fw := &failingWriter{n: 1000}
+	w, err := Writer2Config{ChunkSize: 4096}.NewWriter2(fw)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != errFailingWriter {
+		t.Fatalf("w.Write returned error %v; want %v", err,
+			errFailingWriter)
+	}
+	calls := fw.calls
+	if _, err = w.Write(txt.Bytes()); err != errFailingWriter {
+		t.Fatalf("second w.Write returned error %v; want %v", err,
+			errFailingWriter)
+	}
+	if err = w.Flush(); err != errFailingWriter {
+		t.Fatalf("w.Flush returned error %v; want %v", err,
+			errFailingWriter)
+	}
+	for i := 0; i < 2; i++ {
+		if err = w.Close(); err != errFailingWriter {
+			t.Fatalf("w.Close returned error %v; want %v", err,
+				errFailingWriter)
+		}
+	}
+	if fw.calls != calls {
+		t.Fatalf("%d writes after the error", fw.calls-calls)
+	}
+
+	// an error in Close
+	fw = &failingWriter{n: 10}
+	if w, err = NewWriter2(fw); err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()[:100]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = w.Close(); err != errFailingWriter {
+			t.Fatalf("w.Close returned error %v; want %v", err,
+				errFailingWriter)
+		}
+	}
+}
+
+// chunkSizeData returns highly compressible data, whose chunks are
+// limited by the chunk size and not by the compressed size.
+func chunkSizeData() []byte {
+	return bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "),
+		(4<<20)/45)
+}
+
+// compressChunkSize compresses data with the given chunk size and
+// returns the compressed data.
+func compressChunkSize(tb testing.TB, data []byte, chunkSize int) []byte {
+	var buf bytes.Buffer
+	w, err := Writer2Config{ChunkSize: chunkSize}.NewWriter2(&buf)
+	if err != nil {
+		tb.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		tb.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		tb.Fatalf("w.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriter2ChunkOverhead(t *testing.T) {
+	data := chunkSizeData()
+	prevSize := -1
+	for _, chunkSize := range []int{1 << 16, 1 << 18, 0} {
+		compressed := compressChunkSize(t, data, chunkSize)
+		cs := chunkSize
+		if cs == 0 {
+			cs = maxUncompressed
+		}
+		chunks := len(chunkTypes(t, compressed)) - 1
+		want := (len(data) + cs - 1) / cs
+		if chunks != want {
+			t.Fatalf("chunk size %d: got %d chunks; want %d",
+				cs, chunks, want)
+		}
+		t.Logf("chunk size %d: %d chunks, %d bytes", cs, chunks,
+			len(compressed))
+		if prevSize >= 0 && len(compressed) >= prevSize {
+			t.Fatalf("chunk size %d: compressed size %d; "+
+				"want less than %d", cs, len(compressed),
+				prevSize)
+		}
+		prevSize = len(compressed)
+	}
+}
+
+func BenchmarkWriter2ChunkSize(b *testing.B) {
+	data := chunkSizeData()
+	for _, chunkSize := range []int{1 << 16, 1 << 18, maxUncompressed} {
+		b.Run(fmt.Sprintf("%dKiB", chunkSize>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			var n int
+			for i := 0; i < b.N; i++ {
+				n = len(compressChunkSize(b, data, chunkSize))
+			}
+			b.ReportMetric(float64(n), "compressed-bytes")
+		})
+	}
+}
+
+func TestWriter2LeadingResetChunk(t *testing.T) {
+	// A stream consisting of a single uncompressed chunk resetting
+	// the dictionary. LZMA2 has no reset chunk without data.
+	r, err := NewReader2(bytes.NewReader([]byte{hUD, 0, 0, 'x', hEOS}))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(p) != "x" {
+		t.Fatalf("got %q; want %q", p, "x")
+	}
+
+	// Incompressible data at the start of the stream is written as
+	// uncompressed chunk resetting the dictionary. The following
+	// compressed chunk must not reset it again.
+	rnd := rand.New(rand.NewSource(3))
+	var txt bytes.Buffer
+	io.CopyN(&txt, rnd, 1000)
+	head := txt.Len()
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(3)), 20000)
+	var buf bytes.Buffer
+	w, err := Writer2Config{}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()[:head]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush error %s", err)
+	}
+	// flushing without data doesn't create an empty chunk
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()[head:]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	types := chunkTypes(t, buf.Bytes())
+	if len(types) < 3 || types[0] != cUD || types[1] != cLRN {
+		t.Fatalf("got chunk types %v; want UD and LRN at the start",
+			types)
+	}
+	for _, c := range types[1:] {
+		if c == cUD || c == cLRND {
+			t.Fatalf("got chunk types %v; want a single reset",
+				types)
+		}
+	}
+	if r, err = NewReader2(&buf); err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if p, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, txt.Bytes()) {
+		t.Fatal("decompressed data differs from original")
+	}
+}
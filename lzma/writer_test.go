@@ -247,3 +247,172 @@ func BenchmarkWriter(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkWriterRandom(b *testing.B) {
+	const size = 1 << 20
+	data := make([]byte, size)
+	rand.New(rand.NewSource(50)).Read(data)
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w, err := WriterConfig{DictCap: 1 << 20}.NewWriter(
+			ioutil.Discard)
+		if err != nil {
+			b.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			b.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			b.Fatalf("w.Close error %s", err)
+		}
+	}
+}
+
+func TestWriterSizeInHeaderNoEOS(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(43)), 10000)
+	data := txt.Bytes()
+	compress := func(eos bool) []byte {
+		var buf bytes.Buffer
+		w, err := WriterConfig{
+			Size:      int64(len(data)),
+			EOSMarker: eos,
+		}.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return buf.Bytes()
+	}
+	lz := compress(false)
+	var h header
+	if err := h.unmarshalBinary(lz[:HeaderLen]); err != nil {
+		t.Fatalf("unmarshalBinary error %s", err)
+	}
+	if h.size != int64(len(data)) {
+		t.Fatalf("size in header %d; want %d", h.size, len(data))
+	}
+	if n := len(compress(true)); len(lz) >= n {
+		t.Fatalf("stream without EOS marker has %d bytes;"+
+			" want less than %d", len(lz), n)
+	}
+	r, err := NewReader(bytes.NewReader(lz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
+
+// seekBuffer is an in-memory io.WriteSeeker.
+type seekBuffer struct {
+	data []byte
+	off  int64
+}
+
+func (b *seekBuffer) Write(p []byte) (n int, err error) {
+	if end := b.off + int64(len(p)); end > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, end-int64(len(b.data)))...)
+	}
+	n = copy(b.data[b.off:], p)
+	b.off += int64(n)
+	return n, nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.off
+	case io.SeekEnd:
+		offset += int64(len(b.data))
+	}
+	b.off = offset
+	return offset, nil
+}
+
+func TestWriterSizeOverrun(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(63)), 20000)
+	data := txt.Bytes()
+	const size = 10000
+	decode := func(lz []byte) []byte {
+		r, err := NewReader(bytes.NewReader(lz))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		return p
+	}
+
+	// strict
+	w, err := WriterConfig{Size: size}.NewWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	n, err := w.Write(data)
+	if err != ErrNoSpace {
+		t.Fatalf("w.Write returned error %v; want %v", err, ErrNoSpace)
+	}
+	if n != size {
+		t.Fatalf("w.Write returned %d; want %d", n, size)
+	}
+
+	// lenient with an io.WriteSeeker
+	sb := &seekBuffer{}
+	w, err = WriterConfig{Size: size, AllowSizeOverrun: true}.NewWriter(sb)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if !bytes.Equal(decode(sb.data), data) {
+		t.Fatalf("decompressed data differs from original")
+	}
+
+	// lenient without io.WriteSeeker
+	var buf bytes.Buffer
+	w, err = WriterConfig{Size: size, AllowSizeOverrun: true}.NewWriter(
+		&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != ErrSizeOverrun {
+		t.Fatalf("w.Close returned error %v; want %v", err,
+			ErrSizeOverrun)
+	}
+	lz := buf.Bytes()
+	r, err := NewReader(bytes.NewReader(lz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err == nil {
+		t.Fatalf("decoder accepted data beyond the declared size")
+	}
+	for i := 5; i < 13; i++ {
+		lz[i] = 0xff
+	}
+	if !bytes.Equal(decode(lz), data) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
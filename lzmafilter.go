@@ -59,6 +59,22 @@ func (f *lzmaFilter) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// dictChain stores the LZMA2 writer or reader of the previous block.
+// The next block continues it, so the dictionary is kept without
+// copying it.
+type dictChain struct {
+	w *lzma.Writer2
+	r *lzma.Reader2
+	// dictionary capacity of r
+	dictCap int
+	// window of r
+	window int
+}
+
+// errMaxDictCap indicates that a block requires a dictionary capacity
+// exceeding ReaderConfig.MaxDictCap.
+var errMaxDictCap = errors.New("xz: dictionary capacity exceeds MaxDictCap")
+
 // reader creates a new reader for the LZMA2 filter.
 func (f lzmaFilter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
 	err error) {
@@ -66,21 +82,45 @@ func (f lzmaFilter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
 	config := new(lzma.Reader2Config)
 	if c != nil {
 		config.DictCap = c.DictCap
+		config.Lazy = c.Lazy
 	}
 	dc := int(f.dictCap)
 	if dc < 1 {
 		return nil, errors.New("xz: LZMA2 filter parameter " +
 			"dictionary capacity overflow")
 	}
+	if c != nil && c.MaxDictCap > 0 && dc > c.MaxDictCap {
+		return nil, errMaxDictCap
+	}
 	if dc > config.DictCap {
 		config.DictCap = dc
 	}
+	// Distances beyond the declared capacity indicate corruption,
+	// even if the dictionary is larger.
+	config.Window = dc
 
-	fr, err = config.NewReader2(r)
+	if c == nil || c.chain == nil {
+		return config.NewReader2(r)
+	}
+	// A block starting with a dictionary reset is read correctly by
+	// a continued reader, so the chain supports files written with
+	// and without NoDictReset.
+	chain := c.chain
+	if chain.r != nil && config.DictCap <= chain.dictCap &&
+		dc == chain.window {
+		chain.r.Continue(r)
+		return chain.r, nil
+	}
+	var dict []byte
+	if chain.r != nil {
+		dict = chain.r.Dict()
+	}
+	lr, err := config.NewReader2Dict(r, dict)
 	if err != nil {
 		return nil, err
 	}
-	return fr, nil
+	chain.r, chain.dictCap, chain.window = lr, config.DictCap, dc
+	return lr, nil
 }
 
 // writeCloser creates a io.WriteCloser for the LZMA2 filter.
@@ -93,6 +133,8 @@ func (f lzmaFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
 			DictCap:    c.DictCap,
 			BufSize:    c.BufSize,
 			Matcher:    c.Matcher,
+			NiceLen:    c.NiceLen,
+			HashBits:   c.HashBits,
 		}
 	}
 
@@ -105,11 +147,21 @@ func (f lzmaFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
 		config.DictCap = dc
 	}
 
-	fw, err = config.NewWriter2(w)
+	if c == nil || c.chain == nil {
+		return config.NewWriter2(w)
+	}
+	if c.chain.w != nil {
+		if err = c.chain.w.Continue(w); err != nil {
+			return nil, err
+		}
+		return c.chain.w, nil
+	}
+	lw, err := config.NewWriter2(w)
 	if err != nil {
 		return nil, err
 	}
-	return fw, nil
+	c.chain.w = lw
+	return lw, nil
 }
 
 // last returns true, because an LZMA2 filter must be the last filter in
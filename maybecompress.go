@@ -0,0 +1,42 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "io"
+
+// closingWriter is an xz writer whose Close method closes the
+// underlying writer as well.
+type closingWriter struct {
+	*Writer
+	c io.Closer
+}
+
+// Close closes the xz writer and the underlying writer. The underlying
+// writer is closed even if the xz writer returns an error. The first
+// error is returned.
+func (w *closingWriter) Close() error {
+	err := w.Writer.Close()
+	if cerr := w.c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// MaybeCompress returns w itself if enabled is false. Otherwise it
+// returns an xz writer created with cfg that writes the compressed data
+// to w. Closing the xz writer completes the xz stream and closes w. The
+// function allows callers to compress conditionally without branching
+// in the code using the writer.
+func MaybeCompress(w io.WriteCloser, enabled bool, cfg WriterConfig,
+) (io.WriteCloser, error) {
+	if !enabled {
+		return w, nil
+	}
+	xw, err := cfg.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &closingWriter{Writer: xw, c: w}, nil
+}
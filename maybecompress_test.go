@@ -0,0 +1,57 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// closeBuffer is a buffer that records whether it has been closed.
+type closeBuffer struct {
+	bytes.Buffer
+	closed int
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed++
+	return nil
+}
+
+func TestMaybeCompress(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog.\n"
+	for _, enabled := range []bool{false, true} {
+		var buf closeBuffer
+		w, err := MaybeCompress(&buf, enabled, WriterConfig{})
+		if err != nil {
+			t.Fatalf("MaybeCompress error %s", err)
+		}
+		if _, err = w.Write([]byte(text)); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		if buf.closed != 1 {
+			t.Fatalf("enabled %t: underlying writer closed %d times",
+				enabled, buf.closed)
+		}
+		got := buf.Bytes()
+		if enabled {
+			r, err := NewReader(bytes.NewReader(got))
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			if got, err = ioutil.ReadAll(r); err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+		}
+		if string(got) != text {
+			t.Fatalf("enabled %t: got %q; want %q", enabled, got,
+				text)
+		}
+	}
+}
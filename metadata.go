@@ -0,0 +1,103 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// The xz format has no comment field. Metadata is stored in an
+// additional xz stream after the main stream, whose uncompressed data
+// starts with metadataMagic.
+
+// metadataMagic identifies a metadata stream.
+var metadataMagic = []byte("\x00xz-go-metadata\x00")
+
+// maxMetadataLen limits the size of the metadata.
+const maxMetadataLen = 1 << 20
+
+// errMetadataTooLarge indicates metadata exceeding maxMetadataLen.
+var errMetadataTooLarge = errors.New("xz: metadata too large")
+
+// writeMetadata writes a metadata stream containing data to xz.
+func (c *WriterConfig) writeMetadata(xz io.Writer, data []byte) error {
+	w, err := WriterConfig{
+		DictCap:  lzma.MinDictCap,
+		CheckSum: c.CheckSum,
+	}.NewWriter(xz)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(metadataMagic); err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// readMetadata checks whether the current stream is a metadata stream.
+// If it is, the metadata is stored and the stream is consumed.
+// Otherwise the data read is stored to be returned by Read. The
+// function sets r.sr to nil if the stream has been completely read.
+// The first stream is never a metadata stream, because the metadata
+// stream follows the main stream.
+func (r *Reader) readMetadata() error {
+	if r.stream == 0 {
+		return nil
+	}
+	p := make([]byte, len(metadataMagic))
+	var n int
+	var err error
+	for n < len(p) && err == nil {
+		var k int
+		k, err = r.sr.Read(p[n:])
+		n += k
+	}
+	switch err {
+	case nil:
+	case io.EOF:
+		// The stream has been read completely and the footer
+		// checked.
+		if n == len(p) && bytes.Equal(p, metadataMagic) {
+			r.metadata = []byte{}
+		} else {
+			r.pending = p[:n]
+		}
+		r.endStream()
+		return nil
+	default:
+		return err
+	}
+	if !bytes.Equal(p, metadataMagic) {
+		r.pending = p
+		return nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.sr, maxMetadataLen+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxMetadataLen {
+		return errMetadataTooLarge
+	}
+	r.metadata = data
+	r.endStream()
+	return nil
+}
+
+// Metadata returns the metadata stored by a Writer with
+// WriterConfig.Metadata set. The metadata stream follows the main
+// stream, so the method returns nil until Read has returned io.EOF or
+// if the file doesn't contain metadata. The metadata is not part of
+// the data returned by Read.
+func (r *Reader) Metadata() []byte {
+	return r.metadata
+}
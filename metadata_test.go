@@ -0,0 +1,147 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestMetadata(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(65)), 50000)
+	meta := []byte("name=test.txt\ntime=2022-01-01T00:00:00Z\n")
+	var buf bytes.Buffer
+	w, err := WriterConfig{Metadata: meta, VerifyOutput: true}.NewWriter(
+		&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	xz := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if m := r.Metadata(); m != nil {
+		t.Fatalf("Metadata returned %q before the end", m)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, txt.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+	if m := r.Metadata(); !bytes.Equal(m, meta) {
+		t.Fatalf("Metadata returned %q; want %q", m, meta)
+	}
+
+	// A short second stream without metadata is returned as data.
+	var tail bytes.Buffer
+	w, err = NewWriter(&tail)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write([]byte("abc")); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	xz = append(xz, tail.Bytes()...)
+	r, err = NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if p, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if want := append(txt.Bytes(), "abc"...); !bytes.Equal(p, want) {
+		t.Fatalf("decompressed data differs from original")
+	}
+	if m := r.Metadata(); !bytes.Equal(m, meta) {
+		t.Fatalf("Metadata returned %q; want %q", m, meta)
+	}
+}
+
+func TestMetadataTruncatedStream(t *testing.T) {
+	var first bytes.Buffer
+	w, err := NewWriter(&first)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write([]byte("hello")); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	var second bytes.Buffer
+	if w, err = NewWriter(&second); err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write([]byte("tiny")); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+
+	for k := 1; k < 30; k++ {
+		xz := append(append([]byte{}, first.Bytes()...),
+			second.Bytes()[:second.Len()-k]...)
+		r, err := NewReader(bytes.NewReader(xz))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		if _, err = ioutil.ReadAll(r); err == nil {
+			t.Fatalf("ReadAll of stream truncated by %d bytes "+
+				"returned no error", k)
+		}
+	}
+}
+
+func TestMetadataFirstStream(t *testing.T) {
+	// The main stream is returned as data even if it starts with
+	// the magic.
+	data := append(append([]byte{}, metadataMagic...), "data"...)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("ReadAll returned %q; want %q", p, data)
+	}
+	if m := r.Metadata(); m != nil {
+		t.Fatalf("Metadata returned %q; want nil", m)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// filterVersions maps the filter IDs defined by the xz format to the
+// release of XZ Utils that introduced them.
+var filterVersions = map[uint64]string{
+	0x03:         "5.0.0", // Delta
+	0x04:         "5.0.0", // x86 BCJ
+	0x05:         "5.0.0", // PowerPC BCJ
+	0x06:         "5.0.0", // IA-64 BCJ
+	0x07:         "5.0.0", // ARM BCJ
+	0x08:         "5.0.0", // ARM-Thumb BCJ
+	0x09:         "5.0.0", // SPARC BCJ
+	0x0a:         "5.4.0", // ARM64 BCJ
+	0x0b:         "5.6.0", // RISC-V BCJ
+	lzmaFilterID: "5.0.0", // LZMA2
+}
+
+// baseVersion is the first stable release of XZ Utils. It supports all
+// check types.
+const baseVersion = "5.0.0"
+
+// blockFilterIDs returns the filter IDs of the block header in data.
+// In contrast to blockHeader.UnmarshalBinary the filters don't need to
+// be supported by this package.
+func blockFilterIDs(data []byte) (ids []uint64, err error) {
+	n := len(data) - 4
+	if n < 2 {
+		return nil, errors.New("xz: block header too short")
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(data[:n])
+	if crc.Sum32() != uint32LE(data[n:]) {
+		return nil, errors.New("xz: checksum error for block header")
+	}
+	flags := data[1]
+	if flags&reservedBlockFlags != 0 {
+		return nil, errors.New("xz: reserved block header flags set")
+	}
+	r := bytes.NewReader(data[2:n])
+	if _, err = readSizeInBlockHeader(
+		r, flags&compressedSizePresent != 0); err != nil {
+		return nil, err
+	}
+	if _, err = readSizeInBlockHeader(
+		r, flags&uncompressedSizePresent != 0); err != nil {
+		return nil, err
+	}
+	count := int(flags&filterCountMask) + 1
+	for i := 0; i < count; i++ {
+		id, _, err := readUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		size, _, err := readUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if size > uint64(r.Len()) {
+			return nil, errors.New("xz: filter properties too large")
+		}
+		r.Seek(int64(size), io.SeekCurrent)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// compareVersions compares two version strings of the form
+// major.minor.patch numerically.
+func compareVersions(a, b string) int {
+	var x, y [3]int
+	fmt.Sscanf(a, "%d.%d.%d", &x[0], &x[1], &x[2])
+	fmt.Sscanf(b, "%d.%d.%d", &y[0], &y[1], &y[2])
+	for i := range x {
+		switch {
+		case x[i] < y[i]:
+			return -1
+		case x[i] > y[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// MinVersion reports the oldest release of XZ Utils that can decompress
+// the xz file read from r. The version is derived from the filters
+// used by the blocks, so a tool can ask the user to upgrade if the
+// installed xz is older. Filter IDs unknown to this package result in
+// an error. No block data is decompressed. If r supports io.ReaderAt
+// and io.Seeker the headers of all blocks of all streams are inspected
+// using the index; the read position is restored afterwards. Otherwise
+// only the first block header of the first stream is read.
+func MinVersion(r io.Reader) (version string, err error) {
+	p := make([]byte, HeaderLen)
+	if _, err = io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	var h header
+	if err = h.UnmarshalBinary(p); err != nil {
+		return "", err
+	}
+	version = baseVersion
+	update := func(data []byte) error {
+		ids, err := blockFilterIDs(data)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			v, ok := filterVersions[id]
+			if !ok {
+				return fmt.Errorf("xz: unknown filter id %#x", id)
+			}
+			if compareVersions(v, version) > 0 {
+				version = v
+			}
+		}
+		return nil
+	}
+
+	ra, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		data, _, err := readBlockHeaderData(r)
+		switch err {
+		case nil:
+			if err = update(data); err != nil {
+				return "", err
+			}
+		case errIndexIndicator:
+		default:
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		return version, nil
+	}
+
+	pos, err := ra.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	size, err := ra.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	if _, err = ra.Seek(pos, io.SeekStart); err != nil {
+		return "", err
+	}
+	blocks, err := readIndex(ra, size)
+	if err != nil {
+		return "", err
+	}
+	for _, b := range blocks {
+		sr := io.NewSectionReader(ra, b.offset, b.rec.paddedSize())
+		data, _, err := readBlockHeaderData(sr)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if err = update(data); err != nil {
+			return "", err
+		}
+	}
+	return version, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// filterHeader returns a stream header followed by a block header
+// using the filters with the given IDs before the LZMA2 filter. The
+// filters have no properties.
+func filterHeader(t *testing.T, ids ...byte) []byte {
+	h := header{flags: CRC32}
+	sh, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	p := []byte{0, byte(len(ids))}
+	for _, id := range ids {
+		p = append(p, id, 0)
+	}
+	p = append(p, lzmaFilterID, 1, 0)
+	for len(p)%4 != 0 {
+		p = append(p, 0)
+	}
+	// The header including the CRC-32 has len(p)+4 bytes.
+	p[0] = byte(len(p) / 4)
+	var crc [4]byte
+	putUint32LE(crc[:], crc32.ChecksumIEEE(p))
+	return append(sh, append(p, crc[:]...)...)
+}
+
+func TestMinVersion(t *testing.T) {
+	fox, err := ioutil.ReadFile("fox.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	tests := []struct {
+		name string
+		r    io.Reader
+		want string
+	}{
+		{"fox.xz seekable", bytes.NewReader(fox), "5.0.0"},
+		{"fox.xz", bytes.NewBuffer(fox), "5.0.0"},
+		{"delta", bytes.NewBuffer(filterHeader(t, 0x03)), "5.0.0"},
+		{"arm64", bytes.NewBuffer(filterHeader(t, 0x0a)), "5.4.0"},
+		{"riscv", bytes.NewBuffer(filterHeader(t, 0x0b)), "5.6.0"},
+		{"x86 riscv", bytes.NewBuffer(filterHeader(t, 0x04, 0x0b)),
+			"5.6.0"},
+	}
+	for _, tc := range tests {
+		v, err := MinVersion(tc.r)
+		if err != nil {
+			t.Fatalf("%s: MinVersion error %s", tc.name, err)
+		}
+		if v != tc.want {
+			t.Errorf("%s: MinVersion returned %q; want %q",
+				tc.name, v, tc.want)
+		}
+	}
+	_, err = MinVersion(bytes.NewBuffer(filterHeader(t, 0x30)))
+	if err == nil {
+		t.Fatalf("MinVersion accepted unknown filter id")
+	}
+}
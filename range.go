@@ -0,0 +1,152 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// errRangeClosed is returned by the Read method of a closed range
+// reader.
+var errRangeClosed = errors.New("xz: range reader closed")
+
+// rangeReader reads a range of the uncompressed data of an xz file
+// block by block.
+type rangeReader struct {
+	cfg    ReaderConfig
+	ra     io.ReaderAt
+	blocks []blockInfo
+	// reader for the current block
+	br io.Reader
+	// bytes to discard at the start of the next block
+	skip int64
+	// bytes remaining in the range
+	n   int64
+	err error
+}
+
+// DecodeRange returns a reader for length bytes of the uncompressed
+// data of the xz file provided by ra and size, starting at the
+// uncompressed offset start. The index of the file is used to locate
+// the block containing start; decoding starts at the beginning of that
+// block and the data before start is discarded. So only the blocks
+// overlapping the range are decompressed, which makes the function
+// suitable to serve HTTP range requests from compressed files. The
+// function returns an error if the range isn't contained in the
+// uncompressed data. The check of a block is verified only if the
+// block is read to its end, so the last block of a range ending inside
+// it is not verified.
+func DecodeRange(ra io.ReaderAt, size int64, start, length int64) (
+	io.ReadCloser, error) {
+
+	if start < 0 || length < 0 {
+		return nil, errors.New("xz: negative range")
+	}
+	blocks, err := readIndex(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	var usize int64
+	if k := len(blocks); k > 0 {
+		b := blocks[k-1]
+		usize = b.uncompressedOffset + b.rec.uncompressedSize
+	}
+	if start > usize {
+		return nil, errors.New("xz: range start beyond end of data")
+	}
+	if length > usize-start {
+		return nil, errors.New("xz: range exceeds end of data")
+	}
+	i := 0
+	for i < len(blocks) {
+		b := blocks[i]
+		if start < b.uncompressedOffset+b.rec.uncompressedSize {
+			break
+		}
+		i++
+	}
+	r := &rangeReader{ra: ra, blocks: blocks[i:], n: length}
+	if i < len(blocks) {
+		r.skip = start - blocks[i].uncompressedOffset
+	}
+	if err = r.cfg.Verify(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// nextBlock opens the next block and discards the bytes before the
+// start of the range.
+func (r *rangeReader) nextBlock() error {
+	for len(r.blocks) > 0 {
+		b := r.blocks[0]
+		r.blocks = r.blocks[1:]
+		if b.rec.uncompressedSize == 0 {
+			continue
+		}
+		br, err := r.cfg.openBlock(r.ra, b)
+		if err != nil {
+			return err
+		}
+		if r.skip > 0 {
+			if _, err = io.CopyN(ioutil.Discard, br,
+				r.skip); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			r.skip = 0
+		}
+		r.br = br
+		return nil
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// Read reads uncompressed data of the range into p.
+func (r *rangeReader) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for n < len(p) {
+		if r.n <= 0 {
+			r.err = io.EOF
+			break
+		}
+		if r.br == nil {
+			if r.err = r.nextBlock(); r.err != nil {
+				break
+			}
+		}
+		q := p[n:]
+		if int64(len(q)) > r.n {
+			q = q[:r.n]
+		}
+		k, err := r.br.Read(q)
+		n += k
+		r.n -= int64(k)
+		if err == io.EOF {
+			r.br = nil
+		} else if err != nil {
+			r.err = err
+			break
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, r.err
+}
+
+// Close closes the reader. Subsequent reads return an error.
+func (r *rangeReader) Close() error {
+	r.br = nil
+	r.blocks = nil
+	r.err = errRangeClosed
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestDecodeRange(t *testing.T) {
+	const txtlen = 100000
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(7)), txtlen)
+	orig := txt.Bytes()
+
+	// two streams separated by stream padding
+	xz := compressBlocks(t, orig[:txtlen/2], 8*1024)
+	xz = append(xz, 0, 0, 0, 0)
+	xz = append(xz, compressBlocks(t, orig[txtlen/2:], 10000)...)
+	ra := bytes.NewReader(xz)
+	size := int64(len(xz))
+
+	tests := []struct{ start, length int64 }{
+		{0, 0},
+		{0, 10},
+		{0, txtlen},
+		{100, 8000},
+		{8*1024 - 5, 10},
+		{8 * 1024, 8 * 1024},
+		{1000, 40000},
+		{txtlen/2 - 7, 20},
+		{txtlen - 1, 1},
+		{txtlen, 0},
+	}
+	for _, tc := range tests {
+		r, err := DecodeRange(ra, size, tc.start, tc.length)
+		if err != nil {
+			t.Fatalf("DecodeRange(%d, %d) error %s",
+				tc.start, tc.length, err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if err = r.Close(); err != nil {
+			t.Fatalf("r.Close error %s", err)
+		}
+		want := orig[tc.start : tc.start+tc.length]
+		if !bytes.Equal(p, want) {
+			t.Fatalf("range [%d,%d) differs from original",
+				tc.start, tc.start+tc.length)
+		}
+	}
+
+	if _, err := DecodeRange(ra, size, txtlen+1, 0); err == nil {
+		t.Fatalf("DecodeRange accepted start beyond end")
+	}
+	if _, err := DecodeRange(ra, size, txtlen-10, 11); err == nil {
+		t.Fatalf("DecodeRange accepted range beyond end")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+)
+
+// copyLZMA2 copies the LZMA2 chunk sequence from src to dst. The chunks
+// are copied verbatim but decoded to find the end-of-stream chunk and
+// to compute the hash of the uncompressed data. The function returns
+// the number of compressed and uncompressed bytes. The LZMA2 reader
+// reads the chunks byte by byte, so the writes to dst are buffered.
+func copyLZMA2(dst io.Writer, src io.Reader, dictCap int64, h hash.Hash,
+) (compressed, uncompressed int64, err error) {
+	cr := countingReader{r: src}
+	bw := bufio.NewWriter(dst)
+	fr, err := lzmaFilter{dictCap}.reader(io.TeeReader(&cr, bw), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if uncompressed, err = io.Copy(h, fr); err != nil {
+		return cr.n, uncompressed, err
+	}
+	return cr.n, uncompressed, bw.Flush()
+}
+
+// ExtractLZMA2 copies the raw LZMA2 chunk sequence of the single block
+// of the xz stream in src to dst. The block must use the LZMA2 filter
+// only. The chunks are not recompressed, but they are decoded to locate
+// the end of the block and to verify the check of the block. The index
+// and the footer of the stream are verified as well.
+func ExtractLZMA2(dst io.Writer, src io.Reader) error {
+	sr, err := ReaderConfig{}.newStreamReader(src)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	bh, hlen, err := readBlockHeader(src)
+	if err != nil {
+		if err == errIndexIndicator {
+			return errors.New("xz: stream has no block")
+		}
+		return err
+	}
+	if len(bh.filters) != 1 {
+		return errors.New("xz: block doesn't use the LZMA2 filter only")
+	}
+	f, ok := bh.filters[0].(*lzmaFilter)
+	if !ok {
+		return errors.New("xz: block doesn't use the LZMA2 filter only")
+	}
+	h := sr.newHash()
+	c, u, err := copyLZMA2(dst, src, f.dictCap, h)
+	if err != nil {
+		return err
+	}
+	if bh.compressedSize >= 0 && bh.compressedSize != c {
+		return errors.New("xz: wrong compressed size for block")
+	}
+	if bh.uncompressedSize >= 0 && bh.uncompressedSize != u {
+		return errors.New("xz: wrong uncompressed size for block")
+	}
+
+	s := h.Size()
+	k := padLen(c)
+	q := make([]byte, k+s, k+2*s)
+	if _, err = io.ReadFull(src, q); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if !allZeros(q[:k]) {
+		return errors.New("xz: non-zero block padding")
+	}
+	if !bytes.Equal(q[k:], h.Sum(q[k+s:])) {
+		return errors.New("xz: checksum error for block")
+	}
+	sr.index = append(sr.index,
+		record{int64(hlen) + c + int64(s), u})
+
+	if _, _, err = readBlockHeader(src); err != errIndexIndicator {
+		if err == nil {
+			return errors.New("xz: stream has more than one block")
+		}
+		return err
+	}
+	return sr.readTail()
+}
+
+// WrapLZMA2 wraps the raw LZMA2 chunk sequence in src into an xz stream
+// consisting of a single block and writes it to dst. The chunk sequence
+// must be terminated by an end-of-stream chunk. The chunks are copied
+// verbatim, but they must be decoded to compute the check given by the
+// check argument. The dictSize argument must provide the dictionary
+// size used to create the chunk sequence.
+func WrapLZMA2(dst io.Writer, src io.Reader, dictSize int64, check byte,
+) error {
+	h := header{flags: check}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	newHash, err := newHashFunc(check)
+	if err != nil {
+		return err
+	}
+	if _, err = dst.Write(data); err != nil {
+		return err
+	}
+
+	bh := blockHeader{
+		compressedSize:   -1,
+		uncompressedSize: -1,
+		filters:          []filter{&lzmaFilter{dictSize}},
+	}
+	if data, err = bh.MarshalBinary(); err != nil {
+		return err
+	}
+	if _, err = dst.Write(data); err != nil {
+		return err
+	}
+	hlen := len(data)
+
+	hash := newHash()
+	c, u, err := copyLZMA2(dst, src, dictSize, hash)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	s := hash.Size()
+	p := make([]byte, padLen(c), padLen(c)+s)
+	if _, err = dst.Write(hash.Sum(p)); err != nil {
+		return err
+	}
+
+	index := []record{{int64(hlen) + c + int64(s), u}}
+	f := footer{flags: check}
+	if f.indexSize, err = writeIndex(dst, index); err != nil {
+		return err
+	}
+	if data, err = f.MarshalBinary(); err != nil {
+		return err
+	}
+	_, err = dst.Write(data)
+	return err
+}
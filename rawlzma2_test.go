@@ -0,0 +1,114 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestExtractLZMA2(t *testing.T) {
+	const txtlen = 100000
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(13)), txtlen)
+
+	var xz bytes.Buffer
+	w, err := NewWriter(&xz)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+
+	var raw bytes.Buffer
+	if err = ExtractLZMA2(&raw, bytes.NewReader(xz.Bytes())); err != nil {
+		t.Fatalf("ExtractLZMA2 error %s", err)
+	}
+	r, err := lzma.NewReader2(&raw)
+	if err != nil {
+		t.Fatalf("lzma.NewReader2 error %s", err)
+	}
+	var out bytes.Buffer
+	if _, err = io.Copy(&out, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), txt.Bytes()) {
+		t.Fatal("decompressed data differs from original")
+	}
+}
+
+func TestWrapLZMA2(t *testing.T) {
+	const txtlen = 100000
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(14)), txtlen)
+
+	const dictCap = 1 << 16
+	var raw bytes.Buffer
+	w, err := lzma.Writer2Config{DictCap: dictCap}.NewWriter2(&raw)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	rawData := append([]byte(nil), raw.Bytes()...)
+
+	for _, check := range []byte{None, CRC32, CRC64, SHA256} {
+		var xz bytes.Buffer
+		err = WrapLZMA2(&xz, bytes.NewReader(rawData), dictCap, check)
+		if err != nil {
+			t.Fatalf("WrapLZMA2 error %s", err)
+		}
+		r, err := NewReader(bytes.NewReader(xz.Bytes()))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		var out bytes.Buffer
+		if _, err = io.Copy(&out, r); err != nil {
+			t.Fatalf("io.Copy error %s", err)
+		}
+		if !bytes.Equal(out.Bytes(), txt.Bytes()) {
+			t.Fatal("decompressed data differs from original")
+		}
+
+		var back bytes.Buffer
+		if err = ExtractLZMA2(&back, &xz); err != nil {
+			t.Fatalf("ExtractLZMA2 error %s", err)
+		}
+		if !bytes.Equal(back.Bytes(), rawData) {
+			t.Fatalf("extracted LZMA2 data differs from original")
+		}
+	}
+}
+
+func TestExtractLZMA2Writes(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(15)), 100000)
+	xz, err := compressData(txt.Bytes(), WriterConfig{})
+	if err != nil {
+		t.Fatalf("compressData error %s", err)
+	}
+	var raw writeCounter
+	if err = ExtractLZMA2(&raw, bytes.NewReader(xz)); err != nil {
+		t.Fatalf("ExtractLZMA2 error %s", err)
+	}
+	// The compressed bytes must not be written one by one.
+	if max := raw.Len()/1024 + 1; raw.writes > max {
+		t.Fatalf("%d writes for %d bytes; want at most %d", raw.writes,
+			raw.Len(), max)
+	}
+}
@@ -13,6 +13,9 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/ulikunitz/xz/internal/xlog"
 	"github.com/ulikunitz/xz/lzma"
@@ -24,6 +27,118 @@ import (
 type ReaderConfig struct {
 	DictCap      int
 	SingleStream bool
+	// MaxBytesPerSecond limits the rate of the decompressed bytes
+	// returned by Read. Zero means unlimited.
+	MaxBytesPerSecond int64
+	// Lazy requests the reader to consume only the compressed data
+	// required to satisfy the current Read call. This supports
+	// transports that interleave other data with the xz stream but
+	// reduces the throughput.
+	Lazy bool
+	// Embedded requests the reader to return io.EOF after the
+	// footer of the first stream. The reader doesn't read any data
+	// behind the footer from the underlying reader, so the data
+	// following the stream can be read from the underlying reader.
+	Embedded bool
+	// CheckMinimal requests the reader to return ErrExcessPadding
+	// if the block headers or the streams carry more padding than
+	// required by the format. Stream padding is never required.
+	CheckMinimal bool
+	// MaxInFlight limits the number of blocks decoded concurrently
+	// by DecompressToWriterAt. Each block in flight requires its own
+	// dictionary. Zero selects the value of GOMAXPROCS at the time
+	// Verify is called; DecompressToWriterAt verifies its own copy
+	// of the configuration, so it uses the current value. A
+	// positive value is used as given, independent of GOMAXPROCS.
+	MaxInFlight int
+	// AutoInFlight requests DecompressToWriterAt to derive the
+	// number of blocks decoded concurrently from the block sizes in
+	// the index. The decoding can't be faster than the decoding of
+	// the largest block, so the number is limited to the ratio of
+	// the total uncompressed size to the size of the largest block
+	// and to the number of blocks. MaxInFlight remains the upper
+	// limit.
+	AutoInFlight bool
+	// SkipCorruptBlocks requests the reader to skip the blocks that
+	// cannot be decoded or fail the integrity check and to continue
+	// with the next block; Reader.SkippedBlocks reports the skipped
+	// blocks. The blocks are located using the indexes of the
+	// streams, so the underlying reader must support io.ReaderAt and
+	// io.Seeker and the xz file must start at offset zero. Every
+	// block is decoded twice: the first pass verifies the block, so
+	// that Read returns only verified data. SingleStream, Lazy and
+	// Embedded are ignored.
+	SkipCorruptBlocks bool
+	// WorkerLaunchDelay staggers the start of the goroutines
+	// decoding the blocks in DecompressToWriterAt. The first
+	// MaxInFlight goroutines are started one after another with the
+	// given delay, so the CPU usage ramps up gradually. Zero starts
+	// them at once.
+	WorkerLaunchDelay time.Duration
+	// MaxExpansionRatio limits the ratio of the decompressed bytes
+	// to the compressed bytes consumed. Read returns
+	// ErrExpansionRatio as soon as the ratio is exceeded after the
+	// first 4 KiB of compressed data, which detects decompression
+	// bombs long before the complete output has been produced. Zero
+	// disables the check. It is ignored if SkipCorruptBlocks is set.
+	MaxExpansionRatio float64
+	// MaxDictCap limits the dictionary capacity the LZMA2 filter of
+	// a block may require. The dictionary of the decoder is sized
+	// for each block from its filter properties, so concatenated
+	// streams compressed with different dictionary capacities are
+	// supported. Blocks requiring a larger dictionary are rejected
+	// before any memory is allocated. Zero means no limit.
+	MaxDictCap int
+	// AllowedFilters lists the IDs of the filters the blocks may
+	// use, for instance 0x21 for LZMA2. A block using any other
+	// filter is rejected after its header has been read and before
+	// any data is decoded. An empty list allows all supported
+	// filters.
+	AllowedFilters []uint64
+	// OnBlock is called after each block has been read completely
+	// and its check has been verified, with the uncompressed size
+	// of the block. The call is made from the goroutine calling
+	// Read before Read returns the last data of the block. It is
+	// ignored if SkipCorruptBlocks is set.
+	OnBlock func(uncompressedSize int64)
+	// Follow requests the reader to wait for more data if the
+	// underlying reader returns io.EOF inside the stream, as tail -f
+	// does, which supports reading a file that is still being
+	// written. The underlying reader is polled every 100 ms. Read
+	// returns the data of a block as soon as the block is complete,
+	// so together with OnBlock a consumer can process the data block
+	// by block. Read returns io.EOF after the footer of the first
+	// stream without reading any further data. Read waits forever
+	// if the producer never completes the stream. Follow is ignored
+	// if SkipCorruptBlocks is set.
+	Follow bool
+	// HashWriters are updated with the decompressed data, so that
+	// multiple hashes of the data are computed in one pass. The sums
+	// are complete after Read returned io.EOF.
+	// DecompressToWriterAt feeds the blocks decoded concurrently in
+	// the order of the uncompressed data to the hashes. A block
+	// decoded ahead of the blocks before it buffers up to 1 MiB for
+	// the hashes and then waits, so the memory required is 1 MiB
+	// times MaxInFlight, but the parallelism may be reduced.
+	HashWriters []hash.Hash
+	// SkipChecks requests the reader to skip the verification of
+	// the block checks (CRC-32, CRC-64 or SHA-256), which increases
+	// the decoding throughput. The checks are still read but not
+	// computed, so corrupted data is returned without an error.
+	// Set it only if the integrity of the data is verified
+	// otherwise. The checks of the block headers and the index are
+	// always verified.
+	SkipChecks bool
+	// NoDictReset lets every block of a stream continue the
+	// dictionary of the previous block, which is required to read
+	// the files written with WriterConfig.NoDictReset. The xz format
+	// requires a dictionary reset at the start of each block, so by
+	// default a block without it is rejected as corrupt.
+	NoDictReset bool
+
+	// chain passes the dictionary from block to block in a stream
+	// if NoDictReset is set.
+	chain *dictChain
 }
 
 // Verify checks the reader parameters for Validity. Zero values will be
@@ -36,15 +151,53 @@ func (c *ReaderConfig) Verify() error {
 	if err := lc.Verify(); err != nil {
 		return err
 	}
+	if c.MaxBytesPerSecond < 0 {
+		return errors.New("xz: negative MaxBytesPerSecond")
+	}
+	if c.MaxInFlight == 0 {
+		c.MaxInFlight = runtime.GOMAXPROCS(0)
+	}
+	if c.MaxInFlight < 0 {
+		return errors.New("xz: negative MaxInFlight")
+	}
+	if c.WorkerLaunchDelay < 0 {
+		return errors.New("xz: negative WorkerLaunchDelay")
+	}
+	if !(c.MaxExpansionRatio >= 0) {
+		return errors.New("xz: invalid MaxExpansionRatio")
+	}
+	if c.MaxDictCap < 0 {
+		return errors.New("xz: negative MaxDictCap")
+	}
 	return nil
 }
 
-// Reader supports the reading of one or multiple xz streams.
+// Reader supports the reading of one or multiple xz streams. A Reader
+// is not safe for concurrent use; concurrent calls of Read panic.
 type Reader struct {
 	ReaderConfig
+	guard useGuard
 
 	xz io.Reader
 	sr *streamReader
+	tb *tokenBucket
+	// reader used if SkipCorruptBlocks is set
+	skr *skipReader
+	// data read while checking for a metadata stream
+	pending []byte
+	// metadata stored after the main stream
+	metadata []byte
+	// position tracking for error messages
+	cxz    countingReader
+	stream int
+	// offset behind the last footer read; -1 before the first one
+	footerEnd int64
+	// decompressed bytes returned by Read
+	out int64
+	// combines the HashWriters
+	hw io.Writer
+	// latched ErrExpansionRatio
+	err error
 }
 
 // streamReader decodes a single xz stream
@@ -67,16 +220,36 @@ func NewReader(xz io.Reader) (r *Reader, err error) {
 
 // NewReader creates an xz stream reader. The created reader will be
 // able to process multiple streams and padding unless a SingleStream
-// has been set in the reader configuration c.
+// has been set in the reader configuration c. Further streams are only
+// expected if the underlying reader doesn't return io.EOF after a
+// stream, so a bounded source such as an io.SectionReader delimiting
+// embedded xz data ends cleanly at its bounds.
 func (c ReaderConfig) NewReader(xz io.Reader) (r *Reader, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
 	r = &Reader{
 		ReaderConfig: c,
-		xz:           xz,
+		cxz:          countingReader{r: xz},
+		footerEnd:    -1,
+	}
+	if c.Follow && !c.SkipCorruptBlocks {
+		r.cxz.r = &followReader{r: xz}
+	}
+	r.xz = &r.cxz
+	if len(c.HashWriters) > 0 {
+		r.hw = hashWriter(c.HashWriters)
 	}
-	if r.sr, err = c.newStreamReader(xz); err != nil {
+	if c.MaxBytesPerSecond > 0 {
+		r.tb = newTokenBucket(c.MaxBytesPerSecond)
+	}
+	if c.SkipCorruptBlocks {
+		if r.skr, err = c.newSkipReader(xz); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	if r.sr, err = c.newStreamReader(r.xz); err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
 		}
@@ -85,17 +258,81 @@ func (c ReaderConfig) NewReader(xz io.Reader) (r *Reader, err error) {
 	return r, nil
 }
 
+// hashWriter returns a writer updating all hashes.
+func hashWriter(hashes []hash.Hash) io.Writer {
+	w := make([]io.Writer, len(hashes))
+	for i, h := range hashes {
+		w[i] = h
+	}
+	return io.MultiWriter(w...)
+}
+
 var errUnexpectedData = errors.New("xz: unexpected data after stream")
 
-// Read reads uncompressed data from the stream.
+// ErrExcessPadding indicates that the xz data contains more padding
+// than required. It is only returned if CheckMinimal is set in the
+// reader configuration.
+var ErrExcessPadding = errors.New("xz: excess padding")
+
+// ErrExpansionRatio indicates that the ratio of the decompressed data
+// to the compressed data exceeds the MaxExpansionRatio of the reader
+// configuration.
+var ErrExpansionRatio = errors.New("xz: expansion ratio exceeded")
+
+// minExpansionInput is the number of compressed bytes that must be
+// consumed before the expansion ratio is checked.
+const minExpansionInput = 4096
+
+// Read reads uncompressed data from the stream. If MaxBytesPerSecond
+// is set, Read waits until it may return data and may return fewer
+// bytes than len(p).
 func (r *Reader) Read(p []byte) (n int, err error) {
+	r.guard.enter("Reader.Read")
+	defer r.guard.exit()
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.tb == nil || len(p) == 0 {
+		n, err = r.read(p)
+	} else {
+		k := r.tb.take(len(p))
+		n, err = r.read(p[:k])
+		r.tb.put(k - n)
+	}
+	r.out += int64(n)
+	if r.hw != nil {
+		r.hw.Write(p[:n])
+	}
+	if r.MaxExpansionRatio > 0 && r.skr == nil &&
+		r.cxz.n >= minExpansionInput &&
+		float64(r.out) > r.MaxExpansionRatio*float64(r.cxz.n) {
+		r.err = ErrExpansionRatio
+		return n, r.err
+	}
+	return n, err
+}
+
+// read reads uncompressed data from the stream without rate limit.
+func (r *Reader) read(p []byte) (n int, err error) {
+	if r.skr != nil {
+		return r.skr.Read(p)
+	}
 	for n < len(p) {
+		if len(r.pending) > 0 {
+			k := copy(p[n:], r.pending)
+			r.pending = r.pending[k:]
+			n += k
+			continue
+		}
 		if r.sr == nil {
+			if r.Embedded || r.Follow {
+				return n, io.EOF
+			}
 			if r.SingleStream {
 				data := make([]byte, 1)
 				_, err = io.ReadFull(r.xz, data)
 				if err != io.EOF {
-					return n, errUnexpectedData
+					return n, r.posError(errUnexpectedData)
 				}
 				return n, io.EOF
 			}
@@ -104,24 +341,100 @@ func (r *Reader) Read(p []byte) (n int, err error) {
 				if err != errPadding {
 					break
 				}
+				if r.CheckMinimal {
+					err = ErrExcessPadding
+					break
+				}
 			}
 			if err != nil {
-				return n, err
+				if err == io.EOF {
+					return n, err
+				}
+				return n, r.posError(err)
 			}
+			if err = r.readMetadata(); err != nil {
+				return n, r.posError(err)
+			}
+			continue
 		}
 		k, err := r.sr.Read(p[n:])
 		n += k
 		if err != nil {
 			if err == io.EOF {
-				r.sr = nil
+				r.endStream()
 				continue
 			}
-			return n, err
+			return n, r.posError(err)
+		}
+		if r.Follow {
+			// The stream reader returns at the end of a block.
+			return n, nil
 		}
 	}
 	return n, nil
 }
 
+// endStream records the end of the current stream after its footer has
+// been read.
+func (r *Reader) endStream() {
+	r.sr = nil
+	r.stream++
+	r.footerEnd = r.cxz.n
+}
+
+// CompressedLen returns the number of compressed bytes consumed from
+// the underlying reader up to the footer of the last stream read,
+// including the stream padding between streams. It returns -1 until
+// the first footer has been read. With Embedded set the value is the
+// exact length of the xz stream, since the reader doesn't read data
+// behind the footer, so the caller can advance to the data following
+// the stream. The method doesn't support SkipCorruptBlocks and returns
+// -1 in that case.
+func (r *Reader) CompressedLen() int64 {
+	return r.footerEnd
+}
+
+// DecodeError provides the position of an error in the compressed
+// data. The stream, block and chunk indexes start at zero. The chunk
+// is the LZMA2 chunk of the block; it is -1 if the error didn't occur
+// inside a block. Offset is the number of bytes read from the
+// underlying reader.
+type DecodeError struct {
+	Stream int
+	Block  int
+	Chunk  int
+	Offset int64
+	Err    error
+}
+
+// Error returns the error message including the position.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("xz: decode error at stream %d block %d "+
+		"chunk %d offset %#x: %s", e.Stream, e.Block, e.Chunk, e.Offset,
+		strings.TrimPrefix(e.Err.Error(), "xz: "))
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// posError adds the position of the reader in the compressed data to
+// the error. The errors io.EOF and io.ErrUnexpectedEOF are returned
+// unchanged, so they can still be compared with ==.
+func (r *Reader) posError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return err
+	}
+	e := &DecodeError{Stream: r.stream, Chunk: -1, Offset: r.cxz.n,
+		Err: err}
+	if r.sr != nil {
+		e.Block = len(r.sr.index)
+		if br := r.sr.br; br != nil && br.lr != nil {
+			e.Chunk = br.lr.Chunk()
+		}
+	}
+	return e
+}
+
 var errPadding = errors.New("xz: padding (4 zero bytes) encountered")
 
 // newStreamReader creates a new xz stream reader using the given configuration
@@ -148,6 +461,9 @@ func (c ReaderConfig) newStreamReader(xz io.Reader) (r *streamReader, err error)
 		xz:           xz,
 		index:        make([]record, 0, 4),
 	}
+	if c.NoDictReset {
+		r.chain = new(dictChain)
+	}
 	if err = r.h.UnmarshalBinary(data); err != nil {
 		return nil, err
 	}
@@ -208,9 +524,21 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 					}
 					return n, io.EOF
 				}
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
 				return n, err
 			}
 			xlog.Debugf("block %v", *bh)
+			if r.CheckMinimal {
+				data, err := bh.MarshalBinary()
+				if err != nil {
+					return n, err
+				}
+				if len(data) < hlen {
+					return n, ErrExcessPadding
+				}
+			}
 			r.br, err = r.ReaderConfig.newBlockReader(r.xz, bh,
 				hlen, r.newHash())
 			if err != nil {
@@ -221,8 +549,17 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 		n += k
 		if err != nil {
 			if err == io.EOF {
-				r.index = append(r.index, r.br.record())
+				rec := r.br.record()
+				r.index = append(r.index, rec)
 				r.br = nil
+				if r.OnBlock != nil {
+					r.OnBlock(rec.uncompressedSize)
+				}
+				if r.Follow {
+					// The next block header might not
+					// have been written yet.
+					return n, nil
+				}
 			} else {
 				return n, err
 			}
@@ -231,10 +568,40 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// followPoll is the interval in which a followReader polls the
+// underlying reader.
+const followPoll = 100 * time.Millisecond
+
+// followReader waits for more data if the underlying reader returns
+// io.EOF.
+type followReader struct {
+	r io.Reader
+}
+
+// Read reads data from the underlying reader. It polls the reader
+// until it provides data or returns an error other than io.EOF.
+func (fr *followReader) Read(p []byte) (n int, err error) {
+	for {
+		n, err = fr.r.Read(p)
+		if n > 0 || len(p) == 0 {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+		if err != io.EOF {
+			return n, err
+		}
+		time.Sleep(followPoll)
+	}
+}
+
 // countingReader is a reader that counts the bytes read.
 type countingReader struct {
 	r io.Reader
 	n int64
+	// buffer for ReadByte if r is not an io.ByteReader
+	p [1]byte
 }
 
 // Read reads data from the wrapped reader and adds it to the n field.
@@ -244,6 +611,35 @@ func (lr *countingReader) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// maxConsecutiveEmptyReads is the number of reads returning neither
+// data nor an error tolerated by countingReader.ReadByte.
+const maxConsecutiveEmptyReads = 100
+
+// ReadByte reads a single byte from the wrapped reader. It supports the
+// LZMA2 decoder, which reads the compressed data byte by byte, without
+// calling Read for every byte if the wrapped reader is an
+// io.ByteReader.
+func (lr *countingReader) ReadByte() (c byte, err error) {
+	if br, ok := lr.r.(io.ByteReader); ok {
+		if c, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+		lr.n++
+		return c, nil
+	}
+	// Like bufio, give up after many reads returning no data.
+	for i := 0; i < maxConsecutiveEmptyReads; i++ {
+		n, err := lr.Read(lr.p[:])
+		if n > 0 {
+			return lr.p[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, io.ErrNoProgress
+}
+
 // blockReader supports the reading of a block.
 type blockReader struct {
 	lxz       countingReader
@@ -251,7 +647,10 @@ type blockReader struct {
 	headerLen int
 	n         int64
 	hash      hash.Hash
+	skipCheck bool
 	r         io.Reader
+	// LZMA2 reader of the filter chain
+	lr *lzma.Reader2
 }
 
 // newBlockReader creates a new block reader.
@@ -263,13 +662,17 @@ func (c *ReaderConfig) newBlockReader(xz io.Reader, h *blockHeader,
 		header:    h,
 		headerLen: hlen,
 		hash:      hash,
+		skipCheck: c.SkipChecks,
 	}
 
-	fr, err := c.newFilterReader(&br.lxz, h.filters)
+	// The blocks of a stream may use different filter chains, so
+	// the chain is built from the header of each block.
+	fr, lr, err := c.newFilterReader(&br.lxz, h.filters)
 	if err != nil {
 		return nil, err
 	}
-	if br.hash.Size() != 0 {
+	br.lr = lr
+	if br.hash.Size() != 0 && !br.skipCheck {
 		br.r = io.TeeReader(fr, br.hash)
 	} else {
 		br.r = fr
@@ -333,6 +736,9 @@ func (br *blockReader) Read(p []byte) (n int, err error) {
 	if !allZeros(q[:k]) {
 		return n, errors.New("xz: non-zero block padding")
 	}
+	if br.skipCheck {
+		return n, io.EOF
+	}
 	checkSum := q[k:]
 	computedSum := br.hash.Sum(checkSum[s:])
 	if !bytes.Equal(checkSum, computedSum) {
@@ -341,19 +747,48 @@ func (br *blockReader) Read(p []byte) (n int, err error) {
 	return n, io.EOF
 }
 
+// errFilterNotAllowed indicates a filter that is not listed in
+// ReaderConfig.AllowedFilters.
+var errFilterNotAllowed = errors.New("xz: filter not allowed")
+
+// filterAllowed checks whether the filter ID is allowed by
+// AllowedFilters.
+func (c *ReaderConfig) filterAllowed(id uint64) bool {
+	if len(c.AllowedFilters) == 0 {
+		return true
+	}
+	for _, a := range c.AllowedFilters {
+		if a == id {
+			return true
+		}
+	}
+	return false
+}
+
+// newFilterReader creates the reader for the filter chain f. It returns
+// the LZMA2 reader at the end of the chain as well.
 func (c *ReaderConfig) newFilterReader(r io.Reader, f []filter) (fr io.Reader,
-	err error) {
+	lr *lzma.Reader2, err error) {
 
 	if err = verifyFilters(f); err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	for _, g := range f {
+		if !c.filterAllowed(g.id()) {
+			return nil, nil, fmt.Errorf("%w: %#x",
+				errFilterNotAllowed, g.id())
+		}
 	}
 
 	fr = r
 	for i := len(f) - 1; i >= 0; i-- {
 		fr, err = f[i].reader(fr, c)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if i == len(f)-1 {
+			lr, _ = fr.(*lzma.Reader2)
 		}
 	}
-	return fr, nil
+	return fr, lr, nil
 }
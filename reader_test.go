@@ -5,11 +5,26 @@
 package xz
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 func TestReaderSimple(t *testing.T) {
@@ -50,7 +65,7 @@ func TestReaderSingleStream(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewReader error %s", err)
 	}
-	if _, err = io.Copy(&buf, r); err != errUnexpectedData {
+	if _, err = io.Copy(&buf, r); !errors.Is(err, errUnexpectedData) {
 		t.Fatalf("io.Copy returned %v; want %v", err, errUnexpectedData)
 	}
 }
@@ -135,3 +150,1144 @@ func BenchmarkReader(b *testing.B) {
 		}
 	}
 }
+
+func TestReaderMaxBytesPerSecond(t *testing.T) {
+	const (
+		rate = 400 * 1024
+		size = 200 * 1024
+	)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(make([]byte, size)); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := ReaderConfig{MaxBytesPerSecond: rate}.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	d := time.Since(start)
+	if n != size {
+		t.Fatalf("read %d bytes; want %d", n, size)
+	}
+	// The first burst of rate/10 bytes is returned immediately.
+	want := time.Duration(float64(size-rate/10) / rate * float64(time.Second))
+	t.Logf("duration %s; expected %s", d, want)
+	if d < want*9/10 || d > want*2 {
+		t.Fatalf("reading took %s; want about %s", d, want)
+	}
+}
+
+func TestReaderLazy(t *testing.T) {
+	const size = 256 * 1024
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	consumed := func(lazy bool) int64 {
+		cr := &countingReader{r: bytes.NewReader(buf.Bytes())}
+		r, err := ReaderConfig{Lazy: lazy}.NewReader(cr)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		p := make([]byte, 16)
+		if _, err = io.ReadFull(r, p); err != nil {
+			t.Fatalf("io.ReadFull error %s", err)
+		}
+		if !bytes.Equal(p, data[:len(p)]) {
+			t.Fatalf("read %x; want %x", p, data[:len(p)])
+		}
+		return cr.n
+	}
+	greedy, lazy := consumed(false), consumed(true)
+	t.Logf("greedy %d bytes; lazy %d bytes", greedy, lazy)
+	if lazy >= greedy {
+		t.Fatalf("lazy reader consumed %d bytes; greedy reader %d",
+			lazy, greedy)
+	}
+
+	r, err := ReaderConfig{Lazy: true}.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("lazy reader returned wrong data")
+	}
+}
+
+// stallReader returns no data and no error for every other read.
+type stallReader struct {
+	r     io.Reader
+	stall bool
+}
+
+func (s *stallReader) Read(p []byte) (n int, err error) {
+	s.stall = !s.stall
+	if s.stall {
+		return 0, nil
+	}
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return s.r.Read(p)
+}
+
+func TestCountingReaderEmptyReads(t *testing.T) {
+	data := []byte("abc")
+	cr := &countingReader{r: &stallReader{r: bytes.NewReader(data)}}
+	for i, b := range data {
+		c, err := cr.ReadByte()
+		if err != nil {
+			t.Fatalf("ReadByte %d error %s", i, err)
+		}
+		if c != b {
+			t.Fatalf("ReadByte %d returned %q; want %q", i, c, b)
+		}
+	}
+	if _, err := cr.ReadByte(); err != io.EOF {
+		t.Fatalf("ReadByte at end returned %v; want %v", err, io.EOF)
+	}
+
+	cr = &countingReader{r: emptyReader{}}
+	if _, err := cr.ReadByte(); err != io.ErrNoProgress {
+		t.Fatalf("ReadByte returned %v; want %v", err, io.ErrNoProgress)
+	}
+}
+
+// emptyReader never returns any data or error.
+type emptyReader struct{}
+
+func (emptyReader) Read(p []byte) (n int, err error) { return 0, nil }
+
+func TestReaderErrorPosition(t *testing.T) {
+	const blockSize = 16 * 1024
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(11)), 100000)
+	xz := compressBlocks(t, txt.Bytes(), blockSize)
+	blocks, err := readIndex(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("readIndex error %s", err)
+	}
+	b := blocks[2]
+	pos := b.offset + b.rec.unpaddedSize/2
+	xz[pos] ^= 0xff
+
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	_, err = io.Copy(ioutil.Discard, r)
+	if err == nil {
+		t.Fatalf("io.Copy succeeded on corrupt data")
+	}
+	t.Logf("error %s", err)
+	var e *DecodeError
+	if !errors.As(err, &e) {
+		t.Fatalf("error %v is not a *DecodeError", err)
+	}
+	if e.Stream != 0 || e.Block != 2 {
+		t.Fatalf("error at stream %d block %d; want stream 0 block 2",
+			e.Stream, e.Block)
+	}
+	if e.Chunk < 0 {
+		t.Fatalf("error at chunk %d; want chunk inside block", e.Chunk)
+	}
+	if !(pos < e.Offset && e.Offset <= blocks[3].offset) {
+		t.Fatalf("error offset %#x outside of (%#x,%#x]", e.Offset, pos,
+			blocks[3].offset)
+	}
+	if e.Err == nil || errors.Unwrap(err) != e.Err {
+		t.Fatalf("error doesn't wrap the cause")
+	}
+	if strings.Contains(err.Error(), "xz: xz:") {
+		t.Fatalf("error message %q repeats prefix", err)
+	}
+
+	// Truncated data must return the io.ErrUnexpectedEOF itself.
+	r, err = NewReader(bytes.NewReader(xz[:pos]))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("io.Copy returned %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+}
+
+// paddedHeaderStream creates an xz stream for data whose block header
+// carries four bytes of unnecessary padding.
+func paddedHeaderStream(t *testing.T, data []byte) []byte {
+	const dictCap = 1 << 20
+	var lz bytes.Buffer
+	w, err := lzma.Writer2Config{DictCap: dictCap}.NewWriter2(&lz)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+
+	var buf bytes.Buffer
+	h := header{flags: None}
+	p, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("h.MarshalBinary error %s", err)
+	}
+	buf.Write(p)
+	bh := blockHeader{
+		compressedSize:   -1,
+		uncompressedSize: -1,
+		filters:          []filter{&lzmaFilter{dictCap}},
+	}
+	if p, err = bh.MarshalBinary(); err != nil {
+		t.Fatalf("bh.MarshalBinary error %s", err)
+	}
+	k := len(p) - 4
+	p = append(p[:k:k], 0, 0, 0, 0, 0, 0, 0, 0)
+	p[0]++
+	putUint32LE(p[len(p)-4:], crc32.ChecksumIEEE(p[:len(p)-4]))
+	buf.Write(p)
+	buf.Write(lz.Bytes())
+	buf.Write(make([]byte, padLen(int64(lz.Len()))))
+	index := []record{{int64(len(p) + lz.Len()), int64(len(data))}}
+	f := footer{flags: None}
+	if f.indexSize, err = writeIndex(&buf, index); err != nil {
+		t.Fatalf("writeIndex error %s", err)
+	}
+	if p, err = f.MarshalBinary(); err != nil {
+		t.Fatalf("f.MarshalBinary error %s", err)
+	}
+	buf.Write(p)
+	return buf.Bytes()
+}
+
+func TestReaderCheckMinimal(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	streamPadded := append(buf.Bytes(), 0, 0, 0, 0)
+
+	tests := []struct {
+		name string
+		xz   []byte
+		err  error
+	}{
+		{"minimal", buf.Bytes(), nil},
+		{"stream padding", streamPadded, ErrExcessPadding},
+		{"block header padding",
+			paddedHeaderStream(t, []byte(text)), ErrExcessPadding},
+	}
+	for _, tc := range tests {
+		// The files are valid without the check.
+		r, err := NewReader(bytes.NewReader(tc.xz))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.name, err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ioutil.ReadAll error %s", tc.name, err)
+		}
+		if string(out) != text {
+			t.Fatalf("%s: got %q; want %q", tc.name, out, text)
+		}
+
+		r, err = ReaderConfig{CheckMinimal: true}.NewReader(
+			bytes.NewReader(tc.xz))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", tc.name, err)
+		}
+		_, err = ioutil.ReadAll(r)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%s: ioutil.ReadAll returned %v; want %v",
+				tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestReaderEmbedded(t *testing.T) {
+	const sentinel = "SENTINEL"
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(31)), 50000)
+	text := txt.String()
+	buf := bytes.NewBuffer(compressBlocks(t, txt.Bytes(), 16*1024))
+	buf.WriteString(sentinel)
+
+	for _, lazy := range []bool{false, true} {
+		// hide the io.ByteReader interface of bytes.Reader
+		src := struct{ io.Reader }{bytes.NewReader(buf.Bytes())}
+		r, err := ReaderConfig{Embedded: true, Lazy: lazy}.NewReader(
+			src)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll error %s", err)
+		}
+		if string(out) != text {
+			t.Fatalf("lazy %t: decompressed data differs", lazy)
+		}
+		rest, err := ioutil.ReadAll(src)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll error %s", err)
+		}
+		if string(rest) != sentinel {
+			t.Fatalf("lazy %t: data after stream is %q; want %q",
+				lazy, rest, sentinel)
+		}
+	}
+}
+
+func TestReaderCompressedLen(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(32)), 50000)
+	xz := compressBlocks(t, txt.Bytes(), 16*1024)
+	var frame bytes.Buffer
+	frame.WriteString("BEGIN")
+	frame.Write(xz)
+	frame.WriteString("END")
+
+	for _, lazy := range []bool{false, true} {
+		src := struct{ io.Reader }{bytes.NewReader(frame.Bytes())}
+		marker := make([]byte, len("BEGIN"))
+		if _, err := io.ReadFull(src, marker); err != nil {
+			t.Fatalf("io.ReadFull error %s", err)
+		}
+		r, err := ReaderConfig{Embedded: true, Lazy: lazy}.NewReader(
+			src)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		if n := r.CompressedLen(); n != -1 {
+			t.Fatalf("CompressedLen before footer is %d; want -1", n)
+		}
+		if _, err = io.Copy(ioutil.Discard, r); err != nil {
+			t.Fatalf("io.Copy error %s", err)
+		}
+		n := r.CompressedLen()
+		want := int64(bytes.Index(frame.Bytes(), []byte("END")) -
+			len("BEGIN"))
+		if n != want {
+			t.Fatalf("lazy %t: CompressedLen %d; want %d", lazy, n,
+				want)
+		}
+		rest, err := ioutil.ReadAll(src)
+		if err != nil {
+			t.Fatalf("ioutil.ReadAll error %s", err)
+		}
+		if string(rest) != "END" {
+			t.Fatalf("lazy %t: data after stream is %q; want %q",
+				lazy, rest, "END")
+		}
+	}
+
+	// The metadata stream is included.
+	var buf bytes.Buffer
+	w, err := WriterConfig{Metadata: []byte("meta")}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if n := r.CompressedLen(); n != int64(buf.Len()) {
+		t.Fatalf("CompressedLen %d; want %d", n, buf.Len())
+	}
+}
+
+// zeroReader provides an endless sequence of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (n int, err error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestReaderMaxExpansionRatio(t *testing.T) {
+	const bombLen = 64 << 20
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.CopyN(w, zeroReader{}, bombLen); err != nil {
+		t.Fatalf("io.CopyN error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	bomb := buf.Bytes()
+	t.Logf("%d zero bytes compressed to %d bytes", bombLen, len(bomb))
+
+	cfg := ReaderConfig{MaxExpansionRatio: 1000}
+	r, err := cfg.NewReader(bytes.NewReader(bomb))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != ErrExpansionRatio {
+		t.Fatalf("io.Copy returned %v; want %v", err,
+			ErrExpansionRatio)
+	}
+	t.Logf("stopped after %d bytes", n)
+	if n >= bombLen/2 {
+		t.Fatalf("bomb detected after %d bytes; want less than %d",
+			n, bombLen/2)
+	}
+	if _, err = r.Read(make([]byte, 1)); err != ErrExpansionRatio {
+		t.Fatalf("Read after error returned %v; want %v", err,
+			ErrExpansionRatio)
+	}
+
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(76)), 200000)
+	xz := compressBlocks(t, txt.Bytes(), 64*1024)
+	if r, err = cfg.NewReader(bytes.NewReader(xz)); err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, txt.Bytes()) {
+		t.Fatal("decompressed data differs from original")
+	}
+}
+
+// linesXZ returns compressed newline-delimited text and the number of
+// lines.
+func linesXZ(tb testing.TB, size int64) (xz []byte, lines int) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewGroupReader(
+		randtxt.NewReader(rand.NewSource(41))), size)
+	txt.WriteByte('\n')
+	lines = bytes.Count(txt.Bytes(), []byte{'\n'})
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		tb.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		tb.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		tb.Fatalf("w.Close error %s", err)
+	}
+	return buf.Bytes(), lines
+}
+
+func BenchmarkReaderScanLines(b *testing.B) {
+	xz, lines := linesXZ(b, 1<<20)
+	b.SetBytes(1 << 20)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r, err := NewReader(bytes.NewReader(xz))
+		if err != nil {
+			b.Fatalf("NewReader error %s", err)
+		}
+		s := bufio.NewScanner(r)
+		n := 0
+		for s.Scan() {
+			n++
+		}
+		if err = s.Err(); err != nil {
+			b.Fatalf("Scan error %s", err)
+		}
+		if n != lines {
+			b.Fatalf("scanned %d lines; want %d", n, lines)
+		}
+	}
+}
+
+func TestReaderConcurrentRead(t *testing.T) {
+	xz, err := ioutil.ReadFile("fox.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write(xz[:HeaderLen])
+	}()
+	r, err := NewReader(pr)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+
+	// The first Read blocks waiting for the block header.
+	done := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(r)
+		done <- err
+	}()
+	for atomic.LoadInt32(&r.guard.busy) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("concurrent Read didn't panic")
+			}
+		}()
+		r.Read(make([]byte, 10))
+	}()
+
+	pw.Write(xz[HeaderLen:])
+	pw.Close()
+	if err = <-done; err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+}
+
+// xorFilter is a non-last test filter that inverts every second bit.
+type xorFilter struct {
+	testFilter
+}
+
+type xorReader struct{ r io.Reader }
+
+func (r xorReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0xaa
+	}
+	return n, err
+}
+
+type xorWriteCloser struct{ w io.WriteCloser }
+
+func (w xorWriteCloser) Write(p []byte) (n int, err error) {
+	q := make([]byte, len(p))
+	for i, b := range p {
+		q[i] = b ^ 0xaa
+	}
+	return w.w.Write(q)
+}
+
+func (w xorWriteCloser) Close() error { return w.w.Close() }
+
+func (f xorFilter) reader(r io.Reader, c *ReaderConfig) (io.Reader, error) {
+	return xorReader{r}, nil
+}
+
+func (f xorFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
+) (io.WriteCloser, error) {
+	return xorWriteCloser{w}, nil
+}
+
+func TestReaderBlockFilterChains(t *testing.T) {
+	const xorFilterID = 0x04
+	newFilter := newFilterFunc
+	defer func() { newFilterFunc = newFilter }()
+	newFilterFunc = func(id uint64) filter {
+		if id == xorFilterID {
+			return xorFilter{testFilter{xorFilterID}}
+		}
+		return newFilter(id)
+	}
+
+	c := WriterConfig{}
+	if err := c.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	var buf bytes.Buffer
+	h := header{flags: CRC64}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	buf.Write(data)
+	newHash, err := newHashFunc(h.flags)
+	if err != nil {
+		t.Fatalf("newHashFunc error %s", err)
+	}
+	chains := [][]filter{
+		{xorFilter{testFilter{xorFilterID}}, &lzmaFilter{int64(c.DictCap)}},
+		{&lzmaFilter{int64(c.DictCap)}},
+	}
+	var txt bytes.Buffer
+	var index []record
+	for i, filters := range chains {
+		var p bytes.Buffer
+		io.CopyN(&p, randtxt.NewReader(rand.NewSource(int64(i))), 10000)
+		txt.Write(p.Bytes())
+		bw := &blockWriter{
+			cxz:       countingWriter{w: &buf},
+			blockSize: maxInt64,
+			filters:   filters,
+			hash:      newHash(),
+		}
+		bw.w, err = c.newFilterWriteCloser(&bw.cxz, bw.filters)
+		if err != nil {
+			t.Fatalf("newFilterWriteCloser error %s", err)
+		}
+		bw.mw = io.MultiWriter(bw.w, bw.hash)
+		if err = bw.writeHeader(&buf); err != nil {
+			t.Fatalf("writeHeader error %s", err)
+		}
+		if _, err = bw.Write(p.Bytes()); err != nil {
+			t.Fatalf("bw.Write error %s", err)
+		}
+		if err = bw.Close(); err != nil {
+			t.Fatalf("bw.Close error %s", err)
+		}
+		index = append(index, bw.record())
+	}
+	f := footer{flags: h.flags}
+	if f.indexSize, err = writeIndex(&buf, index); err != nil {
+		t.Fatalf("writeIndex error %s", err)
+	}
+	if data, err = f.MarshalBinary(); err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	buf.Write(data)
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, txt.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
+
+func TestReaderDictCapPerStream(t *testing.T) {
+	// The second stream repeats data at a distance larger than the
+	// dictionary of the first stream.
+	rnd := rand.New(rand.NewSource(11))
+	part := make([]byte, 1<<20+1<<18)
+	rnd.Read(part)
+	data := append(append([]byte(nil), part...), part...)
+	var xz []byte
+	for _, dictCap := range []int{1 << 20, 8 << 20} {
+		p, err := compressData(data, WriterConfig{DictCap: dictCap})
+		if err != nil {
+			t.Fatalf("compressData error %s", err)
+		}
+		xz = append(xz, p...)
+	}
+	if n := len(xz) - len(data); n > len(data)*3/4 {
+		t.Fatalf("second stream doesn't refer to the repeated data")
+	}
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, append(data, data...)) {
+		t.Fatalf("decompressed data differs from original")
+	}
+
+	r, err = ReaderConfig{MaxDictCap: 4 << 20}.NewReader(
+		bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err = ioutil.ReadAll(r)
+	if !errors.Is(err, errMaxDictCap) {
+		t.Fatalf("ReadAll returned %v; want %v", err, errMaxDictCap)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("first stream not decompressed")
+	}
+}
+
+// growingBuffer is a buffer that is safe for concurrent use. Read
+// returns io.EOF if no data is available.
+type growingBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *growingBuffer) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *growingBuffer) Read(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Read(p)
+}
+
+func TestReaderFollowOnBlock(t *testing.T) {
+	const blocks = 3
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(13)), 30000)
+	data := txt.Bytes()
+	blockLen := len(data) / blocks
+
+	var gb growingBuffer
+	sizes := make(chan int64, blocks)
+	result := make(chan error, 1)
+	var out []byte
+	go func() {
+		r, err := ReaderConfig{
+			Follow: true,
+			OnBlock: func(n int64) {
+				sizes <- n
+			},
+		}.NewReader(&gb)
+		if err != nil {
+			result <- err
+			return
+		}
+		out, err = ioutil.ReadAll(r)
+		result <- err
+	}()
+
+	w, err := NewWriter(&gb)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	for i := 0; i < blocks; i++ {
+		_, err = w.Write(data[i*blockLen : (i+1)*blockLen])
+		if err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.NextBlock(); err != nil {
+			t.Fatalf("w.NextBlock error %s", err)
+		}
+		// The block must be reported before the stream is
+		// complete.
+		select {
+		case n := <-sizes:
+			if n != int64(blockLen) {
+				t.Fatalf("block %d: OnBlock got %d; want %d",
+					i, n, blockLen)
+			}
+		case err = <-result:
+			t.Fatalf("reader finished early with %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("block %d not reported", i)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	select {
+	case err = <-result:
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("reader didn't finish")
+	}
+	if !bytes.Equal(out, data[:blocks*blockLen]) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
+
+func TestReaderSectionReader(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(19)), 20000)
+	data := txt.Bytes()
+	xz, err := compressData(data, WriterConfig{})
+	if err != nil {
+		t.Fatalf("compressData error %s", err)
+	}
+	// The data around the section looks like another stream and
+	// must not be read.
+	file := append(append(append([]byte(nil), xz...), xz...), xz...)
+	for _, c := range []ReaderConfig{
+		{},
+		{SingleStream: true},
+		{Lazy: true},
+		{SkipCorruptBlocks: true},
+	} {
+		sr := io.NewSectionReader(bytes.NewReader(file),
+			int64(len(xz)), int64(len(xz)))
+		r, err := c.NewReader(sr)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%+v: ReadAll error %s", c, err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("%+v: decompressed data differs from original",
+				c)
+		}
+	}
+}
+
+func TestReaderDictWindow(t *testing.T) {
+	// The data repeats at a distance beyond 4 KiB.
+	part := make([]byte, 8192)
+	rand.New(rand.NewSource(29)).Read(part)
+	data := append(append([]byte(nil), part...), part...)
+	xz, err := compressData(data, WriterConfig{DictCap: 1 << 20})
+	if err != nil {
+		t.Fatalf("compressData error %s", err)
+	}
+	// Declare a dictionary capacity of 4 KiB in the LZMA2 filter
+	// property of the block header.
+	bh := xz[HeaderLen:]
+	hlen := (int(bh[0]) + 1) * 4
+	if bh[1] != 0 || bh[2] != lzmaFilterID || bh[3] != 1 {
+		t.Fatalf("unexpected block header % x", bh[:hlen])
+	}
+	bh[4] = 0
+	putUint32LE(bh[hlen-4:], crc32.ChecksumIEEE(bh[:hlen-4]))
+
+	for _, c := range []ReaderConfig{{}, {DictCap: 1 << 20}} {
+		r, err := c.NewReader(bytes.NewReader(xz))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		_, err = ioutil.ReadAll(r)
+		if err == nil {
+			t.Fatalf("DictCap %d: over-distance match not detected",
+				c.DictCap)
+		}
+		if c.DictCap > 0 && !errors.Is(err, lzma.ErrCorrupt) {
+			t.Fatalf("ReadAll returned %v; want %v", err,
+				lzma.ErrCorrupt)
+		}
+	}
+}
+
+func TestReaderAllowedFilters(t *testing.T) {
+	newFilter := newFilterFunc
+	defer func() { newFilterFunc = newFilter }()
+	newFilterFunc = func(id uint64) filter {
+		if id == 0x03 {
+			return testFilter{0x03}
+		}
+		return newFilter(id)
+	}
+
+	const text = "The quick brown fox jumps over the lazy dog.\n"
+	plain, err := compressData([]byte(text), WriterConfig{})
+	if err != nil {
+		t.Fatalf("compressData error %s", err)
+	}
+	// Replace the block header by one with a delta filter in front
+	// of the LZMA2 filter.
+	hlen := (int(plain[HeaderLen]) + 1) * 4
+	var bh blockHeader
+	err = bh.UnmarshalBinary(plain[HeaderLen : HeaderLen+hlen])
+	if err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	bh.filters = append([]filter{testFilter{0x03}}, bh.filters...)
+	data, err := bh.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	delta := append(append(append([]byte(nil), plain[:HeaderLen]...),
+		data...), plain[HeaderLen+hlen:]...)
+
+	cfg := ReaderConfig{AllowedFilters: []uint64{lzmaFilterID}}
+	r, err := cfg.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != text {
+		t.Fatalf("got %q; want %q", out, text)
+	}
+
+	r, err = cfg.NewReader(bytes.NewReader(delta))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err = ioutil.ReadAll(r)
+	if !errors.Is(err, errFilterNotAllowed) {
+		t.Fatalf("ReadAll returned %v; want %v", err,
+			errFilterNotAllowed)
+	}
+	if len(out) > 0 {
+		t.Fatalf("ReadAll returned %d bytes of a rejected block",
+			len(out))
+	}
+}
+
+func TestReaderSkipChecks(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(13)), 50000)
+	xz, err := compressData(txt.Bytes(), WriterConfig{CheckSum: SHA256})
+	if err != nil {
+		t.Fatalf("compressData error %s", err)
+	}
+	blocks, err := readIndex(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("readIndex error %s", err)
+	}
+	// corrupt the SHA-256 check of the first block
+	b := blocks[0]
+	xz[b.offset+b.rec.unpaddedSize-1] ^= 0xff
+
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err == nil {
+		t.Fatalf("corrupt check not detected")
+	}
+
+	// The corrupted check is ignored with SkipChecks. This is
+	// the footgun of the option.
+	r, err = ReaderConfig{SkipChecks: true}.NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll with SkipChecks error %s", err)
+	}
+	if !bytes.Equal(out, txt.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
+
+func BenchmarkReaderSkipChecks(b *testing.B) {
+	const testFile = "testdata/enwik7"
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		b.Fatalf("os.ReadFile(%q) error %s", testFile, err)
+	}
+	xz, err := compressData(data, WriterConfig{CheckSum: SHA256})
+	if err != nil {
+		b.Fatalf("compressData error %s", err)
+	}
+	for _, skip := range []bool{false, true} {
+		b.Run(fmt.Sprintf("SkipChecks=%t", skip), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			cfg := ReaderConfig{SkipChecks: skip}
+			for i := 0; i < b.N; i++ {
+				r, err := cfg.NewReader(bytes.NewReader(xz))
+				if err != nil {
+					b.Fatalf("NewReader error %s", err)
+				}
+				n, err := io.Copy(ioutil.Discard, r)
+				if err != nil {
+					b.Fatalf("io.Copy error %s", err)
+				}
+				if n != int64(len(data)) {
+					b.Fatalf("decompressed %d bytes; want %d",
+						n, len(data))
+				}
+			}
+		})
+	}
+}
+
+func TestReaderHashWriters(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(17)), 100000)
+	xz := compressBlocks(t, txt.Bytes(), 7000)
+	wantMD5 := md5.Sum(txt.Bytes())
+	wantSHA := sha256.Sum256(txt.Bytes())
+
+	hashes := []hash.Hash{md5.New(), sha256.New()}
+	r, err := ReaderConfig{HashWriters: hashes}.NewReader(
+		bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if !bytes.Equal(hashes[0].Sum(nil), wantMD5[:]) {
+		t.Fatalf("Reader: MD5 differs from reference")
+	}
+	if !bytes.Equal(hashes[1].Sum(nil), wantSHA[:]) {
+		t.Fatalf("Reader: SHA-256 differs from reference")
+	}
+
+	// A small limit for the data buffered by a block lets the
+	// blocks wait for their turn.
+	defer func(n int) { maxHashPending = n }(maxHashPending)
+	for _, tc := range []struct{ maxInFlight, pending int }{
+		{1, 1 << 20}, {4, 1 << 20}, {4, 100},
+	} {
+		maxInFlight := tc.maxInFlight
+		maxHashPending = tc.pending
+		hashes = []hash.Hash{md5.New(), sha256.New()}
+		cfg := ReaderConfig{HashWriters: hashes,
+			MaxInFlight: maxInFlight}
+		out := make(sliceWriterAt, txt.Len())
+		err = DecompressToWriterAt(out, bytes.NewReader(xz),
+			int64(len(xz)), cfg)
+		if err != nil {
+			t.Fatalf("DecompressToWriterAt error %s", err)
+		}
+		if !bytes.Equal(hashes[0].Sum(nil), wantMD5[:]) {
+			t.Fatalf("MaxInFlight %d: MD5 differs from reference",
+				maxInFlight)
+		}
+		if !bytes.Equal(hashes[1].Sum(nil), wantSHA[:]) {
+			t.Fatalf("MaxInFlight %d: SHA-256 differs from reference",
+				maxInFlight)
+		}
+	}
+}
+
+// xzFromLZMA2 creates an xz stream with a block for each LZMA2 stream.
+// The uncompressed data of the blocks is required for the checks.
+func xzFromLZMA2(t *testing.T, streams, data [][]byte) []byte {
+	var buf bytes.Buffer
+	h := header{flags: CRC32}
+	p, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	buf.Write(p)
+	var index []record
+	newHash, err := newHashFunc(CRC32)
+	if err != nil {
+		t.Fatalf("newHashFunc error %s", err)
+	}
+	for i, s := range streams {
+		bh := blockHeader{
+			compressedSize:   -1,
+			uncompressedSize: -1,
+			filters:          []filter{&lzmaFilter{1 << 20}},
+		}
+		p, err := bh.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary error %s", err)
+		}
+		buf.Write(p)
+		buf.Write(s)
+		buf.Write(make([]byte, padLen(int64(len(s)))))
+		h := newHash()
+		h.Write(data[i])
+		buf.Write(h.Sum(nil))
+		index = append(index, record{
+			unpaddedSize:     int64(len(p)+len(s)) + 4,
+			uncompressedSize: int64(len(data[i])),
+		})
+	}
+	f := footer{flags: CRC32}
+	if f.indexSize, err = writeIndex(&buf, index); err != nil {
+		t.Fatalf("writeIndex error %s", err)
+	}
+	if p, err = f.MarshalBinary(); err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	buf.Write(p)
+	return buf.Bytes()
+}
+
+func TestReaderLeadingResetChunk(t *testing.T) {
+	var streams, data [][]byte
+	var all []byte
+	for i := 0; i < 3; i++ {
+		// incompressible data is written as uncompressed chunk
+		// resetting the dictionary
+		var txt bytes.Buffer
+		io.CopyN(&txt, rand.New(rand.NewSource(int64(i))), 500)
+		head := txt.Len()
+		io.CopyN(&txt, randtxt.NewReader(rand.NewSource(int64(i))),
+			30000)
+		var buf bytes.Buffer
+		w, err := lzma.Writer2Config{DictCap: 1 << 20}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		w.Write(txt.Bytes()[:head])
+		if err = w.Flush(); err != nil {
+			t.Fatalf("w.Flush error %s", err)
+		}
+		w.Write(txt.Bytes()[head:])
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		if buf.Bytes()[0] != 1 {
+			t.Fatalf("LZMA2 stream starts with %#02x; want %#02x",
+				buf.Bytes()[0], 1)
+		}
+		streams = append(streams, buf.Bytes())
+		data = append(data, txt.Bytes())
+		all = append(all, txt.Bytes()...)
+	}
+	xz := xzFromLZMA2(t, streams, data)
+
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, all) {
+		t.Fatal("Reader: decompressed data differs from original")
+	}
+
+	wa := make(sliceWriterAt, len(all))
+	err = DecompressToWriterAt(wa, bytes.NewReader(xz), int64(len(xz)),
+		ReaderConfig{MaxInFlight: 3})
+	if err != nil {
+		t.Fatalf("DecompressToWriterAt error %s", err)
+	}
+	if !bytes.Equal(wa, all) {
+		t.Fatal("DecompressToWriterAt: decompressed data differs " +
+			"from original")
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "io"
+
+// Recompress decodes the xz data from src and encodes it again to dst
+// using the writer configuration cfg. Since blocks with separate
+// dictionaries cannot be combined at the compressed level, this is
+// the way to change the block layout, for instance to merge many
+// small blocks into a single block, or any other parameter of the
+// compression. All streams of src are written into a single stream.
+// If cfg.Metadata is empty, the metadata of src is kept.
+func Recompress(dst io.Writer, src io.Reader, cfg WriterConfig) error {
+	r, err := NewReader(src)
+	if err != nil {
+		return err
+	}
+	w, err := cfg.NewWriter(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
+	if len(w.Metadata) == 0 {
+		w.Metadata = r.Metadata()
+	}
+	return w.Close()
+}
@@ -0,0 +1,72 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestRecompress(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(77)), 100000)
+	orig := txt.Bytes()
+
+	var src bytes.Buffer
+	w, err := WriterConfig{
+		BlockSize: 2000,
+		Metadata:  []byte("meta"),
+	}.NewWriter(&src)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(orig); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+
+	var dst bytes.Buffer
+	if err = Recompress(&dst, bytes.NewReader(src.Bytes()),
+		WriterConfig{}); err != nil {
+		t.Fatalf("Recompress error %s", err)
+	}
+	t.Logf("%d bytes recompressed to %d bytes", src.Len(), dst.Len())
+	if dst.Len() >= src.Len() {
+		t.Fatalf("single block file has %d bytes; want less than %d",
+			dst.Len(), src.Len())
+	}
+
+	xz := dst.Bytes()
+	blocks, err := readIndex(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("readIndex error %s", err)
+	}
+	// one data block and one block of the metadata stream
+	if len(blocks) != 2 {
+		t.Fatalf("recompressed file has %d blocks; want %d",
+			len(blocks), 2)
+	}
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, orig) {
+		t.Fatal("decompressed data differs from original")
+	}
+	if string(r.Metadata()) != "meta" {
+		t.Fatalf("metadata %q; want %q", r.Metadata(), "meta")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"errors"
+	"io"
+)
+
+// RecordReader decompresses an xz stream and pads the decompressed data
+// to a multiple of the record size. It supports consumers requiring
+// fixed-size records like tape formats.
+type RecordReader struct {
+	r          io.Reader
+	recordSize int
+	pad        byte
+	// number of bytes returned modulo the record size
+	off int
+	eof bool
+}
+
+// NewRecordReader creates a reader decompressing the xz stream r using
+// the default reader parameters. At the end of the decompressed data
+// the reader provides pad bytes up to the next multiple of recordSize.
+func NewRecordReader(r io.Reader, recordSize int, pad byte,
+) (rr *RecordReader, err error) {
+	if recordSize <= 0 {
+		return nil, errors.New("xz: record size must be positive")
+	}
+	xr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	rr = &RecordReader{r: xr, recordSize: recordSize, pad: pad}
+	return rr, nil
+}
+
+// Read reads decompressed data followed by the padding of the last
+// record.
+func (rr *RecordReader) Read(p []byte) (n int, err error) {
+	if !rr.eof {
+		n, err = rr.r.Read(p)
+		rr.off = (rr.off + n) % rr.recordSize
+		if err != io.EOF {
+			return n, err
+		}
+		rr.eof = true
+	}
+	for n < len(p) && rr.off != 0 {
+		p[n] = rr.pad
+		n++
+		rr.off = (rr.off + 1) % rr.recordSize
+	}
+	if rr.off == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRecordReader(t *testing.T) {
+	const recordSize = 512
+	for _, n := range []int{0, 1, 511, 512, 513, 2000} {
+		orig := bytes.Repeat([]byte{'a'}, n)
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(orig); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		r, err := NewRecordReader(&buf, recordSize, 0)
+		if err != nil {
+			t.Fatalf("NewRecordReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if len(out)%recordSize != 0 {
+			t.Fatalf("output length %d is not a multiple of %d",
+				len(out), recordSize)
+		}
+		if !bytes.Equal(out[:n], orig) {
+			t.Fatalf("output doesn't start with the original data")
+		}
+		for _, c := range out[n:] {
+			if c != 0 {
+				t.Fatalf("padding byte %#02x; want 0", c)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// errSkipNotSeekable indicates that SkipCorruptBlocks has been set for
+// a reader that doesn't support random access.
+var errSkipNotSeekable = errors.New(
+	"xz: SkipCorruptBlocks requires an io.ReaderAt and io.Seeker")
+
+// skipReader reads the blocks of an xz file located by the index and
+// skips the blocks that cannot be decoded or fail the integrity check.
+type skipReader struct {
+	cfg    ReaderConfig
+	ra     io.ReaderAt
+	blocks []blockInfo
+	// index of the next block
+	i int
+	// reader for the current block
+	br io.Reader
+	// indexes of the skipped blocks
+	skipped []int
+}
+
+// newSkipReader reads the index of the xz file provided by xz.
+func (c ReaderConfig) newSkipReader(xz io.Reader) (r *skipReader, err error) {
+	ra, ok := xz.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return nil, errSkipNotSeekable
+	}
+	size, err := ra.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := readIndex(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	return &skipReader{cfg: c, ra: ra, blocks: blocks}, nil
+}
+
+// verifyBlock decodes the block and checks its integrity without
+// returning the data.
+func (r *skipReader) verifyBlock(b blockInfo) error {
+	br, err := r.cfg.openBlock(r.ra, b)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(ioutil.Discard, br); err != nil {
+		return err
+	}
+	if rec := br.record(); rec != b.rec {
+		return fmt.Errorf("xz: block record is %v; want %v",
+			rec, b.rec)
+	}
+	return nil
+}
+
+// nextBlock opens the next block that passes verification. It returns
+// io.EOF if there are no more blocks.
+func (r *skipReader) nextBlock() error {
+	for r.i < len(r.blocks) {
+		b := r.blocks[r.i]
+		r.i++
+		if err := r.verifyBlock(b); err != nil {
+			r.skipped = append(r.skipped, r.i-1)
+			continue
+		}
+		br, err := r.cfg.openBlock(r.ra, b)
+		if err != nil {
+			return err
+		}
+		r.br = br
+		return nil
+	}
+	return io.EOF
+}
+
+// Read reads the data of the verified blocks.
+func (r *skipReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if r.br == nil {
+			if err = r.nextBlock(); err != nil {
+				return n, err
+			}
+		}
+		k, err := r.br.Read(p[n:])
+		n += k
+		if err == io.EOF {
+			r.br = nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// SkippedBlocks returns the indexes of the blocks that have been
+// skipped so far because they were corrupt. The blocks of all streams
+// are counted starting at zero. The function returns nil if
+// SkipCorruptBlocks isn't set.
+func (r *Reader) SkippedBlocks() []int {
+	if r.skr == nil {
+		return nil
+	}
+	return append([]int(nil), r.skr.skipped...)
+}
@@ -0,0 +1,76 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestReaderSkipCorruptBlocks(t *testing.T) {
+	const txtlen = 100000
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(64)), txtlen)
+	orig := txt.Bytes()
+
+	// two streams separated by stream padding
+	xz := compressBlocks(t, orig[:txtlen/2], 16*1024)
+	xz = append(xz, 0, 0, 0, 0)
+	xz = append(xz, compressBlocks(t, orig[txtlen/2:], 10000)...)
+	blocks, err := readIndex(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("readIndex error %s", err)
+	}
+
+	// corrupt the data of block 2 and block 5
+	var want []byte
+	for i, b := range blocks {
+		u := orig[b.uncompressedOffset:][:b.rec.uncompressedSize]
+		if i == 2 || i == 5 {
+			xz[b.offset+b.rec.unpaddedSize/2] ^= 0x10
+			continue
+		}
+		want = append(want, u...)
+	}
+
+	r, err := ReaderConfig{SkipCorruptBlocks: true}.NewReader(
+		bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, want) {
+		t.Fatalf("recovered data differs from the intact blocks")
+	}
+	if s := r.SkippedBlocks(); !reflect.DeepEqual(s, []int{2, 5}) {
+		t.Fatalf("SkippedBlocks returned %v; want %v", s,
+			[]int{2, 5})
+	}
+
+	// Without the option the reader fails.
+	r, err = NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err == nil {
+		t.Fatalf("ReadAll didn't detect the corrupt block")
+	}
+
+	_, err = ReaderConfig{SkipCorruptBlocks: true}.NewReader(
+		bytes.NewBuffer(xz))
+	if err != errSkipNotSeekable {
+		t.Fatalf("NewReader returned %v; want %v", err,
+			errSkipNotSeekable)
+	}
+}
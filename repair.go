@@ -0,0 +1,33 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "io"
+
+// RepairIndex copies the first xz stream from src to dst and replaces
+// its index and footer by an index and footer computed from the blocks
+// of the stream. The data after the last block in src is ignored, so
+// a corrupt index or footer can be repaired. The checks of all blocks
+// are verified and the function returns an error if a block is
+// corrupt.
+func RepairIndex(dst io.Writer, src io.Reader) error {
+	rw, err := NewStreamRewriter(dst, src)
+	if err != nil {
+		return err
+	}
+	for {
+		b, err := rw.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if err = rw.WriteBlock(b); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
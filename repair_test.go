@@ -0,0 +1,62 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestRepairIndex(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(47)), 100000)
+	orig := compressBlocks(t, txt.Bytes(), 16*1024)
+
+	// corrupt the CRC-32 of the index
+	xz := append([]byte(nil), orig...)
+	xz[len(xz)-footerLen-1] ^= 0xff
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err == nil {
+		t.Fatalf("corrupt index not detected")
+	}
+
+	var buf bytes.Buffer
+	if err = RepairIndex(&buf, bytes.NewReader(xz)); err != nil {
+		t.Fatalf("RepairIndex error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), orig) {
+		t.Fatalf("repaired file differs from original")
+	}
+	r, err = NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, txt.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+
+	// A corrupt block can't be repaired.
+	blocks, err := readIndex(bytes.NewReader(orig), int64(len(orig)))
+	if err != nil {
+		t.Fatalf("readIndex error %s", err)
+	}
+	b := blocks[1]
+	xz[b.offset+b.rec.unpaddedSize-1] ^= 0xff
+	if err = RepairIndex(ioutil.Discard, bytes.NewReader(xz)); err == nil {
+		t.Fatalf("RepairIndex didn't detect the corrupt block")
+	}
+}
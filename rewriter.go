@@ -0,0 +1,205 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+)
+
+// RawBlock is a block of an xz stream in compressed form.
+type RawBlock struct {
+	// Header is the complete block header.
+	Header []byte
+	// Compressed contains the compressed data without the block
+	// padding.
+	Compressed []byte
+	// Check is the check of the uncompressed data of the block.
+	Check []byte
+	// UncompressedSize is the size of the uncompressed data.
+	UncompressedSize int64
+}
+
+// RewriterConfig defines the parameters for a StreamRewriter.
+type RewriterConfig struct {
+	// ChangeCheck requests the output stream to use the check
+	// method CheckSum instead of the check method of the source
+	// stream. The checks of the blocks are computed from the
+	// decoded data.
+	ChangeCheck bool
+	// CheckSum is the check method used if ChangeCheck is set.
+	CheckSum byte
+}
+
+// StreamRewriter copies the blocks of an xz stream in compressed form
+// and creates the index and the footer for the blocks written. It is
+// the primitive for tools that modify xz streams without recompressing
+// them, as RepairIndex does. The blocks are read with Next and written
+// with WriteBlock, which allows the caller to drop, reorder or insert
+// blocks. Close completes the output stream.
+type StreamRewriter struct {
+	src      io.Reader
+	dst      io.Writer
+	srcHash  func() hash.Hash
+	dstHash  func() hash.Hash
+	dstFlags byte
+	change   bool
+	index    []record
+	eos      bool
+	closed   bool
+}
+
+// NewStreamRewriter creates a StreamRewriter that keeps the check
+// method of the source stream.
+func NewStreamRewriter(dst io.Writer, src io.Reader) (*StreamRewriter,
+	error) {
+	return RewriterConfig{}.NewStreamRewriter(dst, src)
+}
+
+// NewStreamRewriter reads the header of the first xz stream in src and
+// writes the header of the output stream to dst.
+func (c RewriterConfig) NewStreamRewriter(dst io.Writer, src io.Reader,
+) (*StreamRewriter, error) {
+	sr, err := ReaderConfig{}.newStreamReader(src)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	rw := &StreamRewriter{
+		src:      src,
+		dst:      dst,
+		srcHash:  sr.newHash,
+		dstHash:  sr.newHash,
+		dstFlags: sr.h.flags,
+	}
+	if c.ChangeCheck && c.CheckSum != sr.h.flags {
+		if rw.dstHash, err = newHashFunc(c.CheckSum); err != nil {
+			return nil, err
+		}
+		rw.dstFlags = c.CheckSum
+		rw.change = true
+	}
+	h := header{flags: rw.dstFlags}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = dst.Write(data); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// Next reads the next block of the source stream. The block is decoded
+// and its check is verified. Next returns io.EOF after the last block.
+// The index and the footer of the source stream are not read, so a
+// corrupt index doesn't cause an error.
+func (rw *StreamRewriter) Next() (*RawBlock, error) {
+	if rw.eos {
+		return nil, io.EOF
+	}
+	var hbuf bytes.Buffer
+	bh, hlen, err := readBlockHeader(io.TeeReader(rw.src, &hbuf))
+	if err != nil {
+		if err == errIndexIndicator {
+			rw.eos = true
+			return nil, io.EOF
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	var cbuf bytes.Buffer
+	c := ReaderConfig{}
+	br, err := c.newBlockReader(io.TeeReader(rw.src, &cbuf), bh, hlen,
+		rw.srcHash())
+	if err != nil {
+		return nil, err
+	}
+	dh := rw.dstHash()
+	if _, err = io.Copy(dh, br); err != nil {
+		return nil, err
+	}
+	data := cbuf.Bytes()
+	n := br.compressedSize()
+	b := &RawBlock{
+		Header:           hbuf.Bytes(),
+		Compressed:       data[:n],
+		Check:            data[len(data)-br.hash.Size():],
+		UncompressedSize: br.uncompressedSize(),
+	}
+	if rw.change {
+		b.Check = dh.Sum(nil)
+	}
+	return b, nil
+}
+
+// errRawBlock indicates an inconsistent RawBlock.
+var errRawBlock = errors.New("xz: inconsistent raw block")
+
+// WriteBlock writes the block to the output stream and adds it to the
+// index. The header must be consistent with the compressed data and the
+// check must have the size required by the check method of the output
+// stream.
+func (rw *StreamRewriter) WriteBlock(b *RawBlock) error {
+	if rw.closed {
+		return errClosed
+	}
+	if len(b.Header) == 0 {
+		return errRawBlock
+	}
+	var bh blockHeader
+	if err := bh.UnmarshalBinary(b.Header); err != nil {
+		return err
+	}
+	n := int64(len(b.Compressed))
+	if bh.compressedSize >= 0 && bh.compressedSize != n {
+		return errRawBlock
+	}
+	if bh.uncompressedSize >= 0 &&
+		bh.uncompressedSize != b.UncompressedSize {
+		return errRawBlock
+	}
+	if len(b.Check) != rw.dstHash().Size() || b.UncompressedSize < 0 {
+		return errRawBlock
+	}
+	var pad [3]byte
+	for _, p := range [][]byte{b.Header, b.Compressed, pad[:padLen(n)],
+		b.Check} {
+		if _, err := rw.dst.Write(p); err != nil {
+			return err
+		}
+	}
+	rw.index = append(rw.index, record{
+		unpaddedSize:     int64(len(b.Header)) + n + int64(len(b.Check)),
+		uncompressedSize: b.UncompressedSize,
+	})
+	return nil
+}
+
+// Close writes the index and the footer of the output stream. The
+// source is not read any further.
+func (rw *StreamRewriter) Close() error {
+	if rw.closed {
+		return errClosed
+	}
+	rw.closed = true
+	f := footer{flags: rw.dstFlags}
+	var err error
+	if f.indexSize, err = writeIndex(rw.dst, rw.index); err != nil {
+		return err
+	}
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = rw.dst.Write(data)
+	return err
+}
@@ -0,0 +1,86 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestStreamRewriter(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(11)), 100000)
+	orig := compressBlocks(t, txt.Bytes(), 16*1024)
+
+	var buf bytes.Buffer
+	rw, err := NewStreamRewriter(&buf, bytes.NewReader(orig))
+	if err != nil {
+		t.Fatalf("NewStreamRewriter error %s", err)
+	}
+	n := 0
+	for {
+		b, err := rw.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("rw.Next error %s", err)
+		}
+		if err = rw.WriteBlock(b); err != nil {
+			t.Fatalf("rw.WriteBlock error %s", err)
+		}
+		n++
+	}
+	if err = rw.Close(); err != nil {
+		t.Fatalf("rw.Close error %s", err)
+	}
+	if n < 2 {
+		t.Fatalf("got %d blocks; want more than one", n)
+	}
+	if !bytes.Equal(buf.Bytes(), orig) {
+		t.Fatalf("rewritten stream differs from original")
+	}
+
+	// change the check to SHA-256
+	buf.Reset()
+	c := RewriterConfig{ChangeCheck: true, CheckSum: SHA256}
+	if rw, err = c.NewStreamRewriter(&buf, bytes.NewReader(orig)); err != nil {
+		t.Fatalf("NewStreamRewriter error %s", err)
+	}
+	for {
+		b, err := rw.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("rw.Next error %s", err)
+		}
+		if len(b.Check) != 32 {
+			t.Fatalf("got check size %d; want %d", len(b.Check), 32)
+		}
+		if err = rw.WriteBlock(b); err != nil {
+			t.Fatalf("rw.WriteBlock error %s", err)
+		}
+	}
+	if err = rw.Close(); err != nil {
+		t.Fatalf("rw.Close error %s", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, txt.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
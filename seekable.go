@@ -0,0 +1,144 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"runtime"
+)
+
+// errSeekableNoDictReset indicates that CompressSeekable has been
+// called with NoDictReset set.
+var errSeekableNoDictReset = errors.New(
+	"xz: CompressSeekable doesn't support NoDictReset")
+
+// blockResult is the compressed block produced by compressBlock.
+type blockResult struct {
+	data []byte
+	rec  record
+	err  error
+}
+
+// compressBlock compresses the n bytes at offset off of ra into a
+// complete block including header, padding and check.
+func (c *WriterConfig) compressBlock(ra io.ReaderAt, off, n int64,
+) (res blockResult) {
+	newHash, err := newHashFunc(c.CheckSum)
+	if err != nil {
+		return blockResult{err: err}
+	}
+	var buf bytes.Buffer
+	bw, err := c.newBlockWriter(&buf, newHash())
+	if err != nil {
+		return blockResult{err: err}
+	}
+	if err = bw.writeHeader(&buf); err != nil {
+		return blockResult{err: err}
+	}
+	if _, err = io.Copy(bw, io.NewSectionReader(ra, off, n)); err != nil {
+		return blockResult{err: err}
+	}
+	if err = bw.Close(); err != nil {
+		return blockResult{err: err}
+	}
+	return blockResult{data: buf.Bytes(), rec: bw.record()}
+}
+
+// CompressSeekable compresses the size bytes provided by ra into a
+// single xz stream written to w. The input is split into blocks of
+// cfg.BlockSize bytes, which are compressed in parallel by GOMAXPROCS
+// goroutines and written in order followed by the complete index. The
+// result is a standard xz file that supports random access, for
+// instance with DecodeRange. If BlockSize is zero three times the
+// dictionary capacity is used, as does xz for multithreaded
+// compression. The compressed blocks in flight are buffered in memory.
+// The OnCompressedBlock hook and Metadata are supported; Progress, the
+// output batching, BlockList and VerifyOutput are ignored.
+func CompressSeekable(w io.Writer, ra io.ReaderAt, size int64,
+	cfg WriterConfig) error {
+
+	blockSizeSet := cfg.BlockSize != 0
+	if err := cfg.Verify(); err != nil {
+		return err
+	}
+	if cfg.NoDictReset {
+		return errSeekableNoDictReset
+	}
+	if !blockSizeSet {
+		cfg.BlockSize = 3 * int64(cfg.DictCap)
+	}
+
+	h := header{flags: cfg.CheckSum}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+
+	// The producer starts the compression of the blocks in order.
+	// The capacity of pending limits the blocks in flight.
+	workers := runtime.GOMAXPROCS(0)
+	pending := make(chan chan blockResult, workers)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(pending)
+		i := 0
+		for off := int64(0); off < size; off += cfg.BlockSize {
+			if !rampUp(i, workers, cfg.WorkerLaunchDelay, done) {
+				return
+			}
+			i++
+			n := size - off
+			if n > cfg.BlockSize {
+				n = cfg.BlockSize
+			}
+			ch := make(chan blockResult, 1)
+			select {
+			case pending <- ch:
+			case <-done:
+				return
+			}
+			go func(off, n int64) {
+				ch <- cfg.compressBlock(ra, off, n)
+			}(off, n)
+		}
+	}()
+
+	var index []record
+	for ch := range pending {
+		res := <-ch
+		if res.err != nil {
+			return res.err
+		}
+		if _, err = w.Write(res.data); err != nil {
+			return err
+		}
+		if cfg.OnCompressedBlock != nil {
+			cfg.OnCompressedBlock(crc32.ChecksumIEEE(res.data))
+		}
+		index = append(index, res.rec)
+	}
+
+	f := footer{flags: h.flags}
+	if f.indexSize, err = writeIndex(w, index); err != nil {
+		return err
+	}
+	if data, err = f.MarshalBinary(); err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if len(cfg.Metadata) > 0 {
+		return cfg.writeMetadata(w, cfg.Metadata)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestCompressSeekable(t *testing.T) {
+	const (
+		txtlen    = 300000
+		blockSize = 32 * 1024
+	)
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(67)), txtlen)
+	orig := txt.Bytes()
+
+	var buf bytes.Buffer
+	err := CompressSeekable(&buf, bytes.NewReader(orig), txtlen,
+		WriterConfig{BlockSize: blockSize})
+	if err != nil {
+		t.Fatalf("CompressSeekable error %s", err)
+	}
+	xz := buf.Bytes()
+	ra := bytes.NewReader(xz)
+	if !bytes.Equal(xz, compressBlocks(t, orig, blockSize)) {
+		t.Fatalf("output differs from the output of Writer")
+	}
+
+	blocks, err := readIndex(ra, int64(len(xz)))
+	if err != nil {
+		t.Fatalf("readIndex error %s", err)
+	}
+	if n := (txtlen + blockSize - 1) / blockSize; len(blocks) != n {
+		t.Fatalf("file has %d blocks; want %d", len(blocks), n)
+	}
+
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, orig) {
+		t.Fatalf("decompressed data differs from original")
+	}
+
+	rng := rand.New(rand.NewSource(68))
+	for i := 0; i < 20; i++ {
+		start := rng.Int63n(txtlen)
+		length := rng.Int63n(txtlen - start + 1)
+		rr, err := DecodeRange(ra, int64(len(xz)), start, length)
+		if err != nil {
+			t.Fatalf("DecodeRange error %s", err)
+		}
+		p, err := ioutil.ReadAll(rr)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(p, orig[start:start+length]) {
+			t.Fatalf("range [%d,%d) differs from original", start,
+				start+length)
+		}
+	}
+
+	// empty input
+	buf.Reset()
+	if err = CompressSeekable(&buf, bytes.NewReader(nil), 0,
+		WriterConfig{}); err != nil {
+		t.Fatalf("CompressSeekable error %s", err)
+	}
+	if r, err = NewReader(&buf); err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if p, err = ioutil.ReadAll(r); err != nil || len(p) != 0 {
+		t.Fatalf("ReadAll returned %d bytes and error %v", len(p), err)
+	}
+}
+
+// timingReaderAt records the time of the first read from each segment
+// of the given size.
+type timingReaderAt struct {
+	ra      io.ReaderAt
+	segment int64
+	mu      sync.Mutex
+	first   map[int64]time.Time
+}
+
+func (r *timingReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	r.mu.Lock()
+	i := off / r.segment
+	if _, ok := r.first[i]; !ok {
+		r.first[i] = time.Now()
+	}
+	r.mu.Unlock()
+	return r.ra.ReadAt(p, off)
+}
+
+func TestCompressSeekableLaunchDelay(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	const (
+		txtlen    = 20000
+		blockSize = 5000
+		delay     = 30 * time.Millisecond
+	)
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(74)), txtlen)
+	orig := txt.Bytes()
+	ra := &timingReaderAt{
+		ra:      bytes.NewReader(orig),
+		segment: blockSize,
+		first:   make(map[int64]time.Time),
+	}
+	var buf bytes.Buffer
+	start := time.Now()
+	err := CompressSeekable(&buf, ra, txtlen, WriterConfig{
+		BlockSize:         blockSize,
+		WorkerLaunchDelay: delay,
+	})
+	if err != nil {
+		t.Fatalf("CompressSeekable error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), compressBlocks(t, orig, blockSize)) {
+		t.Fatal("output differs from sequential compression")
+	}
+	for i := int64(0); i < txtlen/blockSize; i++ {
+		d := ra.first[i].Sub(start)
+		if d < time.Duration(i)*delay {
+			t.Fatalf("block %d read after %v; want at least %v",
+				i, d, time.Duration(i)*delay)
+		}
+	}
+}
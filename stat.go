@@ -0,0 +1,201 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Stat summarizes an xz file. A metadata stream written by a Writer
+// with WriterConfig.Metadata set is counted like any other stream.
+type Stat struct {
+	// UncompressedSize is the total size of the uncompressed data.
+	UncompressedSize int64
+	// Blocks is the number of blocks in all streams.
+	Blocks int
+	// Streams is the number of streams.
+	Streams int
+	// CheckType is the check method of the first stream: None,
+	// CRC32, CRC64 or SHA256.
+	CheckType byte
+}
+
+// Stater computes the Stat of xz files reading only the stream headers,
+// the indexes and the footers. The buffer for the data read is reused
+// by all calls of Stat, so stat-ing many files requires few
+// allocations. A Stater must not be used concurrently.
+type Stater struct {
+	buf []byte
+}
+
+// QuickStat returns the Stat of the xz file provided by ra and size.
+// Use a Stater to stat many files.
+func QuickStat(ra io.ReaderAt, size int64) (Stat, error) {
+	var s Stater
+	return s.Stat(ra, size)
+}
+
+// scratch returns a slice of n bytes from the reusable buffer.
+func (s *Stater) scratch(n int) []byte {
+	if cap(s.buf) < n {
+		s.buf = make([]byte, n)
+	}
+	return s.buf[:n]
+}
+
+// Stat returns the Stat of the xz file provided by ra and size. The
+// streams are located starting from the end of the file and the
+// checksums of the headers, indexes and footers are verified. The
+// blocks are not read.
+func (s *Stater) Stat(ra io.ReaderAt, size int64) (st Stat, err error) {
+	end := size
+	for end > 0 {
+		if end%4 != 0 {
+			return Stat{}, errors.New("xz: file size not aligned")
+		}
+		p := s.scratch(4)
+		if _, err = ra.ReadAt(p, end-4); err != nil {
+			return Stat{}, err
+		}
+		if allZeros(p) {
+			end -= 4
+			continue
+		}
+		if end < HeaderLen+footerLen {
+			return Stat{}, errors.New("xz: file too short")
+		}
+		p = s.scratch(footerLen)
+		if _, err = ra.ReadAt(p, end-footerLen); err != nil {
+			return Stat{}, err
+		}
+		var f footer
+		if err = f.UnmarshalBinary(p); err != nil {
+			return Stat{}, err
+		}
+		indexStart := end - footerLen - f.indexSize
+		if indexStart < HeaderLen {
+			return Stat{}, errors.New(
+				"xz: index size in footer wrong")
+		}
+		n, err := intSize(uint64(f.indexSize))
+		if err != nil {
+			return Stat{}, err
+		}
+		p = s.scratch(n)
+		if _, err = ra.ReadAt(p, indexStart); err != nil {
+			return Stat{}, err
+		}
+		blocks, u, c, err := parseIndex(p)
+		if err != nil {
+			return Stat{}, err
+		}
+		start := indexStart - c - HeaderLen
+		if start < 0 {
+			return Stat{}, errors.New(
+				"xz: index inconsistent with file")
+		}
+		p = s.scratch(HeaderLen)
+		if _, err = ra.ReadAt(p, start); err != nil {
+			return Stat{}, err
+		}
+		var h header
+		if err = h.UnmarshalBinary(p); err != nil {
+			return Stat{}, err
+		}
+		if h.flags != f.flags {
+			return Stat{}, errors.New("xz: footer flags incorrect")
+		}
+		st.UncompressedSize, err = addSize(st.UncompressedSize, u)
+		if err != nil {
+			return Stat{}, err
+		}
+		st.Blocks += blocks
+		st.Streams++
+		// The streams are read backwards; the last one read is
+		// the first stream.
+		st.CheckType = h.flags
+		end = start
+	}
+	if st.Streams == 0 {
+		return Stat{}, errors.New("xz: no stream found")
+	}
+	return st, nil
+}
+
+// sliceUvarint decodes a variable-length integer from p like
+// readUvarint.
+func sliceUvarint(p []byte) (x uint64, n int, err error) {
+	const maxUvarintLen = 10
+
+	var s uint
+	for i, b := range p {
+		if i+1 > maxUvarintLen {
+			return x, i + 1, errOverflowU64
+		}
+		if b < 0x80 {
+			if i+1 == maxUvarintLen && b > 1 {
+				return x, i + 1, errOverflowU64
+			}
+			return x | uint64(b)<<s, i + 1, nil
+		}
+		x |= uint64(b&0x7f) << s
+		s += 7
+	}
+	return x, len(p), io.ErrUnexpectedEOF
+}
+
+// parseIndex parses the complete index in p including the index
+// indicator, the padding and the CRC-32. It returns the number of
+// blocks, the sum of the uncompressed sizes and the sum of the padded
+// sizes of the blocks.
+func parseIndex(p []byte) (blocks int, u, c int64, err error) {
+	if len(p) < 8 || len(p)%4 != 0 {
+		return 0, 0, 0, errors.New("xz: index has wrong size")
+	}
+	if p[0] != 0 {
+		return 0, 0, 0, errors.New("xz: no index indicator")
+	}
+	body := p[:len(p)-4]
+	if crc32.ChecksumIEEE(body) != uint32LE(p[len(p)-4:]) {
+		return 0, 0, 0, errors.New("xz: wrong checksum for index")
+	}
+	q := body[1:]
+	x, k, err := sliceUvarint(q)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	q = q[k:]
+	// every record requires at least two bytes
+	if x > uint64(len(q)/2) {
+		return 0, 0, 0, errors.New("xz: record number overflow")
+	}
+	blocks = int(x)
+	for i := 0; i < blocks; i++ {
+		var rec [2]uint64
+		for j := range rec {
+			rec[j], k, err = sliceUvarint(q)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			q = q[k:]
+		}
+		if rec[0] == 0 || rec[0] > maxInt64-3 || rec[1] > maxInt64 {
+			return 0, 0, 0, errors.New("xz: invalid index record")
+		}
+		r := record{int64(rec[0]), int64(rec[1])}
+		if c, err = addSize(c, r.paddedSize()); err != nil {
+			return 0, 0, 0, err
+		}
+		if u, err = addSize(u, r.uncompressedSize); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	if len(q) > 3 || !allZeros(q) {
+		return 0, 0, 0, errors.New("xz: index padding invalid")
+	}
+	return blocks, u, c, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestQuickStat(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(78)), 100000)
+	orig := txt.Bytes()
+
+	// two streams separated by stream padding
+	xz := compressBlocks(t, orig[:30000], 7000)
+	xz = append(xz, 0, 0, 0, 0)
+	var buf bytes.Buffer
+	w, err := WriterConfig{CheckSum: SHA256, BlockSize: 20000}.NewWriter(
+		&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(orig[30000:]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	xz = append(xz, buf.Bytes()...)
+
+	st, err := QuickStat(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("QuickStat error %s", err)
+	}
+	want := Stat{
+		UncompressedSize: int64(len(orig)),
+		Blocks:           5 + 4,
+		Streams:          2,
+		CheckType:        CRC64,
+	}
+	if st != want {
+		t.Fatalf("QuickStat returned %+v; want %+v", st, want)
+	}
+
+	// corrupt the index of the second stream
+	var s Stater
+	xz[len(xz)-footerLen-2] ^= 0x01
+	if _, err = s.Stat(bytes.NewReader(xz), int64(len(xz))); err == nil {
+		t.Fatal("Stat didn't detect the corrupt index")
+	}
+
+	for _, index := range [][]record{
+		{{16, 1 << 62}, {16, 1 << 62}},
+		{{maxInt64 - 1, 1}},
+	} {
+		xz := indexOnlyStream(t, index)
+		_, err = s.Stat(bytes.NewReader(xz), int64(len(xz)))
+		if err == nil {
+			t.Errorf("index %v: Stat didn't return an error", index)
+		}
+	}
+}
+
+func BenchmarkStater(b *testing.B) {
+	const files = 100
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(79)), 4096)
+	readers := make([]*bytes.Reader, files)
+	sizes := make([]int64, files)
+	for i := range readers {
+		var buf bytes.Buffer
+		w, err := WriterConfig{BlockSize: 1024}.NewWriter(&buf)
+		if err != nil {
+			b.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt.Bytes()[:1024+i*30]); err != nil {
+			b.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			b.Fatalf("w.Close error %s", err)
+		}
+		readers[i] = bytes.NewReader(buf.Bytes())
+		sizes[i] = int64(buf.Len())
+	}
+	var s Stater
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, r := range readers {
+			if _, err := s.Stat(r, sizes[j]); err != nil {
+				b.Fatalf("Stat error %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+)
+
+// StreamReader supports the reading of concatenated xz streams one
+// stream at a time. Stream padding between the streams is skipped.
+type StreamReader struct {
+	ReaderConfig
+
+	xz  *bufio.Reader
+	r   *Reader
+	err error
+}
+
+// NewStreamReader creates a StreamReader for the concatenated xz
+// streams using the default parameters.
+func NewStreamReader(xz io.Reader) (sr *StreamReader, err error) {
+	return ReaderConfig{}.NewStreamReader(xz)
+}
+
+// NewStreamReader creates a StreamReader for the concatenated xz
+// streams. The fields Embedded and SingleStream of the configuration
+// are ignored.
+func (c ReaderConfig) NewStreamReader(xz io.Reader) (sr *StreamReader,
+	err error) {
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	c.Embedded = true
+	c.SingleStream = false
+	sr = &StreamReader{ReaderConfig: c, xz: bufio.NewReader(xz)}
+	return sr, nil
+}
+
+// skipPadding skips the stream padding. It returns io.EOF if no further
+// data follows.
+func (sr *StreamReader) skipPadding() error {
+	for {
+		p, err := sr.xz.Peek(4)
+		if len(p) == 0 && err == io.EOF {
+			return io.EOF
+		}
+		if len(p) < 4 {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if !allZeros(p) {
+			return nil
+		}
+		if sr.CheckMinimal {
+			return ErrExcessPadding
+		}
+		if _, err = sr.xz.Discard(4); err != nil {
+			return err
+		}
+	}
+}
+
+// Next returns a reader for the next stream. The reader returns io.EOF
+// at the footer of the stream. Data of the previous stream that has not
+// been read is skipped. Next returns io.EOF if no more streams follow.
+func (sr *StreamReader) Next() (r io.Reader, err error) {
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if sr.r != nil {
+		if _, err = io.Copy(ioutil.Discard, sr.r); err != nil {
+			sr.err = err
+			return nil, err
+		}
+		sr.r = nil
+	}
+	if err = sr.skipPadding(); err != nil {
+		sr.err = err
+		return nil, err
+	}
+	if sr.r, err = sr.ReaderConfig.NewReader(sr.xz); err != nil {
+		sr.err = err
+		return nil, err
+	}
+	return sr.r, nil
+}
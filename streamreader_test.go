@@ -0,0 +1,61 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStreamReader(t *testing.T) {
+	var buf bytes.Buffer
+	var want []string
+	for i := 0; i < 3; i++ {
+		s := fmt.Sprintf("dataset %d\n", i)
+		want = append(want, s)
+		w, err := NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = io.WriteString(w, s); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		if i == 1 {
+			// stream padding
+			buf.Write(make([]byte, 8))
+		}
+	}
+
+	sr, err := NewStreamReader(&buf)
+	if err != nil {
+		t.Fatalf("NewStreamReader error %s", err)
+	}
+	for i, s := range want {
+		r, err := sr.Next()
+		if err != nil {
+			t.Fatalf("Next error %s", err)
+		}
+		if i == 1 {
+			// The data of the stream is skipped by Next.
+			continue
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if string(p) != s {
+			t.Fatalf("stream %d: got %q; want %q", i, p, s)
+		}
+	}
+	if _, err = sr.Next(); err != io.EOF {
+		t.Fatalf("Next returned error %v; want %v", err, io.EOF)
+	}
+}
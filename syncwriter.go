@@ -0,0 +1,51 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "sync"
+
+// SyncWriter serializes the calls of the methods of a Writer with a
+// mutex, so it can be used from multiple goroutines, for instance by a
+// goroutine writing data and a background goroutine flushing it
+// periodically. The Writer itself remains restricted to a single
+// goroutine at a time.
+type SyncWriter struct {
+	mu sync.Mutex
+	w  *Writer
+}
+
+// NewSyncWriter creates a SyncWriter for w. The Writer must not be
+// used directly anymore.
+func NewSyncWriter(w *Writer) *SyncWriter {
+	return &SyncWriter{w: w}
+}
+
+// Write compresses the data in p.
+func (sw *SyncWriter) Write(p []byte) (n int, err error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.Write(p)
+}
+
+// NextBlock calls NextBlock of the Writer.
+func (sw *SyncWriter) NextBlock() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.NextBlock()
+}
+
+// Flush completes the current block, so all data written so far is
+// available to the consumer of the underlying writer. It is equivalent
+// to NextBlock, which is the only way to flush a Writer.
+func (sw *SyncWriter) Flush() error {
+	return sw.NextBlock()
+}
+
+// Close closes the Writer.
+func (sw *SyncWriter) Close() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.Close()
+}
@@ -0,0 +1,73 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestSyncWriter(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(37)), 200000)
+	data := txt.Bytes()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	sw := NewSyncWriter(w)
+	done := make(chan struct{})
+	flushed := make(chan error, 1)
+	go func() {
+		var err error
+		for {
+			select {
+			case <-done:
+				flushed <- err
+				return
+			default:
+			}
+			if e := sw.Flush(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+	for p := data; len(p) > 0; {
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err = sw.Write(p[:n]); err != nil {
+			t.Fatalf("sw.Write error %s", err)
+		}
+		p = p[n:]
+	}
+	close(done)
+	if err = <-flushed; err != nil {
+		t.Fatalf("sw.Flush error %s", err)
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatalf("sw.Close error %s", err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// targetDictCapExps provides the dictionary capacities of the levels
+// searched by CompressToTarget as exponents of two. The values are the
+// ones used by the presets of xz-utils.
+var targetDictCapExps = []uint{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
+
+// targetConfig returns the writer configuration for the given level.
+// The levels up to 3 use the faster hash table matcher. The dictionary
+// capacity is limited by the size of the data.
+func targetConfig(level int, size int64) WriterConfig {
+	c := WriterConfig{
+		DictCap:  1 << targetDictCapExps[level],
+		Matcher:  lzma.BinaryTree,
+		SizeHint: size,
+	}
+	if level <= 3 {
+		c.Matcher = lzma.HashTable4
+	}
+	return c
+}
+
+// errTargetUnreachable indicates that no level compresses the data
+// into the target size.
+var errTargetUnreachable = errors.New("xz: target size not reachable")
+
+// compressData compresses the data into an xz stream.
+func compressData(data []byte, c WriterConfig) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CompressToTarget compresses data into an xz stream not larger than
+// targetBytes. It compresses the data with the levels 0 to 9, which
+// use the dictionary capacities of the xz-utils presets and the hash
+// table matcher for the levels up to 3, and returns the output closest
+// to the target together with the configuration producing it. The
+// compression ratio doesn't grow strictly with the level, so all
+// levels are tried; levels that are identical for the size of the data
+// are skipped. An error is returned if no level meets the target.
+func CompressToTarget(data []byte, targetBytes int64) ([]byte,
+	WriterConfig, error) {
+
+	if targetBytes <= 0 {
+		return nil, WriterConfig{}, errors.New(
+			"xz: target size must be positive")
+	}
+	var (
+		best     []byte
+		bestCfg  WriterConfig
+		smallest int64 = -1
+		tried    []WriterConfig
+	)
+loop:
+	for level := range targetDictCapExps {
+		cfg := targetConfig(level, int64(len(data)))
+		v := cfg
+		if err := v.Verify(); err != nil {
+			return nil, WriterConfig{}, err
+		}
+		for _, t := range tried {
+			if t.DictCap == v.DictCap && t.Matcher == v.Matcher {
+				continue loop
+			}
+		}
+		tried = append(tried, v)
+		out, err := compressData(data, cfg)
+		if err != nil {
+			return nil, WriterConfig{}, err
+		}
+		n := int64(len(out))
+		if smallest < 0 || n < smallest {
+			smallest = n
+		}
+		if n <= targetBytes && n > int64(len(best)) {
+			best, bestCfg = out, cfg
+		}
+	}
+	if best == nil {
+		return nil, WriterConfig{}, fmt.Errorf(
+			"%w: smallest output has %d bytes",
+			errTargetUnreachable, smallest)
+	}
+	return best, bestCfg, nil
+}
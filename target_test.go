@@ -0,0 +1,85 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestCompressToTarget(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(75)), 100000)
+	data := txt.Bytes()
+
+	var sizes []int
+	for level := range targetDictCapExps {
+		p, err := compressData(data, targetConfig(level,
+			int64(len(data))))
+		if err != nil {
+			t.Fatalf("compressData error %s", err)
+		}
+		sizes = append(sizes, len(p))
+	}
+	t.Logf("sizes %v", sizes)
+	smallest, largest := sizes[0], sizes[0]
+	for _, n := range sizes {
+		if n < smallest {
+			smallest = n
+		}
+		if n > largest {
+			largest = n
+		}
+	}
+	if smallest == largest {
+		t.Fatal("all levels produce the same size")
+	}
+
+	// The largest output is excluded by the target.
+	target := int64(largest - 1)
+	want := 0
+	for _, n := range sizes {
+		if int64(n) <= target && n > want {
+			want = n
+		}
+	}
+	out, cfg, err := CompressToTarget(data, target)
+	if err != nil {
+		t.Fatalf("CompressToTarget error %s", err)
+	}
+	if len(out) != want {
+		t.Fatalf("output has %d bytes; want %d", len(out), want)
+	}
+	p, err := compressData(data, cfg)
+	if err != nil {
+		t.Fatalf("compressData error %s", err)
+	}
+	if !bytes.Equal(p, out) {
+		t.Fatal("returned configuration doesn't reproduce the output")
+	}
+	r, err := NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	dec, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(dec, data) {
+		t.Fatal("decompressed data differs from original")
+	}
+
+	_, _, err = CompressToTarget(data, int64(smallest-1))
+	if !errors.Is(err, errTargetUnreachable) {
+		t.Fatalf("CompressToTarget returned %v; want %v", err,
+			errTargetUnreachable)
+	}
+}
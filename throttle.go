@@ -0,0 +1,75 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "time"
+
+// tokenBucket limits the rate of bytes per second. The bucket is filled
+// with rate tokens per second and holds at most burst tokens.
+type tokenBucket struct {
+	rate   int64
+	burst  int64
+	tokens int64
+	last   time.Time
+}
+
+// newTokenBucket creates a token bucket for the given rate in bytes per
+// second. The burst size is a tenth of the rate, so that the output
+// becomes smooth.
+func newTokenBucket(rate int64) *tokenBucket {
+	burst := rate / 10
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:  rate,
+		burst: burst,
+	}
+}
+
+// fill adds the tokens accumulated since the last call.
+func (b *tokenBucket) fill() {
+	now := time.Now()
+	if b.last.IsZero() {
+		b.last = now
+		b.tokens = b.burst
+		return
+	}
+	d := now.Sub(b.last)
+	t := int64(d.Seconds() * float64(b.rate))
+	if t <= 0 {
+		return
+	}
+	b.last = now
+	b.tokens += t
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// take waits until at least one token is available and returns the
+// number of tokens that may be used, which is at most n. The caller must
+// call put with the number of unused tokens.
+func (b *tokenBucket) take(n int) int {
+	for {
+		b.fill()
+		if b.tokens > 0 {
+			break
+		}
+		d := time.Duration(float64(1-b.tokens) / float64(b.rate) *
+			float64(time.Second))
+		time.Sleep(d)
+	}
+	if int64(n) > b.tokens {
+		n = int(b.tokens)
+	}
+	b.tokens -= int64(n)
+	return n
+}
+
+// put returns unused tokens to the bucket.
+func (b *tokenBucket) put(n int) {
+	b.tokens += int64(n)
+}
@@ -0,0 +1,85 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// errVerifyMismatch indicates that the decompressed output of a
+// Writer differs from its input.
+var errVerifyMismatch = errors.New(
+	"xz: verification failed; decompressed data differs from input")
+
+// outputVerifier decompresses the output of a Writer in a separate
+// goroutine and compares the SHA-256 hash of the decompressed data
+// with the hash of the input.
+type outputVerifier struct {
+	pw *io.PipeWriter
+	in hash.Hash
+	// done is closed after the decoder goroutine has stopped
+	done chan struct{}
+	out  []byte
+	err  error
+}
+
+// newOutputVerifier starts the decoder goroutine. The noDictReset flag
+// must be set if the blocks continue the dictionary of the previous
+// block.
+func newOutputVerifier(noDictReset bool) *outputVerifier {
+	pr, pw := io.Pipe()
+	v := &outputVerifier{
+		pw:   pw,
+		in:   sha256.New(),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(v.done)
+		h := sha256.New()
+		r, err := ReaderConfig{NoDictReset: noDictReset}.NewReader(pr)
+		if err == nil {
+			_, err = io.Copy(h, r)
+		}
+		if err != nil {
+			v.err = fmt.Errorf("xz: verification failed; %w", err)
+			// The writer must not block.
+			io.Copy(ioutil.Discard, pr)
+			return
+		}
+		v.out = h.Sum(nil)
+	}()
+	return v
+}
+
+// Write provides the compressed output to the decoder.
+func (v *outputVerifier) Write(p []byte) (n int, err error) {
+	return v.pw.Write(p)
+}
+
+// abort stops the decoder goroutine.
+func (v *outputVerifier) abort(err error) {
+	v.pw.CloseWithError(err)
+	<-v.done
+}
+
+// close waits for the decoder to complete and compares the
+// decompressed data with the input.
+func (v *outputVerifier) close() error {
+	v.pw.Close()
+	<-v.done
+	if v.err != nil {
+		return v.err
+	}
+	if !bytes.Equal(v.in.Sum(nil), v.out) {
+		return errVerifyMismatch
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// faultyWriteCloser flips a bit in the data written to it.
+type faultyWriteCloser struct {
+	io.WriteCloser
+}
+
+func (w faultyWriteCloser) Write(p []byte) (n int, err error) {
+	q := make([]byte, len(p))
+	copy(q, p)
+	if len(q) > 0 {
+		q[len(q)/2] ^= 1
+	}
+	return w.WriteCloser.Write(q)
+}
+
+func TestWriterVerifyOutput(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(62)), 200000)
+	var fault func(w io.WriteCloser) io.WriteCloser
+	compress := func(noCheck bool) (data []byte, err error) {
+		var buf bytes.Buffer
+		w, err := WriterConfig{
+			VerifyOutput: true,
+			BlockSize:    64 * 1024,
+			NoCheckSum:   noCheck,
+			encoderFault: fault,
+		}.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt.Bytes()); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		err = w.Close()
+		return buf.Bytes(), err
+	}
+
+	data, err := compress(false)
+	if err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, txt.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+
+	fault = func(w io.WriteCloser) io.WriteCloser {
+		return faultyWriteCloser{w}
+	}
+	if _, err = compress(false); err == nil {
+		t.Fatalf("w.Close didn't detect wrong output")
+	}
+	t.Logf("w.Close error %s", err)
+	// Without check only the comparison detects the error.
+	if _, err = compress(true); err != errVerifyMismatch {
+		t.Fatalf("w.Close returned %v; want %v", err,
+			errVerifyMismatch)
+	}
+}
@@ -5,10 +5,13 @@
 package xz
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"hash"
+	"hash/crc32"
 	"io"
+	"time"
 
 	"github.com/ulikunitz/xz/lzma"
 )
@@ -18,13 +21,157 @@ type WriterConfig struct {
 	Properties *lzma.Properties
 	DictCap    int
 	BufSize    int
-	BlockSize  int64
+	// BlockSize splits the uncompressed data into blocks of the given
+	// size, which supports seeking in the xz file. Compression is
+	// done sequentially, so the output is deterministic for a given
+	// configuration (default: a single block).
+	BlockSize int64
+	// BlockList provides the uncompressed offsets at which new
+	// blocks start, as the option --block-list of xz does, which
+	// supports aligning the blocks to record boundaries. The
+	// offsets must be positive and increasing. BlockSize still
+	// limits the size of the blocks, but by default it doesn't
+	// split any block. CompressSeekable ignores BlockList.
+	BlockList []int64
 	// checksum method: CRC32, CRC64 or SHA256 (default: CRC64)
 	CheckSum byte
 	// Forces NoChecksum (default: false)
 	NoCheckSum bool
 	// match algorithm
 	Matcher lzma.MatchAlgorithm
+	// match length at which the matcher stops searching for longer
+	// matches (default: 273)
+	NiceLen int
+	// size of the hash table as power of two (default: derived from
+	// DictCap)
+	HashBits int
+	// TimeBudget requests the selection of the match algorithm
+	// based on the expected compression time for SizeHint bytes.
+	// The selection is a best-effort heuristic and overrides
+	// Matcher. A zero value disables the selection.
+	TimeBudget time.Duration
+	// SizeHint provides the expected size of the uncompressed data.
+	// The dictionary capacity is reduced to the smallest supported
+	// capacity not less than SizeHint. Together with TimeBudget it
+	// controls the selection of the match algorithm.
+	SizeHint int64
+	// Progress is called after each Write and after Close with the
+	// total number of uncompressed bytes written to the Writer and
+	// the number of compressed bytes written to the underlying
+	// writer so far. Since compressed data is buffered the second
+	// value is an estimate until Close. The calls are made from the
+	// goroutine calling Write or Close.
+	Progress func(inBytes, outBytesEstimate int64)
+	// OutputBatchSize coalesces the writes to the underlying writer
+	// into writes of the given size. The last batch is written by
+	// Close. Zero disables batching.
+	OutputBatchSize int
+	// AdaptiveOutputBatch adapts the size of the output batches to
+	// the speed of the underlying writer. The size grows while the
+	// writer keeps up with the compression and shrinks if writing
+	// becomes the bottleneck. OutputBatchSize provides the maximum
+	// batch size (default: 1 MiB).
+	AdaptiveOutputBatch bool
+	// BufferOutput coalesces the writes to the underlying writer,
+	// which increases the throughput for files. Without it every
+	// LZMA2 chunk is written immediately, which reduces the latency
+	// for pipes. The batch size is OutputBatchSize (default: 64 KiB).
+	BufferOutput bool
+	// OnCompressedBlock is called after a block has been completed
+	// with the CRC-32 checksum of the compressed block including the
+	// block header, padding and check. The checksum is computed
+	// while the block is written, so the block is not kept in
+	// memory. The hook allows to store the checksums outside of the
+	// xz file, which can be verified by VerifyCompressedBlocks
+	// without decompression.
+	OnCompressedBlock func(crc uint32)
+	// VerifyOutput requests that the compressed output is
+	// decompressed while it is written and compared with the input.
+	// Close returns an error if the data differs, so encoder bugs
+	// are caught before the data is committed. The decoder runs in
+	// its own goroutine and roughly doubles the CPU usage.
+	VerifyOutput bool
+	// Metadata is stored by Close in an additional xz stream after
+	// the main stream, since the xz format has no comment field.
+	// Reader.Metadata returns it and the Reader doesn't return it as
+	// data. Other xz decoders output the metadata after the data
+	// prefixed by a 16-byte magic. A Reader with SingleStream set
+	// rejects the file. The size is limited to 1 MiB. Note that the
+	// Reader takes any stream after the first one whose data starts
+	// with the magic for a metadata stream, so concatenated streams
+	// must not start with it.
+	Metadata []byte
+	// NoDictReset lets every block continue the dictionary of the
+	// previous block instead of starting with an empty dictionary,
+	// which improves the compression ratio of small blocks. The
+	// xz format requires a dictionary reset at the start of each
+	// block, so only a Reader with ReaderConfig.NoDictReset set can
+	// decode such files. The blocks cannot
+	// be decoded on their own: xz-utils, DecodeRange,
+	// DecompressToWriterAt and SkipCorruptBlocks fail and
+	// CompressSeekable rejects the option.
+	NoDictReset bool
+	// WorkerLaunchDelay staggers the start of the goroutines
+	// compressing the blocks in CompressSeekable. The first
+	// GOMAXPROCS goroutines are started one after another with the
+	// given delay, so the CPU usage ramps up gradually. Zero starts
+	// them at once.
+	WorkerLaunchDelay time.Duration
+	// X86 puts the x86 BCJ filter in front of the LZMA2 filter, as
+	// the option --x86 of xz does. The filter converts the targets
+	// of the CALL and JMP instructions into absolute addresses,
+	// which improves the compression of x86 executables.
+	X86 bool
+	// ARM puts the ARM BCJ filter in front of the LZMA2 filter, as
+	// the option --arm of xz does, which improves the compression
+	// of ARM executables.
+	ARM bool
+	// ARMThumb puts the ARM-Thumb BCJ filter in front of the LZMA2
+	// filter, as the option --armthumb of xz does, which improves
+	// the compression of executables using the Thumb instruction
+	// set.
+	ARMThumb bool
+	// ARM64 puts the ARM64 BCJ filter in front of the LZMA2 filter,
+	// as the option --arm64 of xz does, which improves the
+	// compression of aarch64 executables. Decoding the files
+	// requires XZ Utils 5.4.0 or later.
+	ARM64 bool
+
+	// chain passes the dictionary from block to block if
+	// NoDictReset is set.
+	chain *dictChain
+	// encoderFault allows the tests to replace the output of the
+	// filters of a block by wrong data to simulate encoder bugs.
+	encoderFault func(w io.WriteCloser) io.WriteCloser
+}
+
+// budgetPreset describes a match algorithm and its estimated
+// compression speed in bytes per second.
+type budgetPreset struct {
+	matcher lzma.MatchAlgorithm
+	speed   int64
+}
+
+// budgetPresets lists the presets considered for a time budget. The
+// presets are ordered from the slowest to the fastest. The speeds are
+// rough estimates for text on current hardware.
+var budgetPresets = []budgetPreset{
+	{lzma.BinaryTree, 512 * 1024},
+	{lzma.HashTable4, 2 * 1024 * 1024},
+}
+
+// budgetMatcher returns the match algorithm of the slowest preset that
+// is expected to compress size bytes within the time budget. If no
+// preset fits into the budget the fastest preset is chosen.
+func budgetMatcher(size int64, budget time.Duration) lzma.MatchAlgorithm {
+	for _, p := range budgetPresets {
+		d := time.Duration(float64(size) / float64(p.speed) *
+			float64(time.Second))
+		if d <= budget {
+			return p.matcher
+		}
+	}
+	return budgetPresets[len(budgetPresets)-1].matcher
 }
 
 // fill replaces zero values with default values.
@@ -35,6 +182,18 @@ func (c *WriterConfig) fill() {
 	if c.DictCap == 0 {
 		c.DictCap = 8 * 1024 * 1024
 	}
+	// A dictionary larger than the input wastes memory.
+	if c.SizeHint > 0 && int64(c.DictCap) > c.SizeHint {
+		c.DictCap = int(lzma.SuggestDictCap(c.SizeHint))
+	}
+	// The LZMA2 filter property can represent only a subset of the
+	// dictionary capacities. We use the capacity that the reader
+	// will see.
+	if c.DictCap > 0 {
+		if d := lzma.NearestDictCap(int64(c.DictCap)); int64(int(d)) == d {
+			c.DictCap = int(d)
+		}
+	}
 	if c.BufSize == 0 {
 		c.BufSize = 4096
 	}
@@ -47,6 +206,12 @@ func (c *WriterConfig) fill() {
 	if c.NoCheckSum {
 		c.CheckSum = None
 	}
+	if c.BufferOutput && c.OutputBatchSize == 0 && !c.AdaptiveOutputBatch {
+		c.OutputBatchSize = defaultOutputBatch
+	}
+	if c.TimeBudget > 0 && c.SizeHint > 0 {
+		c.Matcher = budgetMatcher(c.SizeHint, c.TimeBudget)
+	}
 }
 
 // Verify checks the configuration for errors. Zero values will be
@@ -61,6 +226,8 @@ func (c *WriterConfig) Verify() error {
 		DictCap:    c.DictCap,
 		BufSize:    c.BufSize,
 		Matcher:    c.Matcher,
+		NiceLen:    c.NiceLen,
+		HashBits:   c.HashBits,
 	}
 	if err := lc.Verify(); err != nil {
 		return err
@@ -68,36 +235,84 @@ func (c *WriterConfig) Verify() error {
 	if c.BlockSize <= 0 {
 		return errors.New("xz: block size out of range")
 	}
+	for i, off := range c.BlockList {
+		if off <= 0 || (i > 0 && off <= c.BlockList[i-1]) {
+			return errors.New(
+				"xz: block list offsets not positive and increasing")
+		}
+	}
+	if c.TimeBudget < 0 {
+		return errors.New("xz: negative time budget")
+	}
+	if c.SizeHint < 0 {
+		return errors.New("xz: negative size hint")
+	}
+	if c.OutputBatchSize < 0 {
+		return errors.New("xz: negative output batch size")
+	}
+	if c.WorkerLaunchDelay < 0 {
+		return errors.New("xz: negative worker launch delay")
+	}
 	if err := verifyFlags(c.CheckSum); err != nil {
 		return err
 	}
+	if len(c.Metadata) > maxMetadataLen {
+		return errMetadataTooLarge
+	}
+	if err := verifyFilters(c.filters()); err != nil {
+		return err
+	}
 	return nil
 }
 
 // filters creates the filter list for the given parameters.
 func (c *WriterConfig) filters() []filter {
-	return []filter{&lzmaFilter{int64(c.DictCap)}}
+	var f []filter
+	if c.X86 {
+		f = append(f, &x86Filter{})
+	}
+	if c.ARM {
+		f = append(f, &armFilter{})
+	}
+	if c.ARMThumb {
+		f = append(f, &armThumbFilter{})
+	}
+	if c.ARM64 {
+		f = append(f, &arm64Filter{})
+	}
+	return append(f, &lzmaFilter{int64(c.DictCap)})
 }
 
 // maxInt64 defines the maximum 64-bit signed integer.
 const maxInt64 = 1<<63 - 1
 
+// Errors returned by verifyFilters.
+var (
+	errNoFilters        = errors.New("xz: no filters")
+	errTooManyFilters   = errors.New("xz: more than four filters")
+	errLastFilterInside = errors.New("xz: last filter is not last")
+	errWrongLastFilter  = errors.New("xz: wrong last filter")
+)
+
 // verifyFilters checks the filter list for the length and the right
-// sequence of filters.
+// sequence of filters. The xz format requires that the last filter is a
+// filter that can only be used as last filter, for instance LZMA2, and
+// that all other filters are non-last filters. The non-last filters may
+// appear in any order.
 func verifyFilters(f []filter) error {
 	if len(f) == 0 {
-		return errors.New("xz: no filters")
+		return errNoFilters
 	}
-	if len(f) > 4 {
-		return errors.New("xz: more than four filters")
+	if len(f) > maxFilters {
+		return errTooManyFilters
 	}
 	for _, g := range f[:len(f)-1] {
 		if g.last() {
-			return errors.New("xz: last filter is not last")
+			return errLastFilterInside
 		}
 	}
 	if !f[len(f)-1].last() {
-		return errors.New("xz: wrong last filter")
+		return errWrongLastFilter
 	}
 	return nil
 }
@@ -135,26 +350,65 @@ func nopWriteCloser(w io.Writer) io.WriteCloser {
 	return nopWCloser{w}
 }
 
-// Writer compresses data written to it. It is an io.WriteCloser.
+// Writer compresses data written to it. It is an io.WriteCloser. A
+// Writer is not safe for concurrent use; concurrent calls of its
+// methods panic. Use NewSyncWriter to share a Writer between
+// goroutines. After an error all calls of Write, NextBlock and Close
+// return the first error.
+//
+// The data is compressed while it is written. Each LZMA2 chunk is
+// written to the underlying writer as soon as it is complete, so a
+// consumer receives compressed data long before the block is closed.
+// Set OutputBatchSize to combine the chunks into larger writes.
 type Writer struct {
 	WriterConfig
-
-	xz      io.Writer
+	guard useGuard
+
+	xz  io.Writer
+	cxz *countingWriter
+	ob  batchWriter
+	in  int64
+	// index of the next offset in BlockList
+	bl      int
 	bw      *blockWriter
 	newHash func() hash.Hash
 	h       header
 	index   []record
 	closed  bool
+	// checksum of the block provided to OnCompressedBlock
+	cb hash.Hash32
+	// first error returned to the caller
+	err error
+	// verifies the output if VerifyOutput is set
+	verifier *outputVerifier
+}
+
+// fail records the first error of the writer. The stream is corrupt
+// after an error, so the following calls return the error without
+// writing more data.
+func (w *Writer) fail(err error) error {
+	if err != nil && w.err == nil {
+		w.err = err
+		if w.verifier != nil {
+			w.verifier.abort(err)
+		}
+	}
+	return err
 }
 
 // newBlockWriter creates a new block writer writes the header out.
 func (w *Writer) newBlockWriter() error {
 	var err error
-	w.bw, err = w.WriterConfig.newBlockWriter(w.xz, w.newHash())
+	xz := w.xz
+	if w.OnCompressedBlock != nil {
+		w.cb = crc32.NewIEEE()
+		xz = io.MultiWriter(w.xz, w.cb)
+	}
+	w.bw, err = w.WriterConfig.newBlockWriter(xz, w.newHash())
 	if err != nil {
 		return err
 	}
-	if err = w.bw.writeHeader(w.xz); err != nil {
+	if err = w.bw.writeHeader(xz); err != nil {
 		return err
 	}
 	return nil
@@ -168,6 +422,9 @@ func (w *Writer) closeBlockWriter() error {
 		return err
 	}
 	w.index = append(w.index, w.bw.record())
+	if w.OnCompressedBlock != nil {
+		w.OnCompressedBlock(w.cb.Sum32())
+	}
 	return nil
 }
 
@@ -181,12 +438,40 @@ func (c WriterConfig) NewWriter(xz io.Writer) (w *Writer, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
+	var v *outputVerifier
+	if c.VerifyOutput {
+		v = newOutputVerifier(c.NoDictReset)
+		defer func() {
+			if err != nil {
+				v.abort(err)
+			}
+		}()
+		xz = io.MultiWriter(xz, v)
+	}
 	w = &Writer{
 		WriterConfig: c,
-		xz:           xz,
+		cxz:          &countingWriter{w: xz},
 		h:            header{c.CheckSum},
 		index:        make([]record, 0, 4),
+		verifier:     v,
 	}
+	w.chain = nil
+	if c.NoDictReset {
+		w.chain = new(dictChain)
+	}
+	switch {
+	case c.AdaptiveOutputBatch:
+		max := c.OutputBatchSize
+		if max == 0 {
+			max = maxAdaptiveBatch
+		}
+		w.ob = newAdaptiveBatchWriter(xz, minAdaptiveBatch, max)
+		w.cxz.w = w.ob
+	case c.OutputBatchSize > 0:
+		w.ob = bufio.NewWriterSize(xz, c.OutputBatchSize)
+		w.cxz.w = w.ob
+	}
+	w.xz = w.cxz
 	if w.newHash, err = newHashFunc(c.CheckSum); err != nil {
 		return nil, err
 	}
@@ -194,7 +479,7 @@ func (c WriterConfig) NewWriter(xz io.Writer) (w *Writer, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("w.h.MarshalBinary(): error %w", err)
 	}
-	if _, err = xz.Write(data); err != nil {
+	if _, err = w.xz.Write(data); err != nil {
 		return nil, err
 	}
 	if err = w.newBlockWriter(); err != nil {
@@ -204,36 +489,108 @@ func (c WriterConfig) NewWriter(xz io.Writer) (w *Writer, err error) {
 
 }
 
+// progress calls the Progress function if it has been provided.
+func (w *Writer) progress() {
+	if w.Progress != nil {
+		w.Progress(w.in, w.cxz.n)
+	}
+}
+
 // Write compresses the uncompressed data provided.
 func (w *Writer) Write(p []byte) (n int, err error) {
+	w.guard.enter("Writer.Write")
+	defer w.guard.exit()
+	if w.err != nil {
+		return 0, w.err
+	}
 	if w.closed {
 		return 0, errClosed
 	}
-	for {
-		k, err := w.bw.Write(p[n:])
+	defer func() {
+		if w.verifier != nil {
+			w.verifier.in.Write(p[:n])
+		}
+		w.in += int64(n)
+		w.progress()
+	}()
+	for n < len(p) {
+		if w.bw == nil {
+			if err = w.newBlockWriter(); err != nil {
+				return n, w.fail(err)
+			}
+		}
+		q := p[n:]
+		boundary := false
+		if w.bl < len(w.BlockList) {
+			d := w.BlockList[w.bl] - (w.in + int64(n))
+			if d <= int64(len(q)) {
+				q, boundary = q[:d], true
+			}
+		}
+		k, err := w.bw.Write(q)
 		n += k
-		if err != errNoSpace {
-			return n, err
+		if boundary && k == len(q) {
+			w.bl++
 		}
-		if err = w.closeBlockWriter(); err != nil {
-			return n, err
+		switch {
+		case err == errNoSpace:
+		case err != nil:
+			return n, w.fail(err)
+		case !boundary || w.bw.n == 0:
+			continue
 		}
-		if err = w.newBlockWriter(); err != nil {
-			return n, err
+		if err = w.closeBlockWriter(); err != nil {
+			return n, w.fail(err)
 		}
+		w.bw = nil
 	}
+	return n, nil
+}
+
+// NextBlock finalizes the current block, so the next data written
+// starts a new block, which can be used for seeking. The call is
+// ignored if no data has been written to the current block.
+func (w *Writer) NextBlock() error {
+	w.guard.enter("Writer.NextBlock")
+	defer w.guard.exit()
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errClosed
+	}
+	if w.bw == nil || w.bw.n == 0 {
+		return nil
+	}
+	if err := w.closeBlockWriter(); err != nil {
+		return w.fail(err)
+	}
+	w.bw = nil
+	return nil
 }
 
 // Close closes the writer and adds the footer to the Writer. Close
 // doesn't close the underlying writer.
 func (w *Writer) Close() error {
+	w.guard.enter("Writer.Close")
+	defer w.guard.exit()
+	if w.err != nil {
+		return w.err
+	}
 	if w.closed {
 		return errClosed
 	}
 	w.closed = true
+	return w.fail(w.close())
+}
+
+// close writes the last block, the index and the footer.
+func (w *Writer) close() error {
 	var err error
-	if err = w.closeBlockWriter(); err != nil {
-		return err
+	if w.bw != nil {
+		if err = w.closeBlockWriter(); err != nil {
+			return err
+		}
 	}
 
 	f := footer{flags: w.h.flags}
@@ -247,6 +604,24 @@ func (w *Writer) Close() error {
 	if _, err = w.xz.Write(data); err != nil {
 		return err
 	}
+	if len(w.Metadata) > 0 {
+		if err = w.writeMetadata(w.xz, w.Metadata); err != nil {
+			return err
+		}
+	}
+	if w.ob != nil {
+		if err = w.ob.Flush(); err != nil {
+			return err
+		}
+	}
+	if w.verifier != nil {
+		v := w.verifier
+		w.verifier = nil
+		if err = v.close(); err != nil {
+			return err
+		}
+	}
+	w.progress()
 	return nil
 }
 
@@ -293,6 +668,9 @@ func (c *WriterConfig) newBlockWriter(xz io.Writer, hash hash.Hash) (bw *blockWr
 	if err != nil {
 		return nil, err
 	}
+	if c.encoderFault != nil {
+		bw.w = c.encoderFault(bw.w)
+	}
 	if bw.hash.Size() != 0 {
 		bw.mw = io.MultiWriter(bw.w, bw.hash)
 	} else {
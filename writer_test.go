@@ -6,13 +6,18 @@ package xz
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/ulikunitz/xz/internal/randtxt"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 func TestWriter(t *testing.T) {
@@ -201,3 +206,640 @@ func BenchmarkWriter(b *testing.B) {
 	}
 	b.ReportMetric(float64(buf.Len())/float64(len(data)), "rate")
 }
+
+func TestWriterTimeBudget(t *testing.T) {
+	const budget = time.Second
+	small := WriterConfig{TimeBudget: budget, SizeHint: 1 << 10}
+	if err := small.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	large := WriterConfig{TimeBudget: budget, SizeHint: 1 << 30}
+	if err := large.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	if small.Matcher != lzma.BinaryTree {
+		t.Fatalf("small input selects %v; want %v",
+			small.Matcher, lzma.BinaryTree)
+	}
+	if large.Matcher != lzma.HashTable4 {
+		t.Fatalf("large input selects %v; want %v",
+			large.Matcher, lzma.HashTable4)
+	}
+	neg := WriterConfig{TimeBudget: -budget}
+	if err := neg.Verify(); err == nil {
+		t.Fatal("Verify accepted negative time budget")
+	}
+}
+
+func TestWriterDictCapRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := WriterConfig{DictCap: 5000000}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if w.DictCap != 3<<21 {
+		t.Fatalf("w.DictCap is %d; want %d", w.DictCap, 3<<21)
+	}
+	bh, _, err := readBlockHeader(bytes.NewReader(buf.Bytes()[HeaderLen:]))
+	if err != nil {
+		t.Fatalf("readBlockHeader error %s", err)
+	}
+	f := bh.filters[0].(*lzmaFilter)
+	if f.dictCap != int64(w.DictCap) {
+		t.Fatalf("filter dictionary capacity %d; want %d",
+			f.dictCap, w.DictCap)
+	}
+}
+
+func TestWriterProgress(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(19)), 100000)
+
+	var calls int
+	var in, out int64
+	cfg := WriterConfig{
+		Progress: func(inBytes, outBytesEstimate int64) {
+			calls++
+			if inBytes < in || outBytesEstimate < out {
+				t.Errorf("progress values decreased")
+			}
+			in, out = inBytes, outBytesEstimate
+		},
+	}
+	var buf bytes.Buffer
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.Copy(w, &txt); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if calls < 2 {
+		t.Fatalf("Progress called %d times; want at least 2", calls)
+	}
+	if in != 100000 {
+		t.Fatalf("final inBytes %d; want %d", in, 100000)
+	}
+	if out != int64(buf.Len()) {
+		t.Fatalf("final outBytesEstimate %d; want %d", out, buf.Len())
+	}
+}
+
+func TestWriterBlockSizeDeterministic(t *testing.T) {
+	const (
+		txtlen    = 100000
+		blockSize = 16 * 1024
+	)
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(9)), txtlen)
+	data := txt.Bytes()
+	xz := compressBlocks(t, data, blockSize)
+	if !bytes.Equal(xz, compressBlocks(t, data, blockSize)) {
+		t.Fatalf("output of the second compression differs")
+	}
+	blocks, err := readIndex(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("readIndex error %s", err)
+	}
+	if n := (txtlen + blockSize - 1) / blockSize; len(blocks) != n {
+		t.Fatalf("got %d blocks; want %d", len(blocks), n)
+	}
+	var u int64
+	for i, b := range blocks {
+		if b.uncompressedOffset != u {
+			t.Fatalf("block %d has uncompressed offset %d; want %d",
+				i, b.uncompressedOffset, u)
+		}
+		size := int64(blockSize)
+		if i == len(blocks)-1 {
+			size = txtlen - u
+		}
+		if b.rec.uncompressedSize != size {
+			t.Fatalf("block %d has uncompressed size %d; want %d",
+				i, b.rec.uncompressedSize, size)
+		}
+		u += size
+	}
+}
+
+// writeCounter counts the calls of the Write method.
+type writeCounter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (n int, err error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+// compressCounted compresses data with the given batch size and returns
+// the writer receiving the compressed data.
+func compressCounted(tb testing.TB, data []byte, batchSize int,
+) *writeCounter {
+	wc := new(writeCounter)
+	w, err := WriterConfig{OutputBatchSize: batchSize}.NewWriter(wc)
+	if err != nil {
+		tb.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		tb.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		tb.Fatalf("w.Close error %s", err)
+	}
+	return wc
+}
+
+func TestWriterOutputBatchSize(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(13)), 200000)
+	direct := compressCounted(t, txt.Bytes(), 0)
+	batched := compressCounted(t, txt.Bytes(), 64*1024)
+	t.Logf("writes direct %d; batched %d", direct.writes,
+		batched.writes)
+	if !bytes.Equal(direct.Bytes(), batched.Bytes()) {
+		t.Fatalf("batched output differs")
+	}
+	if batched.writes >= direct.writes {
+		t.Fatalf("batched writes %d; want less than %d",
+			batched.writes, direct.writes)
+	}
+}
+
+func TestWriterBufferOutput(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(80)), 200000)
+	counts := make(map[bool]*writeCounter)
+	for _, buffered := range []bool{false, true} {
+		wc := new(writeCounter)
+		w, err := WriterConfig{BufferOutput: buffered}.NewWriter(wc)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt.Bytes()); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		counts[buffered] = wc
+	}
+	t.Logf("writes unbuffered %d; buffered %d", counts[false].writes,
+		counts[true].writes)
+	if !bytes.Equal(counts[false].Bytes(), counts[true].Bytes()) {
+		t.Fatal("buffered output differs")
+	}
+	max := counts[true].Len()/defaultOutputBatch + 1
+	if counts[true].writes > max {
+		t.Fatalf("buffered writes %d; want at most %d",
+			counts[true].writes, max)
+	}
+	if counts[true].writes >= counts[false].writes {
+		t.Fatalf("buffered writes %d; want less than %d",
+			counts[true].writes, counts[false].writes)
+	}
+}
+
+func BenchmarkWriterOutputBatchSize(b *testing.B) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(13)), 1<<20)
+	data := txt.Bytes()
+	for _, batchSize := range []int{0, 64 * 1024} {
+		b.Run(fmt.Sprintf("batch%d", batchSize), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			var writes int
+			for i := 0; i < b.N; i++ {
+				writes += compressCounted(b, data,
+					batchSize).writes
+			}
+			b.ReportMetric(float64(writes)/float64(b.N),
+				"writes/op")
+		})
+	}
+}
+
+func TestWriterNextBlock(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(17)), 50000)
+	data := txt.Bytes()
+	boundaries := []int{0, 1000, 1000, 17000, 42000, len(data)}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	for i := 1; i < len(boundaries); i++ {
+		if _, err = w.Write(data[boundaries[i-1]:boundaries[i]]); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.NextBlock(); err != nil {
+			t.Fatalf("w.NextBlock error %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if err = w.NextBlock(); err != errClosed {
+		t.Fatalf("w.NextBlock after Close returned %v; want %v",
+			err, errClosed)
+	}
+	xz := buf.Bytes()
+	blocks, err := readIndex(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("readIndex error %s", err)
+	}
+	// The empty block between the two calls at offset 1000 is
+	// skipped.
+	want := []int64{0, 1000, 17000, 42000}
+	if len(blocks) != len(want) {
+		t.Fatalf("got %d blocks; want %d", len(blocks), len(want))
+	}
+	for i, b := range blocks {
+		if b.uncompressedOffset != want[i] {
+			t.Fatalf("block %d starts at %d; want %d", i,
+				b.uncompressedOffset, want[i])
+		}
+	}
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
+
+func TestWriterNextBlockWithoutData(t *testing.T) {
+	data := []byte("The quick brown fox jumps over the lazy dog.\n")
+	want, err := compressData(data, WriterConfig{})
+	if err != nil {
+		t.Fatalf("compressData error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = w.NextBlock(); err != nil {
+			t.Fatalf("w.NextBlock error %s", err)
+		}
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.NextBlock(); err != nil {
+		t.Fatalf("w.NextBlock error %s", err)
+	}
+	if err = w.NextBlock(); err != nil {
+		t.Fatalf("w.NextBlock error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("NextBlock without data changed the output")
+	}
+}
+
+func TestWriterBlockList(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(41)), 50000)
+	data := txt.Bytes()
+	blockList := []int64{1000, 1500, 20000, 45000}
+	tests := []struct {
+		blockSize int64
+		want      []int64
+	}{
+		{0, []int64{0, 1000, 1500, 20000, 45000}},
+		{10000, []int64{0, 1000, 1500, 11500, 20000, 30000, 40000,
+			45000}},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		w, err := WriterConfig{
+			BlockSize: tc.blockSize,
+			BlockList: blockList,
+		}.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		for p := data; len(p) > 0; {
+			n := 777
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err = w.Write(p[:n]); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			p = p[n:]
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		xz := buf.Bytes()
+		blocks, err := readIndex(bytes.NewReader(xz), int64(len(xz)))
+		if err != nil {
+			t.Fatalf("readIndex error %s", err)
+		}
+		if len(blocks) != len(tc.want) {
+			t.Fatalf("got %d blocks; want %d", len(blocks),
+				len(tc.want))
+		}
+		for i, b := range blocks {
+			if b.uncompressedOffset != tc.want[i] {
+				t.Fatalf("block %d starts at %d; want %d", i,
+					b.uncompressedOffset, tc.want[i])
+			}
+		}
+		r, err := NewReader(bytes.NewReader(xz))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("decompressed data differs from original")
+		}
+	}
+
+	for _, bl := range [][]int64{{0}, {-1}, {100, 100}, {200, 100}} {
+		c := WriterConfig{BlockList: bl}
+		if err := c.Verify(); err == nil {
+			t.Errorf("Verify accepted block list %v", bl)
+		}
+	}
+}
+
+func TestWriterSizeHintDictCap(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(29)), 100*1024)
+	data := txt.Bytes()
+	var buf bytes.Buffer
+	w, err := WriterConfig{SizeHint: int64(len(data))}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	xz := buf.Bytes()
+	bh, _, err := readBlockHeader(bytes.NewReader(xz[HeaderLen:]))
+	if err != nil {
+		t.Fatalf("readBlockHeader error %s", err)
+	}
+	f := bh.filters[0].(*lzmaFilter)
+	if want := lzma.NearestDictCap(int64(len(data))); f.dictCap != want {
+		t.Fatalf("dictionary capacity %d; want %d", f.dictCap, want)
+	}
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
+
+// slowWriter simulates a slow underlying writer.
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (w slowWriter) Write(p []byte) (n int, err error) {
+	time.Sleep(w.delay)
+	return len(p), nil
+}
+
+func TestWriterAdaptiveOutputBatch(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(51)), 256*1024)
+	batchSize := func(xz io.Writer) int {
+		w, err := WriterConfig{AdaptiveOutputBatch: true}.NewWriter(xz)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt.Bytes()); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return w.ob.(*adaptiveBatchWriter).size
+	}
+	fast := batchSize(ioutil.Discard)
+	slow := batchSize(slowWriter{10 * time.Millisecond})
+	t.Logf("batch size fast sink %d; slow sink %d", fast, slow)
+	if fast <= minAdaptiveBatch {
+		t.Fatalf("batch size %d for fast sink didn't grow", fast)
+	}
+	if slow != minAdaptiveBatch {
+		t.Fatalf("batch size %d for slow sink; want %d", slow,
+			minAdaptiveBatch)
+	}
+}
+
+// failingWriter accepts n bytes and fails afterwards.
+type failingWriter struct {
+	n     int
+	calls int
+}
+
+var errFailingWriter = errors.New("failingWriter: write error")
+
+func (w *failingWriter) Write(p []byte) (n int, err error) {
+	w.calls++
+	if len(p) > w.n {
+		n = w.n
+		w.n = 0
+		return n, errFailingWriter
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriterLatchesError(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(59)), 100000)
+	fw := &failingWriter{n: 1000}
+	w, err := WriterConfig{BlockSize: 4096}.NewWriter(fw)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != errFailingWriter {
+		t.Fatalf("w.Write returned error %v; want %v", err,
+			errFailingWriter)
+	}
+	calls := fw.calls
+	if _, err = w.Write(txt.Bytes()); err != errFailingWriter {
+		t.Fatalf("second w.Write returned error %v; want %v", err,
+			errFailingWriter)
+	}
+	if err = w.NextBlock(); err != errFailingWriter {
+		t.Fatalf("w.NextBlock returned error %v; want %v", err,
+			errFailingWriter)
+	}
+	for i := 0; i < 2; i++ {
+		if err = w.Close(); err != errFailingWriter {
+			t.Fatalf("w.Close returned error %v; want %v", err,
+				errFailingWriter)
+		}
+	}
+	if fw.calls != calls {
+		t.Fatalf("%d writes after the error", fw.calls-calls)
+	}
+}
+
+// progressWriter records the number of bytes written to it.
+type progressWriter struct {
+	n int64
+}
+
+func (w *progressWriter) Write(p []byte) (n int, err error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestWriterStreamsChunks(t *testing.T) {
+	const (
+		size  = 4 << 20
+		piece = 64 << 10
+	)
+	var txt bytes.Buffer
+	if _, err := io.CopyN(&txt, randtxt.NewReader(rand.NewSource(61)),
+		size); err != nil {
+		t.Fatalf("io.CopyN error %s", err)
+	}
+	data := txt.Bytes()
+	pw := &progressWriter{}
+	w, err := NewWriter(pw)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	// stream header and block header
+	headers := pw.n
+	first := int64(-1)
+	for i := 0; i < len(data); i += piece {
+		if _, err = w.Write(data[i : i+piece]); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if first < 0 && pw.n > headers {
+			first = int64(i + piece)
+		}
+	}
+	before := pw.n
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	t.Logf("first output after %d bytes; %d of %d bytes before Close",
+		first, before, pw.n)
+	if !(0 < first && first <= size/8) {
+		t.Fatalf("first compressed chunk written after %d bytes",
+			first)
+	}
+	if before < pw.n*9/10 {
+		t.Fatalf("only %d of %d bytes written before Close", before,
+			pw.n)
+	}
+}
+
+func TestWriterNoDictReset(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(67)), 200000)
+	orig := txt.Bytes()
+
+	compress := func(noDictReset bool) []byte {
+		var buf bytes.Buffer
+		w, err := WriterConfig{
+			BlockSize:    8 * 1024,
+			NoDictReset:  noDictReset,
+			VerifyOutput: true,
+		}.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(orig); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return buf.Bytes()
+	}
+	standard := compress(false)
+	chained := compress(true)
+	t.Logf("standard %d bytes; chained %d bytes", len(standard),
+		len(chained))
+	if len(chained) >= len(standard) {
+		t.Fatalf("chained blocks need %d bytes; want less than %d",
+			len(chained), len(standard))
+	}
+
+	// A default reader rejects the blocks without dictionary reset.
+	r, err := NewReader(bytes.NewReader(chained))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err == nil {
+		t.Fatal("default reader decoded chained blocks")
+	}
+
+	rcfg := ReaderConfig{NoDictReset: true}
+	for _, xz := range [][]byte{standard, chained} {
+		r, err := rcfg.NewReader(bytes.NewReader(xz))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, orig) {
+			t.Fatal("decompressed data differs from original")
+		}
+	}
+
+	// The blocks of the chained file cannot be decoded on their own.
+	out := make(sliceWriterAt, len(orig))
+	err = DecompressToWriterAt(out, bytes.NewReader(chained),
+		int64(len(chained)), ReaderConfig{})
+	if err == nil {
+		t.Fatal("DecompressToWriterAt decoded chained blocks")
+	}
+	t.Logf("expected error %s", err)
+	rc, err := DecodeRange(bytes.NewReader(chained), int64(len(chained)),
+		100000, 1000)
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, rc)
+	}
+	if err == nil {
+		t.Fatal("DecodeRange decoded a chained block")
+	}
+
+	err = CompressSeekable(ioutil.Discard, bytes.NewReader(orig),
+		int64(len(orig)), WriterConfig{NoDictReset: true})
+	if err != errSeekableNoDictReset {
+		t.Fatalf("CompressSeekable returned %v; want %v", err,
+			errSeekableNoDictReset)
+	}
+}
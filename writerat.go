@@ -0,0 +1,450 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// blockInfo describes the position of a block in an xz file.
+type blockInfo struct {
+	// offset of the block header in the xz file
+	offset int64
+	// offset of the uncompressed data of the block
+	uncompressedOffset int64
+	// index record of the block
+	rec record
+	// check flags of the stream
+	flags byte
+}
+
+// paddedSize returns the unpadded size of the record rounded up to a
+// multiple of four.
+func (rec record) paddedSize() int64 {
+	return rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+}
+
+// readStreamIndex reads the footer and the index of the xz stream ending
+// at the offset end. It returns the block information and the offset
+// of the stream header. The uncompressed offsets of the blocks are
+// relative to the start of the stream.
+func readStreamIndex(ra io.ReaderAt, end int64) (blocks []blockInfo,
+	start int64, err error) {
+
+	if end < HeaderLen+footerLen {
+		return nil, 0, errors.New("xz: file too short")
+	}
+	p := make([]byte, footerLen)
+	if _, err = ra.ReadAt(p, end-footerLen); err != nil {
+		return nil, 0, err
+	}
+	var f footer
+	if err = f.UnmarshalBinary(p); err != nil {
+		return nil, 0, err
+	}
+	indexStart := end - footerLen - f.indexSize
+	if indexStart < HeaderLen {
+		return nil, 0, errors.New("xz: index size in footer wrong")
+	}
+	ir := io.NewSectionReader(ra, indexStart, f.indexSize)
+	if _, err = io.ReadFull(ir, p[:1]); err != nil {
+		return nil, 0, err
+	}
+	if p[0] != 0 {
+		return nil, 0, errors.New("xz: no index indicator")
+	}
+	// readIndexBody requires the number of records, so we read it
+	// first and rewind.
+	u, _, err := readUvarint(lzma.ByteReader(ir))
+	if err != nil {
+		return nil, 0, err
+	}
+	if u > uint64(f.indexSize) {
+		return nil, 0, errors.New("xz: record number overflow")
+	}
+	if _, err = ir.Seek(1, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	recLen, err := intSize(u)
+	if err != nil {
+		return nil, 0, err
+	}
+	index, n, err := readIndexBody(ir, recLen)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n+1 != f.indexSize {
+		return nil, 0, errors.New("xz: index size in footer wrong")
+	}
+
+	var c, uoff int64
+	blocks = make([]blockInfo, len(index))
+	for i, rec := range index {
+		blocks[i] = blockInfo{
+			offset:             c,
+			uncompressedOffset: uoff,
+			rec:                rec,
+			flags:              f.flags,
+		}
+		if c, err = addSize(c, rec.paddedSize()); err != nil {
+			return nil, 0, err
+		}
+		uoff, err = addSize(uoff, rec.uncompressedSize)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	start = indexStart - c - HeaderLen
+	if start < 0 {
+		return nil, 0, errors.New("xz: index inconsistent with file")
+	}
+	p = p[:HeaderLen]
+	if _, err = ra.ReadAt(p, start); err != nil {
+		return nil, 0, err
+	}
+	var h header
+	if err = h.UnmarshalBinary(p); err != nil {
+		return nil, 0, err
+	}
+	if h.flags != f.flags {
+		return nil, 0, errors.New("xz: footer flags incorrect")
+	}
+	for i := range blocks {
+		blocks[i].offset += start + HeaderLen
+	}
+	return blocks, start, nil
+}
+
+// readIndex reads the indexes of all streams in the xz file of the
+// given size. Stream padding between the streams is skipped. The
+// uncompressed offsets of the blocks are relative to the start of the
+// file.
+func readIndex(ra io.ReaderAt, size int64) (blocks []blockInfo,
+	err error) {
+
+	var streams [][]blockInfo
+	end := size
+	p := make([]byte, 4)
+	for end > 0 {
+		if end%4 != 0 {
+			return nil, errors.New("xz: file size not aligned")
+		}
+		if _, err = ra.ReadAt(p, end-4); err != nil {
+			return nil, err
+		}
+		if allZeros(p) {
+			end -= 4
+			continue
+		}
+		b, start, err := readStreamIndex(ra, end)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, b)
+		end = start
+	}
+	if len(streams) == 0 {
+		return nil, errors.New("xz: no stream found")
+	}
+	var uoff int64
+	for i := len(streams) - 1; i >= 0; i-- {
+		for _, b := range streams[i] {
+			b.uncompressedOffset = uoff
+			blocks = append(blocks, b)
+			uoff, err = addSize(uoff, b.rec.uncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// offsetWriter writes to an io.WriterAt starting at the given offset.
+type offsetWriter struct {
+	wa  io.WriterAt
+	off int64
+}
+
+// Write writes p at the current offset and advances it.
+func (w *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = w.wa.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// openBlock returns a reader for the uncompressed data of the block
+// described by b.
+func (c *ReaderConfig) openBlock(ra io.ReaderAt, b blockInfo) (
+	br *blockReader, err error) {
+
+	r := io.NewSectionReader(ra, b.offset, b.rec.paddedSize())
+	bh, hlen, err := readBlockHeader(r)
+	if err != nil {
+		if err == errIndexIndicator {
+			err = errors.New("xz: index indicator instead of block")
+		}
+		return nil, err
+	}
+	newHash, err := newHashFunc(b.flags)
+	if err != nil {
+		return nil, err
+	}
+	return c.newBlockReader(r, bh, hlen, newHash())
+}
+
+// decodeBlock decodes the block described by b and writes the
+// uncompressed data to wa. If out is not nil the data is written to it
+// as well.
+func (c *ReaderConfig) decodeBlock(wa io.WriterAt, ra io.ReaderAt,
+	b blockInfo, out io.Writer) error {
+
+	br, err := c.openBlock(ra, b)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = &offsetWriter{wa: wa, off: b.uncompressedOffset}
+	if out != nil {
+		w = io.MultiWriter(w, out)
+	}
+	if _, err = io.Copy(w, br); err != nil {
+		return err
+	}
+	if rec := br.record(); rec != b.rec {
+		return fmt.Errorf("xz: block record is %v; want %v",
+			rec, b.rec)
+	}
+	return nil
+}
+
+// hashSequencer feeds the blocks decoded concurrently to the hash
+// writers in the order of the uncompressed data.
+type hashSequencer struct {
+	w io.Writer
+	// turns[i] is closed after the blocks before block i have been
+	// hashed
+	turns []chan struct{}
+}
+
+// newHashSequencer creates a hash sequencer for n blocks.
+func newHashSequencer(hashes []hash.Hash, n int) *hashSequencer {
+	s := &hashSequencer{
+		w:     hashWriter(hashes),
+		turns: make([]chan struct{}, n+1),
+	}
+	for i := range s.turns {
+		s.turns[i] = make(chan struct{})
+	}
+	close(s.turns[0])
+	return s
+}
+
+// maxHashPending limits the data of a block buffered by a
+// blockHashWriter before it is the turn of the block.
+var maxHashPending = 1 << 20
+
+// blockHashWriter provides the data of block i to the hash sequencer.
+// The data is hashed directly if it is the turn of the block. Before
+// that at most maxHashPending bytes are buffered; then Write waits for
+// the turn of the block, which stops its decoding.
+type blockHashWriter struct {
+	s    *hashSequencer
+	i    int
+	turn bool
+	buf  bytes.Buffer
+}
+
+// block returns the writer for the data of block i.
+func (s *hashSequencer) block(i int) *blockHashWriter {
+	return &blockHashWriter{s: s, i: i}
+}
+
+// wait waits for the turn of the block and hashes the buffered data.
+func (w *blockHashWriter) wait() {
+	<-w.s.turns[w.i]
+	w.turn = true
+	w.s.w.Write(w.buf.Bytes())
+	w.buf = bytes.Buffer{}
+}
+
+// Write hashes p or buffers it until it is the turn of the block.
+func (w *blockHashWriter) Write(p []byte) (n int, err error) {
+	if !w.turn {
+		select {
+		case <-w.s.turns[w.i]:
+			w.wait()
+		default:
+			if w.buf.Len()+len(p) <= maxHashPending {
+				return w.buf.Write(p)
+			}
+			w.wait()
+		}
+	}
+	return w.s.w.Write(p)
+}
+
+// close passes the turn to the next block. It must be called for every
+// block started, even if its decoding failed.
+func (w *blockHashWriter) close() {
+	if !w.turn {
+		w.wait()
+	}
+	close(w.s.turns[w.i+1])
+}
+
+// rampUp waits before the launch of goroutine i if it is one of the
+// first n goroutines, so that they are started one after another
+// separated by delay. It returns false if done has been closed while
+// waiting.
+func rampUp(i, n int, delay time.Duration, done <-chan struct{}) bool {
+	if delay <= 0 || i == 0 || i >= n {
+		return true
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// autoInFlight estimates the parallelism of the blocks. The blocks
+// can't be decoded faster than the largest block, so the result is the
+// ratio of the total uncompressed size to the size of the largest
+// block rounded up, limited by the number of blocks and by max.
+func autoInFlight(blocks []blockInfo, max int) int {
+	var total, largest int64
+	for _, b := range blocks {
+		n := b.rec.uncompressedSize
+		total += n
+		if n > largest {
+			largest = n
+		}
+	}
+	k := len(blocks)
+	if largest > 0 {
+		if p := (total + largest - 1) / largest; p < int64(k) {
+			k = int(p)
+		}
+	}
+	if k > max {
+		k = max
+	}
+	if k < 1 {
+		k = 1
+	}
+	return k
+}
+
+// DecompressToWriterAt decompresses the xz file provided by ra and
+// size and writes the uncompressed data to wa. The blocks are located
+// using the indexes of the streams and decompressed concurrently. Each
+// block is written directly to its offset in wa. The number of blocks
+// decoded at the same time is limited by cfg.MaxInFlight, which bounds
+// the memory required for the dictionaries. The index records the size
+// of every block, so the reader doesn't need to know the BlockSize used
+// by the writer; every file with more than one block can be decoded in
+// parallel.
+func DecompressToWriterAt(wa io.WriterAt, ra io.ReaderAt, size int64,
+	cfg ReaderConfig) error {
+
+	if err := cfg.Verify(); err != nil {
+		return err
+	}
+	blocks, err := readIndex(ra, size)
+	if err != nil {
+		return err
+	}
+
+	inFlight := cfg.MaxInFlight
+	if cfg.AutoInFlight {
+		inFlight = autoInFlight(blocks, inFlight)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		seq      *hashSequencer
+	)
+	if len(cfg.HashWriters) > 0 {
+		seq = newHashSequencer(cfg.HashWriters, len(blocks))
+	}
+	sem := make(chan struct{}, inFlight)
+	for i, b := range blocks {
+		rampUp(i, inFlight, cfg.WorkerLaunchDelay, nil)
+		sem <- struct{}{}
+		mu.Lock()
+		stop := firstErr != nil
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int, b blockInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// A block waiting for its turn keeps its slot, which
+			// limits the data buffered for the hashes.
+			var out io.Writer
+			if seq != nil {
+				hw := seq.block(i)
+				defer hw.close()
+				out = hw
+			}
+			err := cfg.decodeBlock(wa, ra, b, out)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i, b)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// VerifyCompressedBlocks compares the CRC-32 checksums of the
+// compressed blocks in the xz file with the checksums in sums, which
+// have been provided to the WriterConfig.OnCompressedBlock hook. The
+// blocks are not decompressed, so the function detects corrupted data
+// much faster than a full decode. The function supports multiple
+// streams.
+func VerifyCompressedBlocks(xz io.ReaderAt, size int64, sums []uint32) error {
+	blocks, err := readIndex(xz, size)
+	if err != nil {
+		return err
+	}
+	if len(blocks) != len(sums) {
+		return fmt.Errorf("xz: file has %d blocks; got %d checksums",
+			len(blocks), len(sums))
+	}
+	for i, b := range blocks {
+		crc := crc32.NewIEEE()
+		r := io.NewSectionReader(xz, b.offset, b.rec.paddedSize())
+		if _, err = io.Copy(crc, r); err != nil {
+			return err
+		}
+		if crc.Sum32() != sums[i] {
+			return fmt.Errorf(
+				"xz: CRC-32 mismatch for compressed block %d", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// sliceWriterAt implements io.WriterAt for a preallocated byte slice.
+type sliceWriterAt []byte
+
+func (s sliceWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
+	if off+int64(len(p)) > int64(len(s)) {
+		return 0, io.ErrShortWrite
+	}
+	return copy(s[off:], p), nil
+}
+
+// compressBlocks compresses data using the given block size.
+func compressBlocks(t *testing.T, data []byte, blockSize int64) []byte {
+	var buf bytes.Buffer
+	w, err := WriterConfig{BlockSize: blockSize}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompressToWriterAt(t *testing.T) {
+	const txtlen = 200000
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(5)), txtlen)
+	orig := txt.Bytes()
+
+	// two streams separated by stream padding
+	xz := compressBlocks(t, orig[:txtlen/2], 16*1024)
+	xz = append(xz, 0, 0, 0, 0)
+	xz = append(xz, compressBlocks(t, orig[txtlen/2:], 10000)...)
+
+	out := make(sliceWriterAt, txtlen)
+	err := DecompressToWriterAt(out, bytes.NewReader(xz), int64(len(xz)),
+		ReaderConfig{})
+	if err != nil {
+		t.Fatalf("DecompressToWriterAt error %s", err)
+	}
+	if !bytes.Equal(out, orig) {
+		t.Fatal("decompressed data differs from original")
+	}
+
+	// corrupt a byte inside the first block
+	xz[HeaderLen+20] ^= 0xff
+	err = DecompressToWriterAt(out, bytes.NewReader(xz), int64(len(xz)),
+		ReaderConfig{})
+	if err == nil {
+		t.Fatal("DecompressToWriterAt didn't detect corruption")
+	}
+}
+
+// slowWriterAt delays every write and records the maximum number of
+// concurrent writes.
+type slowWriterAt struct {
+	sliceWriterAt
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (s *slowWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
+	s.mu.Lock()
+	s.current++
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	n, err = s.sliceWriterAt.WriteAt(p, off)
+	s.mu.Lock()
+	s.current--
+	s.mu.Unlock()
+	return n, err
+}
+
+func TestDecompressToWriterAtMaxInFlight(t *testing.T) {
+	const txtlen = 100000
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(6)), txtlen)
+	orig := txt.Bytes()
+	xz := compressBlocks(t, orig, 5000)
+
+	for _, maxInFlight := range []int{1, 3} {
+		out := &slowWriterAt{sliceWriterAt: make([]byte, txtlen)}
+		cfg := ReaderConfig{MaxInFlight: maxInFlight}
+		err := DecompressToWriterAt(out, bytes.NewReader(xz),
+			int64(len(xz)), cfg)
+		if err != nil {
+			t.Fatalf("DecompressToWriterAt error %s", err)
+		}
+		if !bytes.Equal(out.sliceWriterAt, orig) {
+			t.Fatal("decompressed data differs from original")
+		}
+		if out.max > maxInFlight {
+			t.Fatalf("%d concurrent writes; want at most %d",
+				out.max, maxInFlight)
+		}
+	}
+}
+
+func TestDecompressToWriterAtAutoInFlight(t *testing.T) {
+	const txtlen = 100000
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(8)), txtlen)
+	orig := txt.Bytes()
+
+	// one large block and two small blocks
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	for _, p := range [][]byte{orig[:90000], orig[90000:95000],
+		orig[95000:]} {
+		if _, err = w.Write(p); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.NextBlock(); err != nil {
+			t.Fatalf("w.NextBlock error %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	poor := buf.Bytes()
+
+	tests := []struct {
+		xz   []byte
+		max  int
+		want int
+	}{
+		{compressBlocks(t, orig, 5000), 8, 8},
+		{compressBlocks(t, orig, 5000), 32, 20},
+		{compressBlocks(t, orig, 0), 8, 1},
+		{poor, 8, 2},
+	}
+	for i, tc := range tests {
+		blocks, err := readIndex(bytes.NewReader(tc.xz),
+			int64(len(tc.xz)))
+		if err != nil {
+			t.Fatalf("readIndex error %s", err)
+		}
+		if n := autoInFlight(blocks, tc.max); n != tc.want {
+			t.Errorf("test %d: autoInFlight returned %d; want %d",
+				i, n, tc.want)
+		}
+	}
+
+	out := &slowWriterAt{sliceWriterAt: make([]byte, txtlen)}
+	cfg := ReaderConfig{MaxInFlight: 8, AutoInFlight: true}
+	err = DecompressToWriterAt(out, bytes.NewReader(poor),
+		int64(len(poor)), cfg)
+	if err != nil {
+		t.Fatalf("DecompressToWriterAt error %s", err)
+	}
+	if !bytes.Equal(out.sliceWriterAt, orig) {
+		t.Fatal("decompressed data differs from original")
+	}
+	if out.max > 2 {
+		t.Fatalf("%d concurrent writes; want at most 2", out.max)
+	}
+}
+
+// barrierWriterAt blocks every write until n writes are waiting or
+// the timeout has expired and records the maximum number of concurrent
+// writes.
+type barrierWriterAt struct {
+	sliceWriterAt
+	n       int
+	timeout time.Duration
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (b *barrierWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
+	b.mu.Lock()
+	b.current++
+	if b.current > b.max {
+		b.max = b.current
+	}
+	deadline := time.Now().Add(b.timeout)
+	for b.max < b.n && time.Now().Before(deadline) {
+		b.mu.Unlock()
+		time.Sleep(time.Millisecond)
+		b.mu.Lock()
+	}
+	b.current--
+	b.mu.Unlock()
+	return b.sliceWriterAt.WriteAt(p, off)
+}
+
+func TestMaxInFlightIndependentOfGOMAXPROCS(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	c := ReaderConfig{MaxInFlight: 8}
+	if err := c.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	if c.MaxInFlight != 8 {
+		t.Fatalf("Verify changed MaxInFlight to %d", c.MaxInFlight)
+	}
+	c = ReaderConfig{}
+	if err := c.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	if c.MaxInFlight != 1 {
+		t.Fatalf("default MaxInFlight %d; want GOMAXPROCS 1",
+			c.MaxInFlight)
+	}
+
+	const txtlen = 100000
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(66)), txtlen)
+	orig := txt.Bytes()
+	xz := compressBlocks(t, orig, 5000)
+	out := &barrierWriterAt{
+		sliceWriterAt: make([]byte, txtlen),
+		n:             8,
+		timeout:       5 * time.Second,
+	}
+	err := DecompressToWriterAt(out, bytes.NewReader(xz), int64(len(xz)),
+		ReaderConfig{MaxInFlight: 8})
+	if err != nil {
+		t.Fatalf("DecompressToWriterAt error %s", err)
+	}
+	if !bytes.Equal(out.sliceWriterAt, orig) {
+		t.Fatal("decompressed data differs from original")
+	}
+	if out.max != 8 {
+		t.Fatalf("%d blocks in flight; want 8", out.max)
+	}
+}
+
+func TestDecompressToWriterAtBlockSizeFromIndex(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	const txtlen = 100000
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(68)), txtlen)
+	orig := txt.Bytes()
+	for _, blockSize := range []int64{7000, 12345} {
+		xz := compressBlocks(t, orig, blockSize)
+		out := &barrierWriterAt{
+			sliceWriterAt: make([]byte, txtlen),
+			n:             4,
+			timeout:       5 * time.Second,
+		}
+		// The configuration doesn't mention the block size.
+		err := DecompressToWriterAt(out, bytes.NewReader(xz),
+			int64(len(xz)), ReaderConfig{})
+		if err != nil {
+			t.Fatalf("DecompressToWriterAt error %s", err)
+		}
+		if !bytes.Equal(out.sliceWriterAt, orig) {
+			t.Fatal("decompressed data differs from original")
+		}
+		if out.max != 4 {
+			t.Fatalf("block size %d: %d blocks in flight; want 4",
+				blockSize, out.max)
+		}
+	}
+}
+
+// timingWriterAt records the time of the first write into each
+// segment of the given size.
+type timingWriterAt struct {
+	sliceWriterAt
+	segment int64
+	mu      sync.Mutex
+	first   map[int64]time.Time
+}
+
+func (w *timingWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
+	w.mu.Lock()
+	i := off / w.segment
+	if _, ok := w.first[i]; !ok {
+		w.first[i] = time.Now()
+	}
+	w.mu.Unlock()
+	return w.sliceWriterAt.WriteAt(p, off)
+}
+
+func TestDecompressToWriterAtLaunchDelay(t *testing.T) {
+	const (
+		txtlen    = 20000
+		blockSize = 5000
+		delay     = 30 * time.Millisecond
+	)
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(73)), txtlen)
+	orig := txt.Bytes()
+	xz := compressBlocks(t, orig, blockSize)
+	out := &timingWriterAt{
+		sliceWriterAt: make([]byte, txtlen),
+		segment:       blockSize,
+		first:         make(map[int64]time.Time),
+	}
+	start := time.Now()
+	err := DecompressToWriterAt(out, bytes.NewReader(xz), int64(len(xz)),
+		ReaderConfig{MaxInFlight: 4, WorkerLaunchDelay: delay})
+	if err != nil {
+		t.Fatalf("DecompressToWriterAt error %s", err)
+	}
+	if !bytes.Equal(out.sliceWriterAt, orig) {
+		t.Fatal("decompressed data differs from original")
+	}
+	for i := int64(0); i < txtlen/blockSize; i++ {
+		d := out.first[i].Sub(start)
+		if d < time.Duration(i)*delay {
+			t.Fatalf("block %d written after %v; want at least %v",
+				i, d, time.Duration(i)*delay)
+		}
+	}
+}
+
+// indexOnlyStream returns a stream consisting of a header, the index
+// with the given records and the footer. The blocks are missing.
+func indexOnlyStream(t *testing.T, index []record) []byte {
+	var buf bytes.Buffer
+	h := header{flags: CRC32}
+	data, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	buf.Write(data)
+	f := footer{flags: h.flags}
+	if f.indexSize, err = writeIndex(&buf, index); err != nil {
+		t.Fatalf("writeIndex error %s", err)
+	}
+	if data, err = f.MarshalBinary(); err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestReadIndexSizeOverflow(t *testing.T) {
+	tests := [][]record{
+		{{16, 1 << 62}, {16, 1 << 62}},
+		{{1 << 62, 1}, {1 << 62, 1}},
+		{{maxInt64 - 1, 1}},
+	}
+	for _, index := range tests {
+		xz := indexOnlyStream(t, index)
+		_, err := readIndex(bytes.NewReader(xz), int64(len(xz)))
+		if err != errSizeOverflow {
+			t.Errorf("index %v: readIndex error %v; want %v",
+				index, err, errSizeOverflow)
+		}
+	}
+}
+
+func TestVerifyCompressedBlocks(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(53)), 60000)
+	var sums []uint32
+	var buf bytes.Buffer
+	w, err := WriterConfig{
+		BlockSize: 16 * 1024,
+		OnCompressedBlock: func(crc uint32) {
+			sums = append(sums, crc)
+		},
+	}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if len(sums) != 4 {
+		t.Fatalf("OnCompressedBlock called %d times; want %d",
+			len(sums), 4)
+	}
+	xz := buf.Bytes()
+	if err = VerifyCompressedBlocks(bytes.NewReader(xz),
+		int64(len(xz)), sums); err != nil {
+		t.Fatalf("VerifyCompressedBlocks error %s", err)
+	}
+
+	// flip a byte in the compressed data of the second block
+	blocks, err := readIndex(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("readIndex error %s", err)
+	}
+	b := blocks[1]
+	xz[b.offset+b.rec.paddedSize()/2] ^= 0x01
+	err = VerifyCompressedBlocks(bytes.NewReader(xz), int64(len(xz)), sums)
+	if err == nil {
+		t.Fatalf("VerifyCompressedBlocks didn't detect the corruption")
+	}
+	t.Logf("expected error %s", err)
+}
@@ -0,0 +1,154 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"fmt"
+	"io"
+)
+
+// x86FilterID is the ID of the x86 BCJ filter.
+const x86FilterID = 0x04
+
+// x86Filter declares the x86 BCJ filter information stored in an xz
+// block header. The filter converts the relative targets of the CALL
+// and JMP instructions into absolute addresses, which improves the
+// compression of x86 executables.
+type x86Filter struct {
+	start uint32
+}
+
+// String returns a representation of the x86 filter.
+func (f x86Filter) String() string {
+	return fmt.Sprintf("x86 start offset %#x", f.start)
+}
+
+// id returns the ID for the x86 filter.
+func (f x86Filter) id() uint64 { return x86FilterID }
+
+// MarshalBinary converts the x86Filter in its encoded representation.
+func (f x86Filter) MarshalBinary() (data []byte, err error) {
+	return marshalBCJ(x86FilterID, f.start), nil
+}
+
+// UnmarshalBinary unmarshals the given data representation of the x86
+// filter.
+func (f *x86Filter) UnmarshalBinary(data []byte) error {
+	start, err := unmarshalBCJ(x86FilterID, "x86", 1, data)
+	if err != nil {
+		return err
+	}
+	f.start = start
+	return nil
+}
+
+// reader creates a new reader for the x86 filter.
+func (f x86Filter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
+	err error) {
+	return newBCJReader(r, newX86Coder(f.start, false)), nil
+}
+
+// writeCloser creates a io.WriteCloser for the x86 filter.
+func (f x86Filter) writeCloser(w io.WriteCloser, c *WriterConfig,
+) (fw io.WriteCloser, err error) {
+	return newBCJWriter(w, newX86Coder(f.start, true)), nil
+}
+
+// last returns false, because the x86 filter must be followed by
+// another filter.
+func (f x86Filter) last() bool { return false }
+
+// x86Coder implements the x86 BCJ conversion of XZ Utils. The state
+// consists of the position of the last E8 or E9 byte and a mask
+// describing the bytes following it, which may be spread over multiple
+// calls of code.
+type x86Coder struct {
+	ip       uint32
+	prevPos  uint32
+	prevMask uint32
+	encoder  bool
+}
+
+// newX86Coder creates an x86 coder for the data starting at the given
+// position.
+func newX86Coder(start uint32, encoder bool) *x86Coder {
+	return &x86Coder{ip: start, prevPos: start - 5, encoder: encoder}
+}
+
+// x86MSByte checks whether b is the most significant byte of a small
+// positive or negative displacement.
+func x86MSByte(b byte) bool { return b == 0 || b == 0xff }
+
+var (
+	x86AllowedMask = [8]bool{true, true, true, false, true, false,
+		false, false}
+	x86BitNumber = [8]uint32{0, 1, 2, 2, 3, 3, 3, 3}
+)
+
+// code converts the CALL (E8) and JMP (E9) instructions in p.
+func (c *x86Coder) code(p []byte) int {
+	if len(p) < 5 {
+		return 0
+	}
+	if c.ip-c.prevPos > 5 {
+		c.prevPos = c.ip - 5
+	}
+	limit := len(p) - 5
+	i := 0
+	for i <= limit {
+		b := p[i]
+		if b != 0xe8 && b != 0xe9 {
+			i++
+			continue
+		}
+		pos := c.ip + uint32(i)
+		offset := pos - c.prevPos
+		c.prevPos = pos
+		if offset > 5 {
+			c.prevMask = 0
+		} else {
+			for k := uint32(0); k < offset; k++ {
+				c.prevMask &= 0x77
+				c.prevMask <<= 1
+			}
+		}
+		b = p[i+4]
+		if !(x86MSByte(b) && x86AllowedMask[(c.prevMask>>1)&7] &&
+			c.prevMask>>1 < 0x10) {
+			i++
+			c.prevMask |= 1
+			if x86MSByte(b) {
+				c.prevMask |= 0x10
+			}
+			continue
+		}
+		src := uint32(p[i+1]) | uint32(p[i+2])<<8 |
+			uint32(p[i+3])<<16 | uint32(b)<<24
+		var dest uint32
+		for {
+			if c.encoder {
+				dest = src + (pos + 5)
+			} else {
+				dest = src - (pos + 5)
+			}
+			if c.prevMask == 0 {
+				break
+			}
+			k := x86BitNumber[c.prevMask>>1]
+			if !x86MSByte(byte(dest >> (24 - k*8))) {
+				break
+			}
+			src = dest ^ (1<<(32-k*8) - 1)
+		}
+		p[i+4] = ^byte((dest>>24)&1 - 1)
+		p[i+3] = byte(dest >> 16)
+		p[i+2] = byte(dest >> 8)
+		p[i+1] = byte(dest)
+		i += 5
+		c.prevMask = 0
+	}
+	c.ip += uint32(i)
+	return i
+}
@@ -0,0 +1,172 @@
+// Copyright 2014-2022 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+// x86Code generates n bytes resembling x86 machine code with many CALL
+// and JMP instructions.
+func x86Code(n int, seed int64) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	p := make([]byte, 0, n+5)
+	for len(p) < n {
+		switch rng.Intn(8) {
+		case 0:
+			// CALL or JMP with a near displacement
+			d := uint32(rng.Intn(1<<16) - 1<<15)
+			p = append(p, 0xe8+byte(rng.Intn(2)), byte(d),
+				byte(d>>8), byte(d>>16), byte(d>>24))
+		case 1:
+			// opcode followed by a small immediate
+			p = append(p, byte(rng.Intn(256)), byte(rng.Intn(16)),
+				0, 0, 0)
+		default:
+			p = append(p, byte(rng.Intn(64)))
+		}
+	}
+	return p[:n]
+}
+
+// bcjFixture returns the data of the fixture compressed by xz and the
+// data produced by the BCJ filter of xz, which is the input of its LZMA2
+// filter.
+func bcjFixture(t *testing.T, file string) (xz, filtered []byte) {
+	xz, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	r := bytes.NewReader(xz[HeaderLen:])
+	bh, _, err := readBlockHeader(r)
+	if err != nil {
+		t.Fatalf("readBlockHeader error %s", err)
+	}
+	if len(bh.filters) != 2 {
+		t.Fatalf("got %d filters; want %d", len(bh.filters), 2)
+	}
+	lr, err := bh.filters[1].reader(r, nil)
+	if err != nil {
+		t.Fatalf("LZMA2 reader error %s", err)
+	}
+	if filtered, err = ioutil.ReadAll(lr); err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	return xz, filtered
+}
+
+// bcjEncode encodes data with the filter writing it in chunks of the
+// given size.
+func bcjEncode(t *testing.T, f filter, data []byte, chunk int) []byte {
+	var buf bytes.Buffer
+	w, err := f.writeCloser(nopWriteCloser(&buf), nil)
+	if err != nil {
+		t.Fatalf("writeCloser error %s", err)
+	}
+	for p := data; len(p) > 0; {
+		k := chunk
+		if k > len(p) {
+			k = len(p)
+		}
+		if _, err = w.Write(p[:k]); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		p = p[k:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+// testBCJFixture decodes a fixture created by xz and compares the
+// output of the encoder with the filtered data of xz.
+func testBCJFixture(t *testing.T, file string, f filter, data []byte) {
+	xz, filtered := bcjFixture(t, file)
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decoded %s differs from original", file)
+	}
+	if bytes.Equal(filtered, data) {
+		t.Fatalf("filter of xz didn't change the data")
+	}
+	for _, chunk := range []int{1, 3, 1000, len(data)} {
+		if !bytes.Equal(bcjEncode(t, f, data, chunk), filtered) {
+			t.Fatalf("chunk size %d: encoded data differs from xz",
+				chunk)
+		}
+	}
+}
+
+// testBCJRoundTrip compresses data with the given writer configuration
+// and decompresses it reading a single byte at a time.
+func testBCJRoundTrip(t *testing.T, cfg WriterConfig, data []byte) {
+	xz, err := compressData(data, cfg)
+	if err != nil {
+		t.Fatalf("compressData error %s", err)
+	}
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(iotest.OneByteReader(r))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
+
+func TestX86FilterFixture(t *testing.T) {
+	testBCJFixture(t, "testdata/x86.xz", &x86Filter{}, x86Code(1<<14, 1))
+}
+
+func TestX86FilterRoundTrip(t *testing.T) {
+	data := x86Code(100000, 2)
+	testBCJRoundTrip(t, WriterConfig{X86: true}, data)
+	testBCJRoundTrip(t, WriterConfig{X86: true, BlockSize: 7000}, data)
+	// short data isn't converted at all
+	testBCJRoundTrip(t, WriterConfig{X86: true}, []byte{0xe8, 0, 0})
+}
+
+func TestX86FilterStartOffset(t *testing.T) {
+	f := &x86Filter{start: 0x1000}
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	var g x86Filter
+	if err = g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if g != *f {
+		t.Fatalf("got %v; want %v", g, *f)
+	}
+	code := x86Code(10000, 3)
+	enc := bcjEncode(t, f, code, 100)
+	r, err := g.reader(iotest.HalfReader(bytes.NewReader(enc)), nil)
+	if err != nil {
+		t.Fatalf("reader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, code) {
+		t.Fatalf("decoded data differs from original")
+	}
+}